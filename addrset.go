@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// addressSet publishes several of a host's global IPv6 addresses as one
+// AAAA record each, e.g. one per delegated prefix. Records are added and
+// removed as SLAAC addresses come and go.
+type addressSet struct {
+	Interface string     `json:"interface"`
+	Prefix    stringList `json:"prefix,omitempty"` // only addresses inside one of these
+	Count     int        `json:"count,omitempty"`  // at most this many, best first; 0 for all
+}
+
+func (a *addressSet) validate() error {
+	if a.Interface == "" {
+		return errors.New("addresses: interface is required")
+	}
+	if _, err := a.prefixes(); err != nil {
+		return fmt.Errorf("addresses: prefix: %w", err)
+	}
+	return nil
+}
+
+func (a *addressSet) prefixes() ([]netip.Prefix, error) {
+	return parsePrefixes(strings.Join(a.Prefix, ","))
+}
+
+// pick returns the addresses to publish, sorted.
+func (a *addressSet) pick() ([]string, error) {
+	ips, err := interfaceAddrs(a.Interface, "tcp6")
+	if err != nil {
+		return nil, err
+	}
+	prefixes, _ := a.prefixes()
+	var out []string
+	for _, ip := range ips {
+		addr, _ := netip.AddrFromSlice(ip)
+		if len(prefixes) > 0 && !slices.ContainsFunc(prefixes, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			continue
+		}
+		out = append(out, addr.String())
+		if len(out) == a.Count {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("interface %s has no IPv6 address in %s", a.Interface, strings.Join(a.Prefix, ", "))
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// reconcileSet makes the cfg.Type/cfg.Name records among recs hold exactly
+// the addresses in want: missing ones are created, ones no longer wanted
+// (and duplicates) are deleted.
+func reconcileSet(ctx context.Context, cfg Config, recs []DomainRecord, want []string, res *runResult) error {
+	matches := matchingRecords(recs, cfg.Type, cfg.Name)
+	have := map[string]DomainRecord{}
+	var stale []DomainRecord
+	for _, r := range matches {
+		ip := net.ParseIP(r.Data)
+		if ip == nil || !slices.Contains(want, ip.String()) {
+			stale = append(stale, r)
+			continue
+		}
+		if _, dup := have[ip.String()]; dup {
+			stale = append(stale, r)
+			continue
+		}
+		have[ip.String()] = r
+	}
+
+	res.Action = "unchanged"
+	res.explain(msgExplainSet, len(have), len(want), len(stale))
+	for _, ip := range want {
+		if r, ok := have[ip]; ok {
+			if res.ID == 0 {
+				res.ID = r.ID
+			}
+			continue
+		}
+		created, err := createRecord(ctx, cfg, ip)
+		if err != nil {
+			return withExitCode(5, fmt.Errorf("create record %s: %w", ip, err))
+		}
+		logm(msgRecordCreated, cfg.Name, cfg.Domain, ip, cfg.createTTL())
+		res.Action = "updated"
+		if res.ID == 0 {
+			res.ID = created.ID
+		}
+	}
+	for _, r := range stale {
+		if err := deleteRecord(ctx, cfg, r.ID); err != nil {
+			return withExitCode(6, fmt.Errorf("delete record id=%d: %w", r.ID, err))
+		}
+		logm(msgSetRemoved, cfg.Type, cfg.Name, cfg.Domain, r.ID, r.Data)
+		res.Action = "updated"
+	}
+	if len(matches) == 0 {
+		res.Action = "created"
+	}
+	if res.Action == "unchanged" {
+		logm(msgRecordSameIP)
+	}
+	if err := writeLastIP(stateFile(cfg), strings.Join(want, ",")); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+	return nil
+}
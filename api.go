@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runAPI implements `do-ddns api METHOD PATH [BODY]`: a raw DigitalOcean
+// API call with the updater's auth, retry and rate-limit handling, for
+// debugging.
+func runAPI(args []string) error {
+	fs := flag.NewFlagSet("api", flag.ExitOnError)
+	token := fs.String("token", os.Getenv("DO_TOKEN"), "DigitalOcean API token (or env DO_TOKEN)")
+	maxRetries := fs.Int("max-retries", envDefaultInt("MAX_RETRIES", 6), "Max retries (or env MAX_RETRIES)")
+	include := fs.Bool("i", false, "Print the HTTP status and response headers")
+	raw := fs.Bool("raw", false, "Print the response body as received instead of indenting JSON")
+	timeout := fs.Duration("timeout", 2*time.Minute, "Overall timeout, including retries")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: do-ddns api [flags] METHOD PATH [BODY | @file | -]")
+		fmt.Fprintln(os.Stderr, "  e.g. do-ddns api GET /domains/example.com/records/123")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		fs.Usage()
+		return withExitCode(2, errors.New("api: METHOD and PATH are required"))
+	}
+	if strings.TrimSpace(*token) == "" {
+		return withExitCode(2, errors.New("api: DO_TOKEN / --token is required"))
+	}
+	method := strings.ToUpper(fs.Arg(0))
+	url, err := apiURL(fs.Arg(1))
+	if err != nil {
+		return withExitCode(2, fmt.Errorf("api: %w", err))
+	}
+
+	var body []byte
+	if fs.NArg() == 3 {
+		if body, err = readBody(fs.Arg(2)); err != nil {
+			return withExitCode(2, fmt.Errorf("api: body: %w", err))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	cfg := Config{Token: *token, MaxRetries: *maxRetries}
+	data, status, hdr, reqErr := doRequest(ctx, cfg, method, url, body)
+
+	if *include && status != 0 {
+		fmt.Printf("HTTP %d\n", status)
+		keys := make([]string, 0, len(hdr))
+		for k := range hdr {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s: %s\n", k, strings.Join(hdr[k], ", "))
+		}
+		fmt.Println()
+	}
+	if len(data) > 0 {
+		var out bytes.Buffer
+		if *raw || json.Indent(&out, data, "", "  ") != nil {
+			out.Reset()
+			out.Write(data)
+		}
+		if !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteByte('\n')
+		}
+		os.Stdout.Write(out.Bytes())
+	}
+	if reqErr != nil {
+		return fmt.Errorf("api: %s %s: %w", method, url, reqErr)
+	}
+	return nil
+}
+
+// apiURL accepts a path relative to the API root, with or without the /v2
+// prefix, or a full URL on the API host (such as a links.pages.next value).
+// The token is sent with the request, so any other scheme or host is
+// refused.
+func apiURL(path string) (string, error) {
+	if strings.Contains(path, "://") {
+		u, err := url.Parse(path)
+		if err != nil {
+			return "", err
+		}
+		base, _ := url.Parse(apiBase)
+		if u.Scheme != base.Scheme || !strings.EqualFold(u.Host, base.Host) || u.User != nil {
+			return "", fmt.Errorf("%s: not a DigitalOcean API URL (%s://%s); the token is only sent there", path, base.Scheme, base.Host)
+		}
+		return path, nil
+	}
+	path = "/" + strings.TrimPrefix(path, "/")
+	path = strings.TrimPrefix(path, "/v2")
+	return apiBase + path, nil
+}
+
+// readBody returns a literal body, the contents of @file, or stdin for "-".
+func readBody(arg string) ([]byte, error) {
+	switch {
+	case arg == "-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(arg, "@"):
+		return os.ReadFile(arg[1:])
+	}
+	return []byte(arg), nil
+}
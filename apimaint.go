@@ -0,0 +1,189 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DigitalOcean answers 503 with a maintenance message while its API is under
+// scheduled maintenance. Such responses are retried more patiently than
+// other server errors, the window is recorded in the state DB (as
+// api_maintenance), and failures during it do not send notifications. A
+// request that gives up during maintenance starts a cooldown: records
+// checked before it ends are not sent to the API at all. The first
+// successful call afterwards ends the window.
+
+// apiMaintenance is a maintenance window announced by the API.
+type apiMaintenance struct {
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`             // failure notifications are held back until then
+	RetryAt time.Time `json:"retry_at,omitzero"` // records are skipped as cooldown until then
+	Message string    `json:"message,omitempty"`
+}
+
+const (
+	apiMaintenanceMinWait = 30 * time.Second
+	apiMaintenanceMaxWait = 5 * time.Minute
+	// apiMaintenanceHold is how long a maintenance response without a
+	// Retry-After holds back failure notifications, and the longest
+	// cooldown.
+	apiMaintenanceHold = 15 * time.Minute
+)
+
+type apiMaintenanceError struct {
+	Message string
+}
+
+func (e *apiMaintenanceError) Error() string {
+	return "DigitalOcean API maintenance: " + e.Message
+}
+
+// maintenanceResponse reports whether a response announces API maintenance,
+// and its message.
+func maintenanceResponse(status int, data []byte) (string, bool) {
+	if status != http.StatusServiceUnavailable {
+		return "", false
+	}
+	var er errorResponse
+	json.Unmarshal(data, &er)
+	text := cmp.Or(er.Message, strings.TrimSpace(string(data)))
+	if !strings.Contains(strings.ToLower(er.ID+" "+text), "maintenance") {
+		return "", false
+	}
+	return text, true
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date; it
+// returns 0 if there is none.
+func retryAfter(hdr http.Header) time.Duration {
+	ra := strings.TrimSpace(hdr.Get("Retry-After"))
+	if ra == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(ra); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// apiMaint is this process's view of the maintenance window; loaded tells
+// whether the state DB has been consulted yet.
+var apiMaint struct {
+	sync.Mutex
+	loaded  bool
+	until   time.Time
+	retryAt time.Time
+}
+
+// loadAPIMaintenance picks up a window recorded by an earlier run, once.
+func loadAPIMaintenance(stateDir string) {
+	apiMaint.Lock()
+	defer apiMaint.Unlock()
+	if apiMaint.loaded {
+		return
+	}
+	db, err := viewStateDB(stateDir)
+	if err != nil {
+		return
+	}
+	apiMaint.loaded = true
+	if db.APIMaintenance != nil {
+		apiMaint.until, apiMaint.retryAt = db.APIMaintenance.Until, db.APIMaintenance.RetryAt
+	}
+}
+
+// apiCooldown reports whether the API asked not to be called before now,
+// and until when.
+func apiCooldown() (time.Time, bool) {
+	apiMaint.Lock()
+	defer apiMaint.Unlock()
+	return apiMaint.retryAt, time.Now().Before(apiMaint.retryAt)
+}
+
+// inAPIMaintenance reports whether failures should not be notified.
+func inAPIMaintenance(err error) bool {
+	var me *apiMaintenanceError
+	if errors.As(err, &me) {
+		return true
+	}
+	apiMaint.Lock()
+	defer apiMaint.Unlock()
+	return time.Now().Before(apiMaint.until)
+}
+
+// noteAPIMaintenance starts or extends the window after a maintenance
+// response.
+func noteAPIMaintenance(cfg Config, message string, wait time.Duration) {
+	now := time.Now()
+	until := now.Add(max(wait, apiMaintenanceHold))
+	apiMaint.Lock()
+	apiMaint.loaded, apiMaint.until = true, until
+	apiMaint.Unlock()
+	if cfg.StateDir == "" {
+		return
+	}
+	if err := updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		if db.APIMaintenance == nil {
+			db.APIMaintenance = &apiMaintenance{Since: now}
+		}
+		db.APIMaintenance.Until, db.APIMaintenance.Message = until, message
+		return true
+	}); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+}
+
+// startAPICooldown is called when a request gave up during maintenance:
+// no more requests are made for the Retry-After of the last response, at
+// least apiMaintenanceMinWait and at most apiMaintenanceHold.
+func startAPICooldown(cfg Config, retryAfter time.Duration) {
+	retryAt := time.Now().Add(min(max(retryAfter, apiMaintenanceMinWait), apiMaintenanceHold))
+	apiMaint.Lock()
+	apiMaint.retryAt = retryAt
+	apiMaint.Unlock()
+	if cfg.StateDir == "" {
+		return
+	}
+	if err := updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		if db.APIMaintenance == nil {
+			return false
+		}
+		db.APIMaintenance.RetryAt = retryAt
+		return true
+	}); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+}
+
+// apiRecovered ends the window after a successful call.
+func apiRecovered(cfg Config) {
+	apiMaint.Lock()
+	if apiMaint.loaded && apiMaint.until.IsZero() {
+		apiMaint.Unlock()
+		return
+	}
+	apiMaint.loaded, apiMaint.until, apiMaint.retryAt = true, time.Time{}, time.Time{}
+	apiMaint.Unlock()
+	if cfg.StateDir == "" {
+		return
+	}
+	var was *apiMaintenance
+	if err := updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		was, db.APIMaintenance = db.APIMaintenance, nil
+		return was != nil
+	}); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+	if was != nil {
+		logm(msgAPIMaintenanceOver, time.Since(was.Since).Round(time.Second))
+	}
+}
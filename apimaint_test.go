@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A request that gives up during API maintenance fails its record, and the
+// records checked after it are not sent to the API until the cooldown ends.
+func TestAPIMaintenanceCooldown(t *testing.T) {
+	t.Cleanup(func() {
+		apiMaint.Lock()
+		apiMaint.loaded, apiMaint.until, apiMaint.retryAt = false, time.Time{}, time.Time{}
+		apiMaint.Unlock()
+	})
+	var calls atomic.Int32
+	var down atomic.Bool
+	down.Store(true)
+	z := &zone{}
+	fakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"id":"service_unavailable","message":"The API is down for scheduled maintenance."}`))
+			return
+		}
+		z.ServeHTTP(w, r)
+	}))
+
+	dir := t.TempDir()
+	value := filepath.Join(dir, "value")
+	if err := os.WriteFile(value, []byte("v"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.Type, cfg.Types, cfg.DataFrom, cfg.StateDir = "TXT", []string{"TXT"}, value, dir
+	check := func(name string) runResult {
+		c := cfg
+		c.Name = name
+		res, _ := runOnce(context.Background(), c, nil, nil)
+		if len(res) != 1 {
+			t.Fatalf("%d results for %s", len(res), name)
+		}
+		return res[0]
+	}
+
+	if res := check("a"); res.Err == nil || !inAPIMaintenance(res.Err) {
+		t.Fatalf("record a during maintenance: %+v", res)
+	}
+	n := calls.Load()
+	res := check("b")
+	if res.Err != nil || res.Action != "cooldown" || res.skipReason() != skipCooldown {
+		t.Errorf("record b during the cooldown: action %q, err %v", res.Action, res.Err)
+	}
+	if calls.Load() != n {
+		t.Error("record b called the API during the cooldown")
+	}
+	db, err := viewStateDB(dir)
+	if err != nil || db.APIMaintenance == nil || db.APIMaintenance.RetryAt.IsZero() {
+		t.Fatalf("state DB api_maintenance = %+v, %v", db.APIMaintenance, err)
+	}
+
+	if d := time.Until(db.APIMaintenance.RetryAt); d < 25*time.Second || d > apiMaintenanceMinWait {
+		t.Errorf("cooldown of %s, want the 30s minimum", d)
+	}
+
+	// Once the cooldown has ended, records are checked again and the first
+	// success ends the window.
+	down.Store(false)
+	apiMaint.Lock()
+	apiMaint.retryAt = time.Now()
+	apiMaint.Unlock()
+	if res := check("b"); res.Err != nil || res.Action != "created" {
+		t.Errorf("record b after the cooldown: action %q, err %v", res.Action, res.Err)
+	}
+	if _, ok := apiCooldown(); ok {
+		t.Error("still cooling down after a successful call")
+	}
+	if db, _ := viewStateDB(dir); db.APIMaintenance != nil {
+		t.Errorf("api_maintenance = %+v after a successful call", db.APIMaintenance)
+	}
+}
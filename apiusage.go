@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// API usage history: every process counts its DigitalOcean API requests per
+// hour and record, along with the rate-limit headers DigitalOcean sends
+// (which count every request made with the token, from any host), and adds
+// them to <state dir>/do-ddns.usage.json when it is done: one-shot runs and
+// subcommands on exit, the daemon after every check (or with
+// --state-sync-interval, on that interval). `do-ddns usage` summarizes the
+// file. Hours older than usageRetention are dropped.
+
+const usageRetention = 7 * 24 * time.Hour
+
+type usageHistory struct {
+	Hours []*usageHour `json:"hours"`
+}
+
+type usageHour struct {
+	Hour        time.Time      `json:"hour"`
+	Calls       map[string]int `json:"calls"` // by record, or by domain for zone-wide requests
+	Errors      int            `json:"errors,omitempty"`
+	RateLimited int            `json:"rate_limited,omitempty"` // HTTP 429 responses
+	// Limit and MinRemaining come from the RateLimit-Limit and
+	// RateLimit-Remaining headers: the lowest remaining budget seen.
+	Limit        int       `json:"limit,omitempty"`
+	MinRemaining *int      `json:"min_remaining,omitempty"`
+	MinAt        time.Time `json:"min_at,omitzero"`
+}
+
+func (h *usageHour) merge(o *usageHour) {
+	for k, n := range o.Calls {
+		h.Calls[k] += n
+	}
+	h.Errors += o.Errors
+	h.RateLimited += o.RateLimited
+	h.Limit = max(h.Limit, o.Limit)
+	if o.MinRemaining != nil && (h.MinRemaining == nil || *o.MinRemaining < *h.MinRemaining) {
+		h.MinRemaining, h.MinAt = o.MinRemaining, o.MinAt
+	}
+}
+
+func (h *usageHour) total() int {
+	n := 0
+	for _, c := range h.Calls {
+		n += c
+	}
+	return n
+}
+
+func usagePath(stateDir string) string {
+	return filepath.Join(stateDir, "do-ddns.usage.json")
+}
+
+// apiUsage collects this process's requests until they are flushed.
+var apiUsage usageRecorder
+
+type usageRecorder struct {
+	mu    sync.Mutex
+	dir   string
+	hours map[time.Time]*usageHour
+}
+
+// note counts one request attempt; status is 0 if no response came back.
+// Requests made without a state directory (e.g. `do-ddns api`) are not
+// kept.
+func (u *usageRecorder) note(cfg Config, status int, hdr http.Header) {
+	if cfg.StateDir == "" {
+		return
+	}
+	now := time.Now()
+	key := cfg.Domain
+	if cfg.Name != "" {
+		key = fqdn(cfg.Name, cfg.Domain)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.dir = cfg.StateDir
+	if u.hours == nil {
+		u.hours = map[time.Time]*usageHour{}
+	}
+	hour := now.UTC().Truncate(time.Hour)
+	h := u.hours[hour]
+	if h == nil {
+		h = &usageHour{Hour: hour, Calls: map[string]int{}}
+		u.hours[hour] = h
+	}
+	h.Calls[cmp.Or(key, "-")]++
+	switch {
+	case status == http.StatusTooManyRequests:
+		h.RateLimited++
+	case status == 0 || status >= 400:
+		h.Errors++
+	}
+	if hdr == nil {
+		return
+	}
+	limit, err1 := strconv.Atoi(hdr.Get("Ratelimit-Limit"))
+	remaining, err2 := strconv.Atoi(hdr.Get("Ratelimit-Remaining"))
+	if err1 == nil && err2 == nil {
+		h.Limit = max(h.Limit, limit)
+		if h.MinRemaining == nil || remaining < *h.MinRemaining {
+			h.MinRemaining, h.MinAt = &remaining, now
+		}
+	}
+}
+
+// flush adds the collected requests to the usage file.
+func (u *usageRecorder) flush() {
+	u.mu.Lock()
+	dir, hours := u.dir, u.hours
+	u.hours = nil
+	u.mu.Unlock()
+	if len(hours) == 0 {
+		return
+	}
+	if err := addUsage(dir, hours); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+}
+
+func loadUsage(stateDir string) (*usageHistory, error) {
+	var hist usageHistory
+	b, err := os.ReadFile(usagePath(stateDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &hist, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &hist); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", usagePath(stateDir), err)
+	}
+	return &hist, nil
+}
+
+func addUsage(stateDir string, hours map[time.Time]*usageHour) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	hist, err := loadUsage(stateDir)
+	if err != nil {
+		return err
+	}
+	byHour := map[time.Time]*usageHour{}
+	for _, h := range hist.Hours {
+		byHour[h.Hour.UTC()] = h
+	}
+	for t, h := range hours {
+		if old := byHour[t]; old != nil {
+			old.merge(h)
+		} else {
+			byHour[t] = h
+		}
+	}
+	cutoff := time.Now().Add(-usageRetention)
+	hist.Hours = nil
+	for _, t := range slices.SortedFunc(maps.Keys(byHour), time.Time.Compare) {
+		if t.After(cutoff) {
+			hist.Hours = append(hist.Hours, byHour[t])
+		}
+	}
+	b, err := json.MarshalIndent(hist, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := usagePath(stateDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// usageReport is the output of `do-ddns usage --json`.
+type usageReport struct {
+	Since       time.Time      `json:"since"`
+	Calls       int            `json:"calls"`
+	Errors      int            `json:"errors"`
+	RateLimited int            `json:"rate_limited"`
+	ByRecord    map[string]int `json:"by_record"`
+	BusiestHour time.Time      `json:"busiest_hour,omitzero"`
+	BusiestHits int            `json:"busiest_hour_calls,omitempty"`
+	// Limit, MinRemaining and Headroom (the share of Limit left at
+	// MinRemaining) are only known if DigitalOcean sent rate-limit headers.
+	Limit        int       `json:"limit,omitempty"`
+	MinRemaining *int      `json:"min_remaining,omitempty"`
+	MinAt        time.Time `json:"min_at,omitzero"`
+	Headroom     *float64  `json:"headroom,omitempty"`
+}
+
+// lowHeadroom is the share of the rate limit below which `do-ddns usage`
+// suggests splitting tokens.
+const lowHeadroom = 0.2
+
+// runUsage implements `do-ddns usage`: totals per record and the rate-limit
+// headroom seen, from the usage file.
+func runUsage(args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	stateDir := fs.String("state-dir", envDefault("STATE_DIR", "/tmp"), "State directory (or env STATE_DIR)")
+	since := fs.Duration("since", 24*time.Hour, "Report the requests of this last period (at most 7 days are kept)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return withExitCode(2, errors.New("usage: do-ddns usage [--state-dir DIR] [--since 24h] [--json]"))
+	}
+
+	hist, err := loadUsage(*stateDir)
+	if err != nil {
+		return err
+	}
+	rep := usageReport{Since: time.Now().Add(-*since).Truncate(time.Hour), ByRecord: map[string]int{}}
+	for _, h := range hist.Hours {
+		if h.Hour.Before(rep.Since) {
+			continue
+		}
+		for k, n := range h.Calls {
+			rep.ByRecord[k] += n
+		}
+		if n := h.total(); n > rep.BusiestHits {
+			rep.BusiestHour, rep.BusiestHits = h.Hour, n
+		}
+		rep.Calls += h.total()
+		rep.Errors += h.Errors
+		rep.RateLimited += h.RateLimited
+		rep.Limit = max(rep.Limit, h.Limit)
+		if h.MinRemaining != nil && (rep.MinRemaining == nil || *h.MinRemaining < *rep.MinRemaining) {
+			rep.MinRemaining, rep.MinAt = h.MinRemaining, h.MinAt
+		}
+	}
+	if rep.MinRemaining != nil && rep.Limit > 0 {
+		hr := float64(*rep.MinRemaining) / float64(rep.Limit)
+		rep.Headroom = &hr
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	fmt.Printf("DigitalOcean API requests since %s (from %s)\n", rep.Since.Format("2006-01-02 15:04"), usagePath(*stateDir))
+	if rep.Calls == 0 {
+		fmt.Println("  none recorded")
+		return nil
+	}
+	fmt.Printf("  Requests:     %d (%d failed, %d rate limited)\n", rep.Calls, rep.Errors, rep.RateLimited)
+	fmt.Printf("  Busiest hour: %s, %d requests\n", rep.BusiestHour.Format("2006-01-02 15:04"), rep.BusiestHits)
+	keys := slices.SortedFunc(maps.Keys(rep.ByRecord), func(a, b string) int {
+		return cmp.Or(cmp.Compare(rep.ByRecord[b], rep.ByRecord[a]), cmp.Compare(a, b))
+	})
+	fmt.Println("  By record:")
+	for _, k := range keys {
+		fmt.Printf("    %-40s %6d  %3.0f%%\n", k, rep.ByRecord[k], 100*float64(rep.ByRecord[k])/float64(rep.Calls))
+	}
+	if rep.Headroom == nil {
+		fmt.Println("  Rate limit:   no rate-limit headers seen")
+		return nil
+	}
+	fmt.Printf("  Rate limit:   %d/hour for the token; lowest remaining %d (%.0f%% headroom) at %s\n",
+		rep.Limit, *rep.MinRemaining, 100**rep.Headroom, rep.MinAt.Format("2006-01-02 15:04"))
+	if *rep.Headroom < lowHeadroom {
+		fmt.Println("  The token's budget is shared by everything using it, and it ran low: give sites their own token, or check less often (--interval).")
+	}
+	return nil
+}
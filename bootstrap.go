@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Records of a config can depend on each other: a CNAME pointing at a name
+// whose A/AAAA record is managed in the same config, or a TXT record
+// (typically an ownership marker) next to such an address record. Those
+// dependents are reconciled only after the address record succeeded, and
+// are aborted if it failed. When a group's address record was created in
+// this run (a first run against an empty zone) and any member of the group
+// failed, the records created in this run are deleted again, so the next
+// run starts the bootstrap from scratch instead of finishing a half-built
+// group.
+
+// recordDeps returns, for each record, the indices of the address records
+// it depends on. Records with an address type never depend on others, so
+// there are no cycles.
+func recordDeps(records []Config) [][]int {
+	owners := map[string]int{}
+	for i, c := range records {
+		if slices.ContainsFunc(c.Types, isAddressType) {
+			if _, dup := owners[hostKey(fqdn(c.Name, c.Domain))]; !dup {
+				owners[hostKey(fqdn(c.Name, c.Domain))] = i
+			}
+		}
+	}
+	deps := make([][]int, len(records))
+	for i, c := range records {
+		if slices.ContainsFunc(c.Types, isAddressType) {
+			continue
+		}
+		if slices.Contains(c.Types, "CNAME") {
+			if j, ok := owners[hostKey(c.Data)]; ok {
+				deps[i] = append(deps[i], j)
+			}
+		}
+		if slices.Contains(c.Types, "TXT") {
+			if j, ok := owners[hostKey(fqdn(c.Name, c.Domain))]; ok && !slices.Contains(deps[i], j) {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	return deps
+}
+
+func hostKey(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// bootstrapOrder lists record indices with the address records first, for
+// the sequential --fail-fast mode.
+func bootstrapOrder(deps [][]int) []int {
+	var first, then []int
+	for i, d := range deps {
+		if len(d) == 0 {
+			first = append(first, i)
+		} else {
+			then = append(then, i)
+		}
+	}
+	return append(first, then...)
+}
+
+// failedDep returns the first dependency of record i that did not succeed.
+func failedDep(deps [][]int, i int, perRecord [][]runResult, errs []error) (int, bool) {
+	for _, j := range deps[i] {
+		if errs[j] != nil || slices.ContainsFunc(perRecord[j], func(r runResult) bool { return r.Action == "aborted" }) {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// blockedResults reports cfg's types as not attempted because the record it
+// depends on failed.
+func blockedResults(cfg, dep Config) []runResult {
+	var out []runResult
+	for _, t := range cfg.Types {
+		logm(msgRecordBlocked, t, fqdn(cfg.Name, cfg.Domain), describeDep(dep))
+		res := runResult{Domain: cfg.Domain, Name: cfg.Name, Type: t, Action: "aborted"}
+		res.explain(msgExplainBlocked, describeDep(dep))
+		out = append(out, res)
+	}
+	return out
+}
+
+// rollbackBootstraps deletes the records created in this run for every
+// group whose address record was created now and which had a failure.
+func rollbackBootstraps(ctx context.Context, records []Config, deps [][]int, perRecord [][]runResult, errs []error) {
+	groups := map[int][]int{}
+	for i, d := range deps {
+		for _, j := range d {
+			groups[j] = append(groups[j], i)
+		}
+	}
+	for owner, dependents := range groups {
+		if !slices.ContainsFunc(perRecord[owner], func(r runResult) bool { return r.Action == "created" }) {
+			continue
+		}
+		members := append([]int{owner}, dependents...)
+		failed := slices.ContainsFunc(members, func(i int) bool {
+			return errs[i] != nil || slices.ContainsFunc(perRecord[i], func(r runResult) bool { return r.Action == "aborted" })
+		})
+		if !failed {
+			continue
+		}
+		group := fqdn(records[owner].Name, records[owner].Domain)
+		logm(msgRollbackStart, group)
+		rctx, cancel := context.WithTimeout(ctx, 45*time.Second)
+		for _, i := range members {
+			for k := range perRecord[i] {
+				if perRecord[i][k].Action == "created" {
+					undoChange(rctx, records[i], &perRecord[i][k], group)
+				}
+			}
+		}
+		cancel()
+	}
+}
+
+// undoChange undoes what this run did to res's record: a record it created
+// is deleted, one it updated gets its previous value and TTL back. It
+// reports whether there was nothing to undo or undoing worked.
+func undoChange(ctx context.Context, cfg Config, res *runResult, group string) bool {
+	if res.ID == 0 || (res.Action != "created" && res.Action != "updated") {
+		return true
+	}
+	cfg.Type = res.Type
+	host := fqdn(res.Name, res.Domain)
+	switch {
+	case res.Action == "created":
+		if err := deleteRecord(ctx, cfg, res.ID); err != nil {
+			warnf("%s", msg(msgRollbackFailed, res.Type, host, res.ID, err))
+			return false
+		}
+		logm(msgRollbackDeleted, res.Type, host, res.ID)
+	case res.Previous != nil:
+		cfg.TTL, cfg.PreserveTTL = res.Previous.TTL, false
+		if err := updateRecord(ctx, cfg, res.ID, res.Previous.Data); err != nil {
+			warnf("%s", msg(msgRollbackFailed, res.Type, host, res.ID, err))
+			return false
+		}
+		logm(msgRollbackRestored, res.Type, host, res.ID, res.Previous.Data)
+	default:
+		// e.g. an address set, whose previous records are not kept
+		warnf("%s", msg(msgRollbackFailed, res.Type, host, res.ID, "its previous value is not known"))
+		return false
+	}
+	res.Action = "rolled-back"
+	res.explain(msgExplainRolledBack, group)
+	if err := clearLastIP(stateFile(cfg)); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+	if cfg.ExpiresIn > 0 {
+		if err := untrackExpiry(cfg, res.key()); err != nil {
+			warnf("%s", msg(msgStateWriteFailed, err))
+		}
+	}
+	return true
+}
+
+// describeDep is the dependency of a record in explanations.
+func describeDep(dep Config) string {
+	return fmt.Sprintf("%s (%s)", fqdn(dep.Name, dep.Domain), strings.Join(dep.Types, ","))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A first run against an empty zone creates the A record, then the CNAME
+// depending on it fails: the A record is deleted again so the next run
+// starts the bootstrap from scratch.
+func TestBootstrapRollback(t *testing.T) {
+	ipSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "203.0.113.7\n")
+	}))
+	defer ipSrv.Close()
+
+	z := &zone{}
+	fakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			body, _ := io.ReadAll(r.Body)
+			if bytes.Contains(body, []byte(`"CNAME"`)) {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				io.WriteString(w, `{"id":"unprocessable_entity","message":"CNAME records cannot share a name with other records."}`)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		z.ServeHTTP(w, r)
+	}))
+
+	dir := t.TempDir()
+	addr := testConfig()
+	addr.Name, addr.Type, addr.Types, addr.IPSource, addr.StateDir = "hq", "A", []string{"A"}, ipSrv.URL, dir
+	alias := testConfig()
+	alias.Name, alias.Type, alias.Types, alias.Data, alias.StateDir = "www", "CNAME", []string{"CNAME"}, "hq.example.com.", dir
+
+	results, err := runAll(context.Background(), []Config{addr, alias}, nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot share a name") {
+		t.Fatalf("runAll err = %v, want the CNAME failure", err)
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if want := []string{"POST A hq", "DELETE 5001"}; !slices.Equal(z.changes, want) {
+		t.Errorf("changes %q, want %q", z.changes, want)
+	}
+	if len(z.records) != 0 {
+		t.Errorf("records left in the zone: %+v", z.records)
+	}
+	if len(results) == 0 || results[0].Action != "rolled-back" {
+		t.Errorf("results = %+v, want the A record rolled back", results)
+	}
+	if ip, _ := readLastIP(stateFile(addr)); ip != "" {
+		t.Errorf("last IP %q kept for a rolled-back record", ip)
+	}
+}
+
+func TestUndoChange(t *testing.T) {
+	z := &zone{records: []DomainRecord{
+		{ID: 1, Type: "A", Name: "hq", Data: "203.0.113.7", TTL: 300},
+		{ID: 2, Type: "A", Name: "vpn", Data: "203.0.113.7", TTL: 300},
+	}}
+	fakeAPI(t, z)
+	cfg := testConfig()
+	cfg.StateDir, cfg.TTL = t.TempDir(), 300
+
+	created := runResult{Domain: "example.com", Name: "hq", Type: "A", Action: "created", ID: 1}
+	updated := runResult{Domain: "example.com", Name: "vpn", Type: "A", Action: "updated", ID: 2,
+		Previous: &DomainRecord{ID: 2, Data: "192.0.2.1", TTL: 3600}}
+	unknown := runResult{Domain: "example.com", Name: "set", Type: "A", Action: "updated", ID: 3}
+	unchanged := runResult{Domain: "example.com", Name: "hq", Type: "A", Action: "unchanged", ID: 1}
+
+	for _, res := range []*runResult{&created, &updated} {
+		if !undoChange(context.Background(), cfg, res, "hq.example.com") || res.Action != "rolled-back" {
+			t.Errorf("undoing %s: action %q", res.Name, res.Action)
+		}
+	}
+	if undoChange(context.Background(), cfg, &unknown, "hq.example.com") || unknown.Action != "updated" {
+		t.Errorf("undoing an update without a previous value: action %q", unknown.Action)
+	}
+	if !undoChange(context.Background(), cfg, &unchanged, "hq.example.com") || unchanged.Action != "unchanged" {
+		t.Errorf("undoing an unchanged record: action %q", unchanged.Action)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	want := []DomainRecord{{ID: 2, Type: "A", Name: "vpn", Data: "192.0.2.1", TTL: 3600}}
+	if !slices.Equal(z.records, want) {
+		t.Errorf("zone = %+v, want %+v", z.records, want)
+	}
+}
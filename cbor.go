@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// cborMarshal encodes v as CBOR (RFC 8949). Like parseYAML it goes through
+// encoding/json, so the struct tags decide the field names and omitempty
+// applies: v is marshalled to JSON and the resulting maps, arrays, strings,
+// numbers, booleans and nulls are written as their CBOR equivalents. Map
+// keys are sorted, so equal values encode to equal bytes.
+func cborMarshal(v any) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := cborEncode(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cborUnmarshal decodes CBOR written by cborMarshal into v. Indefinite-length
+// items and tags are not supported.
+func cborUnmarshal(b []byte, v any) error {
+	d := cborDecoder{b: b}
+	generic, err := d.value(0)
+	if err != nil {
+		return err
+	}
+	if d.off != len(b) {
+		return fmt.Errorf("cbor: %d trailing bytes", len(b)-d.off)
+	}
+	j, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+)
+
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		buf.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{m | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(m | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func cborEncode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case string:
+		cborHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n >= 0 {
+				cborHead(buf, cborUint, uint64(n))
+			} else {
+				cborHead(buf, cborNegint, uint64(-(n + 1)))
+			}
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			cborHead(buf, cborUint, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return fmt.Errorf("cbor: number %s: %w", v, err)
+		}
+		buf.WriteByte(0xfb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	case []any:
+		cborHead(buf, cborArray, uint64(len(v)))
+		for _, x := range v {
+			if err := cborEncode(buf, x); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		cborHead(buf, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			cborHead(buf, cborText, uint64(len(k)))
+			buf.WriteString(k)
+			if err := cborEncode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: cannot encode %T", v)
+	}
+	return nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+var errCBORShort = errors.New("cbor: unexpected end of data")
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return nil, errCBORShort
+	}
+	p := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	return p, nil
+}
+
+// head reads an item's major type and argument.
+func (d *cborDecoder) head() (major byte, info byte, n uint64, err error) {
+	p, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = p[0]>>5, p[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		p, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range p {
+			n = n<<8 | uint64(c)
+		}
+		return major, info, n, nil
+	}
+	return 0, 0, 0, fmt.Errorf("cbor: unsupported additional info %d at offset %d", info, d.off-1)
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > 64 {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case cborNegint:
+		if n == math.MaxUint64 {
+			return json.Number("-18446744073709551616"), nil
+		}
+		return json.Number("-" + strconv.FormatUint(n+1, 10)), nil
+	case cborBytes:
+		return d.next(n)
+	case cborText:
+		p, err := d.next(n)
+		return string(p), err
+	case cborArray:
+		if n > uint64(len(d.b)) {
+			return nil, errCBORShort
+		}
+		out := make([]any, 0, n)
+		for range n {
+			x, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, x)
+		}
+		return out, nil
+	case cborMap:
+		if n > uint64(len(d.b)) {
+			return nil, errCBORShort
+		}
+		out := make(map[string]any, n)
+		for range n {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("cbor: map key is %T, not a string", k)
+			}
+			if out[ks], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case cborSimple:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case 27:
+			return math.Float64frombits(n), nil
+		}
+	}
+	return nil, fmt.Errorf("cbor: unsupported item (major %d, info %d)", major, info)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 8949 Appendix A examples, for the items cborMarshal writes.
+var cborVectors = []struct {
+	json string
+	cbor string
+}{
+	{`0`, "00"},
+	{`23`, "17"},
+	{`24`, "1818"},
+	{`100`, "1864"},
+	{`1000`, "1903e8"},
+	{`1000000`, "1a000f4240"},
+	{`1000000000000`, "1b000000e8d4a51000"},
+	{`18446744073709551615`, "1bffffffffffffffff"},
+	{`-1`, "20"},
+	{`-1000`, "3903e7"},
+	{`-9223372036854775808`, "3b7fffffffffffffff"},
+	{`1.1`, "fb3ff199999999999a"},
+	{`-4.1`, "fbc010666666666666"},
+	{`false`, "f4"},
+	{`true`, "f5"},
+	{`null`, "f6"},
+	{`""`, "60"},
+	{`"a"`, "6161"},
+	{`"ü"`, "62c3bc"},
+	{`[]`, "80"},
+	{`[1,[2,3],[4,5]]`, "8301820203820405"},
+	{`{}`, "a0"},
+	{`{"a":1,"b":[2,3]}`, "a26161016162820203"},
+	{`["a",{"b":"c"}]`, "826161a161626163"},
+}
+
+func TestCBORVectors(t *testing.T) {
+	for _, v := range cborVectors {
+		var in any
+		dec := json.NewDecoder(strings.NewReader(v.json))
+		dec.UseNumber()
+		if err := dec.Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		got, err := cborMarshal(in)
+		if err != nil {
+			t.Errorf("cborMarshal(%s): %v", v.json, err)
+			continue
+		}
+		if hex.EncodeToString(got) != v.cbor {
+			t.Errorf("cborMarshal(%s) = %x, want %s", v.json, got, v.cbor)
+		}
+
+		var back json.RawMessage
+		b, _ := hex.DecodeString(v.cbor)
+		if err := cborUnmarshal(b, &back); err != nil {
+			t.Errorf("cborUnmarshal(%s): %v", v.cbor, err)
+			continue
+		}
+		if string(back) != v.json {
+			t.Errorf("cborUnmarshal(%s) = %s, want %s", v.cbor, back, v.json)
+		}
+	}
+}
+
+// Items cborMarshal never writes but the decoder accepts.
+func TestCBORDecodeOnly(t *testing.T) {
+	tests := []struct {
+		cbor string
+		want string
+	}{
+		{"fa47c35000", `100000`},     // single-precision float
+		{"f7", `null`},               // undefined
+		{"4401020304", `"AQIDBA=="`}, // byte string, as base64 like encoding/json
+		{"1800", `0`},                // non-minimal length
+		{"7818" + strings.Repeat("61", 24), `"` + strings.Repeat("a", 24) + `"`},
+	}
+	for _, tt := range tests {
+		b, _ := hex.DecodeString(tt.cbor)
+		var got json.RawMessage
+		if err := cborUnmarshal(b, &got); err != nil {
+			t.Errorf("cborUnmarshal(%s): %v", tt.cbor, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("cborUnmarshal(%s) = %s, want %s", tt.cbor, got, tt.want)
+		}
+	}
+}
+
+func sampleStateDB() *stateDB {
+	t0 := time.Date(2026, 10, 14, 9, 30, 0, 123456789, time.UTC)
+	return &stateDB{
+		Expiring: []expiringRecord{
+			{Domain: "example.com", Name: "demo", Type: "A", ID: 42, Data: "203.0.113.7", Created: t0, Expires: t0.Add(2 * time.Hour)},
+			{Domain: "example.com", Name: "old", Type: "TXT", ID: 1 << 40, Data: `"quoted" ünïcode`, Created: t0, Expires: t0, Deleted: true},
+		},
+		Maintenance:   []maintenanceEntry{{Domain: "example.com", Name: "www", Type: "A", ID: 7, Original: "192.0.2.1", OriginalTTL: 60, Target: "192.0.2.99", Since: t0}},
+		Paused:        []pausedRecord{{Domain: "example.com", Name: "*", Reason: "migration", Since: t0, Until: t0.Add(time.Hour)}},
+		ActiveTargets: []activeTarget{{Domain: "example.com", Name: "api", Target: "green", Since: t0}},
+		Rotation:      []rotationState{{Key: "example.com/lb/A", Last: "198.51.100.2"}},
+		LastAPIError:  &apiErrorRecord{Time: t0, RunID: "r-1", Request: "PUT /v2/domains/example.com/records/7", Status: 422, Error: "bad"},
+		Groups:        map[string]*groupState{"hq": {State: "degraded", Since: t0, Failed: []string{"example.com/hq/AAAA"}, Error: "boom"}},
+		APIMaintenance: &apiMaintenance{
+			Since: t0, Until: t0.Add(15 * time.Minute), Message: "scheduled maintenance",
+		},
+		LastPlan: t0,
+		LastIP:   map[string]string{"example.com_hq_A.last_ip": "203.0.113.7", "example.com_hq_AAAA.last_ip": "2001:db8::1"},
+	}
+}
+
+func TestCBORStateDBRoundTrip(t *testing.T) {
+	db := sampleStateDB()
+	want, err := json.Marshal(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cbor -> json
+	c1, err := cborMarshal(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromCBOR stateDB
+	if err := cborUnmarshal(c1, &fromCBOR); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(&fromCBOR)
+	if !bytes.Equal(got, want) {
+		t.Errorf("after CBOR:\n got %s\nwant %s", got, want)
+	}
+
+	// json -> cbor: the same bytes again.
+	var fromJSON stateDB
+	if err := json.Unmarshal(got, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	c2, err := cborMarshal(&fromJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c1, c2) {
+		t.Errorf("re-encoding changed the CBOR:\n%x\n%x", c1, c2)
+	}
+	if len(c1) >= len(want) {
+		t.Errorf("CBOR is %d bytes, JSON %d: no smaller", len(c1), len(want))
+	}
+
+	// An empty DB stays empty.
+	c, _ := cborMarshal(&stateDB{})
+	var empty stateDB
+	if err := cborUnmarshal(c, &empty); err != nil || !reflect.DeepEqual(empty, stateDB{}) {
+		t.Errorf("empty DB: %x -> %+v, %v", c, empty, err)
+	}
+}
+
+func TestCBORMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		cbor string
+		want string
+	}{
+		{"empty", "", "unexpected end"},
+		{"trailing bytes", "0000", "1 trailing bytes"},
+		{"short length", "19", "unexpected end"},
+		{"short string", "6461", "unexpected end"},
+		{"short array", "8301", "unexpected end"},
+		{"short map", "a16161", "unexpected end"},
+		{"huge array", "9bffffffffffffffff", "unexpected end"},
+		{"huge string", "7bffffffffffffffff", "unexpected end"},
+		{"huge map", "bb0000000100000000", "unexpected end"},
+		{"indefinite array", "9f01ff", "unsupported additional info 31"},
+		{"reserved info", "1c", "unsupported additional info 28"},
+		{"tag", "c074", "unsupported item (major 6"},
+		{"half float", "f93c00", "unsupported item (major 7, info 25)"},
+		{"simple value", "f820", "unsupported item (major 7, info 24)"},
+		{"integer map key", "a10102", "map key is json.Number"},
+		{"nesting too deep", strings.Repeat("81", 100) + "00", "nesting too deep"},
+		{"NaN", "fb7ff8000000000000", "unsupported value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.cbor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v any
+			err = cborUnmarshal(b, &v)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("cborUnmarshal(%s) = %v, want an error containing %q", tt.cbor, err, tt.want)
+			}
+		})
+	}
+
+	// Every truncation of a real state DB fails cleanly.
+	full, err := cborMarshal(sampleStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(full) {
+		var db stateDB
+		if err := cborUnmarshal(full[:n], &db); err == nil {
+			t.Fatalf("truncated to %d of %d bytes: no error", n, len(full))
+		}
+	}
+
+	// Well-formed CBOR of the wrong shape is a decoding error, not a panic.
+	wrong, _ := cborMarshal(map[string]any{"expiring": "not a list"})
+	var db stateDB
+	if err := cborUnmarshal(wrong, &db); err == nil {
+		t.Error("expiring as a string: no error")
+	}
+}
+
+func FuzzCBORUnmarshal(f *testing.F) {
+	full, _ := cborMarshal(sampleStateDB())
+	f.Add(full)
+	for _, v := range cborVectors {
+		b, _ := hex.DecodeString(v.cbor)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var v any
+		if cborUnmarshal(b, &v) != nil {
+			return
+		}
+		// Whatever decodes re-encodes, and decodes to the same value.
+		again, err := cborMarshal(v)
+		if err != nil {
+			t.Fatalf("re-encoding %x: %v", b, err)
+		}
+		var v2 any
+		if err := cborUnmarshal(again, &v2); err != nil {
+			t.Fatalf("decoding re-encoded %x: %v", again, err)
+		}
+		j1, _ := json.Marshal(v)
+		j2, _ := json.Marshal(v2)
+		if !bytes.Equal(j1, j2) {
+			t.Fatalf("%s != %s", j1, j2)
+		}
+	})
+}
+
+func useStateFormat(t *testing.T, name string) {
+	t.Helper()
+	prev := stateFormat
+	t.Cleanup(func() { stateFormat = prev })
+	if err := setStateFormat(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStateFormatMigration switches a state directory written with the
+// default format (the state DB as JSON and one .last_ip text file per
+// record) to CBOR and back.
+func TestStateFormatMigration(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{StateDir: dir, Domain: "example.com", Name: "hq", Type: "A"}
+	path := stateFile(cfg)
+	name := filepath.Base(path)
+	if err := os.WriteFile(path, []byte("203.0.113.7\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "do-ddns.state.json"), []byte(`{"last_plan":"2026-10-14T09:30:00Z","rotation":[{"key":"k","last":"v"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	useStateFormat(t, "cbor")
+	// The text file is still read until the value is written again.
+	if ip, err := readLastIP(path); err != nil || ip != "203.0.113.7" {
+		t.Fatalf("readLastIP before migrating = %q, %v", ip, err)
+	}
+	if err := storeLastIP(path, "203.0.113.8"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(name) || exists("do-ddns.state.json") || !exists("do-ddns.state.cbor") {
+		t.Fatalf("after migrating to cbor: last_ip file %v, json %v, cbor %v",
+			exists(name), exists("do-ddns.state.json"), exists("do-ddns.state.cbor"))
+	}
+	db, err := viewStateDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.LastIP[name] != "203.0.113.8" || len(db.Rotation) != 1 || db.LastPlan.IsZero() {
+		t.Errorf("migrated state DB = %+v: lost what the JSON one held", db)
+	}
+	if ip, err := readLastIP(path); err != nil || ip != "203.0.113.8" {
+		t.Errorf("readLastIP from the state DB = %q, %v", ip, err)
+	}
+
+	// An unchanged value writes nothing.
+	before, _ := os.ReadFile(filepath.Join(dir, "do-ddns.state.cbor"))
+	st, _ := os.Stat(filepath.Join(dir, "do-ddns.state.cbor"))
+	time.Sleep(10 * time.Millisecond)
+	if err := storeLastIP(path, "203.0.113.8"); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(filepath.Join(dir, "do-ddns.state.cbor"))
+	st2, _ := os.Stat(filepath.Join(dir, "do-ddns.state.cbor"))
+	if !bytes.Equal(before, after) || !st.ModTime().Equal(st2.ModTime()) {
+		t.Error("storing the same last IP rewrote the state DB")
+	}
+
+	// And back: the value moves to its text file again.
+	useStateFormat(t, "json")
+	if err := storeLastIP(path, "203.0.113.9"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "203.0.113.9\n" {
+		t.Fatalf("last_ip file after migrating back = %q, %v", b, err)
+	}
+	if exists("do-ddns.state.cbor") || !exists("do-ddns.state.json") {
+		t.Errorf("after migrating back: cbor %v, json %v", exists("do-ddns.state.cbor"), exists("do-ddns.state.json"))
+	}
+	if db, err := viewStateDB(dir); err != nil || db.LastIP[name] != "" || len(db.Rotation) != 1 {
+		t.Errorf("state DB after migrating back = %+v, %v", db, err)
+	}
+}
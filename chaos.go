@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault injection for resilience testing. Deliberately not a regular flag,
+// so it never shows up in --help: pass --chaos SPEC (stripped from the
+// arguments before they are parsed, for the updater and every subcommand)
+// or set DO_DDNS_CHAOS. SPEC is a comma-separated list of fault=probability
+// pairs, e.g. "429=0.2,5xx=0.1,timeout=0.05,malformed=0.1,reset=0.05,seed=42":
+//
+//	429        answer 429 Too Many Requests (Retry-After: 1)
+//	5xx        answer 503 Service Unavailable
+//	timeout    hang until the request's context expires (at most 30s)
+//	reset      fail the request before it is sent
+//	malformed  send the request, then truncate and garble the response body
+//	seed       make the sequence of faults reproducible
+//	scope      "api" to leave IP sources, health checks and notifications alone
+type chaosConfig struct {
+	faults  []chaosFault
+	apiOnly bool
+	rng     *rand.Rand
+	mu      sync.Mutex
+}
+
+type chaosFault struct {
+	name string
+	p    float64
+}
+
+var chaosFaults = []string{"429", "5xx", "timeout", "reset", "malformed"}
+
+// extractChaos removes --chaos SPEC / --chaos=SPEC from args.
+func extractChaos(args []string) (spec string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "chaos" {
+			rest = append(rest, a)
+			continue
+		}
+		if !hasVal && i+1 < len(args) {
+			i++
+			val = args[i]
+		}
+		spec = val
+	}
+	return spec, rest
+}
+
+func parseChaos(spec string) (*chaosConfig, error) {
+	c := &chaosConfig{}
+	seed := uint64(time.Now().UnixNano())
+	total := 0.0
+	for _, kv := range splitList(spec) {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("chaos: %q: want fault=probability", kv)
+		}
+		if k == "seed" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("chaos: seed: %w", err)
+			}
+			seed = n
+			continue
+		}
+		if k == "scope" {
+			if v != "api" && v != "all" {
+				return nil, fmt.Errorf("chaos: scope: want api or all, got %q", v)
+			}
+			c.apiOnly = v == "api"
+			continue
+		}
+		known := false
+		for _, f := range chaosFaults {
+			known = known || f == k
+		}
+		p, err := strconv.ParseFloat(v, 64)
+		if !known || err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("chaos: %q: want one of %s with a probability between 0 and 1", kv, strings.Join(chaosFaults, ", "))
+		}
+		total += p
+		c.faults = append(c.faults, chaosFault{k, p})
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("chaos: probabilities add up to %.2f, more than 1", total)
+	}
+	c.rng = rand.New(rand.NewPCG(seed, seed))
+	return c, nil
+}
+
+// enableChaos wraps every HTTP transport (composing with --record/--replay)
+// in fault injection.
+func enableChaos(spec string) error {
+	c, err := parseChaos(spec)
+	if err != nil {
+		return err
+	}
+	prev := transportHook
+	transportHook = func(rt http.RoundTripper) http.RoundTripper {
+		if prev != nil {
+			rt = prev(rt)
+		}
+		return chaosTransport{next: rt, c: c}
+	}
+	http.DefaultClient.Transport = wrapTransport(http.DefaultTransport)
+	logf("WARN: %s", msg(msgChaosEnabled, spec))
+	return nil
+}
+
+// pick returns the fault to inject into the next request, or "".
+func (c *chaosConfig) pick() string {
+	c.mu.Lock()
+	r := c.rng.Float64()
+	c.mu.Unlock()
+	for _, f := range c.faults {
+		if r < f.p {
+			return f.name
+		}
+		r -= f.p
+	}
+	return ""
+}
+
+type chaosTransport struct {
+	next http.RoundTripper
+	c    *chaosConfig
+}
+
+func (t chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.c.apiOnly && !strings.HasPrefix(req.URL.String(), apiBase) {
+		return t.next.RoundTrip(req)
+	}
+	fault := t.c.pick()
+	if fault == "" {
+		return t.next.RoundTrip(req)
+	}
+	logm(msgChaosFault, fault, req.Method, req.URL.Path)
+	switch fault {
+	case "429":
+		resp := jsonResponse(req, 429, map[string]string{"id": "too_many_requests", "message": "chaos: injected rate limit"})
+		resp.Header.Set("Retry-After", "1")
+		return resp, nil
+	case "5xx":
+		return jsonResponse(req, 503, map[string]string{"id": "service_unavailable", "message": "chaos: injected server error"}), nil
+	case "timeout":
+		ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+		defer cancel()
+		<-ctx.Done()
+		return nil, fmt.Errorf("chaos: injected timeout: %w", context.DeadlineExceeded)
+	case "reset":
+		return nil, errors.New("chaos: injected connection reset")
+	}
+
+	// malformed: the request really happens, only its answer is lost.
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(append(body[:len(body)/2:len(body)/2], "\x00{]chaos"...)))
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileConfig is the --config file. Top-level settings default every record;
+// unset values fall back to the corresponding flag / env variable.
+type fileConfig struct {
+	Token             string       `json:"token,omitempty"`
+	IPSource          string       `json:"ip_source,omitempty"`
+	CrossCheck        string       `json:"cross_check,omitempty"`
+	StateDir          string       `json:"state_dir,omitempty"`
+	TTL               int          `json:"ttl,omitempty"`
+	CreateTTL         int          `json:"create_ttl,omitempty"`
+	CreatePriority    int          `json:"create_priority,omitempty"`
+	PerPage           int          `json:"per_page,omitempty"`
+	MaxRetries        int          `json:"max_retries,omitempty"`
+	CleanupDuplicates bool         `json:"cleanup_duplicates,omitempty"`
+	PreserveTTL       bool         `json:"preserve_ttl,omitempty"`
+	OnConflict        string       `json:"on_conflict,omitempty"`
+	GroupPolicy       string       `json:"group_policy,omitempty"`
+	ChangeWindow      string       `json:"change_window,omitempty"`
+	Settle            duration     `json:"settle,omitempty"`
+	Notify            notifyConfig `json:"notify,omitzero"`
+
+	// Networks are named network profiles records can be limited to.
+	Networks map[string]networkProfile `json:"networks,omitempty"`
+
+	Records []recordEntry `json:"records"`
+}
+
+// recordEntry is one managed record in the config file.
+type recordEntry struct {
+	Domain            string     `json:"domain"`
+	Name              string     `json:"name"`
+	Type              stringList `json:"type,omitempty"`
+	TTL               int        `json:"ttl,omitempty"`
+	CreateTTL         int        `json:"create_ttl,omitempty"`
+	CreatePriority    int        `json:"create_priority,omitempty"`
+	Data              string     `json:"data,omitempty"`
+	DataFrom          string     `json:"data_from,omitempty"`
+	CleanupDuplicates *bool      `json:"cleanup_duplicates,omitempty"`
+	PreserveTTL       *bool      `json:"preserve_ttl,omitempty"`
+	OnConflict        string     `json:"on_conflict,omitempty"`
+	Group             string     `json:"group,omitempty"`
+	ExpiresIn         duration   `json:"expires_in,omitempty"`
+	// Networks limits the record to these network profiles.
+	Networks stringList `json:"networks,omitempty"`
+
+	// Targets are named values for `do-ddns switch`, e.g.
+	// {blue: "https://api64.ipify.org", green: "203.0.113.10,2001:db8::10"}.
+	Targets       map[string]string `json:"targets,omitempty"`
+	DefaultTarget string            `json:"default_target,omitempty"`
+
+	// Rotate spreads the record over several addresses, one per run.
+	Rotate *rotationConfig `json:"rotate,omitempty"`
+	// Failover publishes the first healthy uplink of a multi-WAN site.
+	Failover *failoverConfig `json:"failover,omitempty"`
+	// Addresses publishes several of the host's IPv6 addresses as AAAA.
+	Addresses *addressSet `json:"addresses,omitempty"`
+}
+
+// stringList accepts either a YAML list or a comma-separated string, so
+// `type: [A, AAAA]` and `type: A,AAAA` mean the same thing.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = splitList(s)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("expected a string or a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+// loadConfigFile reads a YAML (or JSON) config file, or several
+// comma-separated ones layered as described in overlay.go.
+func loadConfigFile(path string) (*fileConfig, error) {
+	var merged any
+	for _, p := range splitList(path) {
+		v, err := readConfigValue(p)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeConfig(merged, v)
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var fc fileConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &fc, nil
+}
+
+// readConfigValue parses one config file into generic maps and lists.
+func readConfigValue(path string) (any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return v, nil
+	}
+	v, err := parseYAML(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return v, nil
+}
+
+// recordConfigs expands the file into one Config per record, layered on top
+// of base (flags and env).
+func (fc *fileConfig) recordConfigs(base Config) ([]Config, error) {
+	return fc.expand(base, true)
+}
+
+// expand is recordConfigs, optionally without requiring a token (for
+// `do-ddns plan`, which never talks to the API).
+func (fc *fileConfig) expand(base Config, needToken bool) ([]Config, error) {
+	if fc.Token != "" {
+		base.Token = fc.Token
+	}
+	if fc.IPSource != "" {
+		base.IPSource = fc.IPSource
+	}
+	if fc.CrossCheck != "" {
+		base.CrossCheck = fc.CrossCheck
+	}
+	if fc.StateDir != "" {
+		base.StateDir = fc.StateDir
+	}
+	if fc.TTL > 0 {
+		base.TTL = fc.TTL
+	}
+	if fc.CreateTTL > 0 {
+		base.CreateTTL = fc.CreateTTL
+	}
+	if fc.CreatePriority > 0 {
+		base.CreatePriority = fc.CreatePriority
+	}
+	if fc.PerPage > 0 {
+		base.PerPage = fc.PerPage
+	}
+	if fc.MaxRetries > 0 {
+		base.MaxRetries = fc.MaxRetries
+	}
+	if fc.CleanupDuplicates {
+		base.CleanupDuplicates = true
+	}
+	if fc.PreserveTTL {
+		base.PreserveTTL = true
+	}
+	if fc.OnConflict != "" {
+		p, err := parseConflictPolicy(fc.OnConflict)
+		if err != nil {
+			return nil, fmt.Errorf("on_conflict: %w", err)
+		}
+		base.OnConflict = p
+	}
+	if fc.GroupPolicy != "" {
+		p, err := parseGroupPolicy(fc.GroupPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("group_policy: %w", err)
+		}
+		base.GroupPolicy = p
+	} else {
+		base.GroupPolicy = groupDegrade
+	}
+	if fc.Settle > 0 {
+		base.Settle = time.Duration(fc.Settle)
+	}
+	if fc.ChangeWindow != "" {
+		w, err := parseChangeWindow(fc.ChangeWindow)
+		if err != nil {
+			return nil, fmt.Errorf("change_window: %w", err)
+		}
+		base.ChangeWindow = w
+	}
+
+	for name, p := range fc.Networks {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("networks.%s: %w", name, err)
+		}
+	}
+	base.NetworkProfiles = fc.Networks
+
+	if needToken && strings.TrimSpace(base.Token) == "" {
+		return nil, errors.New("token is required (config file, DO_TOKEN or --token)")
+	}
+	if len(fc.Records) == 0 {
+		return nil, errors.New("config file defines no records")
+	}
+
+	seen := map[string]bool{}
+	var out []Config
+	for i, r := range fc.Records {
+		if r.Domain == "" || r.Name == "" {
+			return nil, fmt.Errorf("records[%d]: domain and name are required", i)
+		}
+		c := base
+		var err error
+		if c.Domain, err = toASCII(r.Domain); err != nil {
+			return nil, fmt.Errorf("records[%d]: domain: %w", i, err)
+		}
+		if c.Name, err = toASCII(r.Name); err != nil {
+			return nil, fmt.Errorf("records[%d]: name: %w", i, err)
+		}
+		c.Data, c.DataFrom = r.Data, r.DataFrom
+		c.Group = strings.TrimSpace(r.Group)
+		if r.TTL > 0 {
+			c.TTL = r.TTL
+		}
+		if r.CreateTTL > 0 {
+			c.CreateTTL = r.CreateTTL
+		}
+		if r.CreatePriority > 0 {
+			c.CreatePriority = r.CreatePriority
+		}
+		if r.CleanupDuplicates != nil {
+			c.CleanupDuplicates = *r.CleanupDuplicates
+		}
+		if r.PreserveTTL != nil {
+			c.PreserveTTL = *r.PreserveTTL
+		}
+		if r.OnConflict != "" {
+			p, err := parseConflictPolicy(r.OnConflict)
+			if err != nil {
+				return nil, fmt.Errorf("records[%d]: on_conflict: %w", i, err)
+			}
+			c.OnConflict = p
+		}
+		if r.ExpiresIn > 0 {
+			c.ExpiresIn = time.Duration(r.ExpiresIn)
+		}
+		for _, n := range r.Networks {
+			if _, ok := fc.Networks[n]; !ok {
+				return nil, fmt.Errorf("records[%d]: network %q is not defined under networks", i, n)
+			}
+		}
+		c.Networks = r.Networks
+		c.Targets = r.Targets
+		c.DefaultTarget = r.DefaultTarget
+		if _, ok := r.Targets[r.DefaultTarget]; r.DefaultTarget != "" && !ok {
+			return nil, fmt.Errorf("records[%d]: default_target %q is not one of its targets", i, r.DefaultTarget)
+		}
+		if r.Rotate != nil {
+			if err := r.Rotate.validate(); err != nil {
+				return nil, fmt.Errorf("records[%d]: %w", i, err)
+			}
+			c.Rotate = r.Rotate
+		}
+		if r.Failover != nil {
+			if r.Rotate != nil {
+				return nil, fmt.Errorf("records[%d]: rotate and failover cannot be combined", i)
+			}
+			if err := r.Failover.validate(); err != nil {
+				return nil, fmt.Errorf("records[%d]: %w", i, err)
+			}
+			c.Failover = r.Failover
+		}
+		if r.Addresses != nil {
+			if r.Rotate != nil || r.Failover != nil || len(r.Targets) > 0 {
+				return nil, fmt.Errorf("records[%d]: addresses cannot be combined with rotate, failover or targets", i)
+			}
+			if err := r.Addresses.validate(); err != nil {
+				return nil, fmt.Errorf("records[%d]: %w", i, err)
+			}
+			c.Addresses = r.Addresses
+		}
+		c.Types = nil
+		for _, t := range r.Type {
+			c.Types = append(c.Types, strings.ToUpper(strings.TrimSpace(t)))
+		}
+		if len(c.Types) == 0 {
+			c.Types = []string{"A"}
+		}
+		c.Type = c.Types[0]
+		if err := checkDataFrom(c); err != nil {
+			return nil, fmt.Errorf("records[%d]: %w", i, err)
+		}
+
+		// A name can have several entries (e.g. an A record and its TXT
+		// ownership marker), but each type only once.
+		for _, t := range c.Types {
+			key := recordKey(c.Domain, c.Name, t)
+			if seen[key] {
+				return nil, fmt.Errorf("records[%d]: %s is defined more than once", i, key)
+			}
+			seen[key] = true
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runConfig implements `do-ddns config schema|show|from-record|messages`.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return withExitCode(2, errors.New("usage: do-ddns config schema|show|from-record|messages [flags]"))
+	}
+	switch args[0] {
+	case "schema":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configSchema())
+	case "show":
+		return runConfigShow(args[1:])
+	case "from-record":
+		return runConfigFromRecord(args[1:])
+	case "messages":
+		writeMessages()
+		return nil
+	}
+	return withExitCode(2, errors.New("usage: do-ddns config schema|show|from-record|messages [flags]"))
+}
+
+// runConfigShow prints the config after merging overlays, secrets redacted.
+func runConfigShow(args []string) error {
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	configPath := new(string)
+	configFlag(fs, configPath, "Config file; repeat to layer overlays (or env DO_CONFIG, comma-separated)")
+	fs.Parse(args)
+	if *configPath == "" {
+		return withExitCode(2, errors.New("config show: --config is required"))
+	}
+	fc, err := loadConfigFile(*configPath)
+	if err != nil {
+		return withExitCode(2, err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(redactConfig(*fc))
+}
+
+// managedTypes are the record types the updater can reconcile.
+var managedTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+// runConfigFromRecord prints a config file entry reproducing a live record,
+// ready to paste under `records:`.
+func runConfigFromRecord(args []string) error {
+	fs := flag.NewFlagSet("config from-record", flag.ExitOnError)
+	cfg := recordFlags(fs)
+	fs.Parse(args)
+	typeSet := false
+	fs.Visit(func(f *flag.Flag) { typeSet = typeSet || f.Name == "type" })
+	requireRecord(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+	defer cancel()
+	recs, err := listRecords(ctx, *cfg, cfg.Name)
+	if err != nil {
+		return withExitCode(4, fmt.Errorf("listing records: %w", err))
+	}
+
+	var found []DomainRecord
+	for _, r := range recs {
+		if r.Name == cfg.Name && (!typeSet || r.Type == cfg.Type) {
+			found = append(found, r)
+		}
+	}
+	if len(found) == 0 {
+		return withExitCode(2, fmt.Errorf("no records named %s in %s", cfg.Name, cfg.Domain))
+	}
+	writeRecordEntry(os.Stdout, cfg.Domain, cfg.Name, found)
+	return nil
+}
+
+func writeRecordEntry(w io.Writer, domain, name string, found []DomainRecord) {
+	slices.SortFunc(found, func(a, b DomainRecord) int {
+		return cmp.Or(strings.Compare(a.Type, b.Type), cmp.Compare(a.ID, b.ID))
+	})
+
+	var types []string
+	var data string
+	ttl := 0
+	var notes []string
+	for _, r := range found {
+		fmt.Fprintf(w, "  # %s %s -> %s (id=%d, ttl=%d)\n", r.Type, fqdn(name, domain), r.Data, r.ID, r.TTL)
+		switch {
+		case !slices.Contains(managedTypes, r.Type):
+			notes = append(notes, fmt.Sprintf("%s records are not managed by do-ddns; left out", r.Type))
+			continue
+		case slices.Contains(types, r.Type):
+			notes = append(notes, fmt.Sprintf("duplicate %s record id=%d; consider cleanup_duplicates: true", r.Type, r.ID))
+			continue
+		case !isAddressType(r.Type) && data != "":
+			notes = append(notes, fmt.Sprintf("only one static value per entry; %s left out", r.Type))
+			continue
+		}
+		types = append(types, r.Type)
+		if !isAddressType(r.Type) {
+			data = r.Data
+			if r.Type == "TXT" {
+				data = parseTXT(r.Data)
+			}
+		}
+		if ttl == 0 {
+			ttl = r.TTL
+		} else if r.TTL != ttl {
+			notes = append(notes, fmt.Sprintf("%s has ttl=%d; the entry uses %d", r.Type, r.TTL, ttl))
+		}
+	}
+	for _, n := range notes {
+		fmt.Fprintf(w, "  # NOTE: %s\n", n)
+	}
+	if len(types) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "  - domain: %s\n", yamlString(domain))
+	fmt.Fprintf(w, "    name: %s\n", yamlString(name))
+	if len(types) == 1 {
+		fmt.Fprintf(w, "    type: %s\n", types[0])
+	} else {
+		fmt.Fprintf(w, "    type: [%s]\n", strings.Join(types, ", "))
+	}
+	if ttl > 0 {
+		fmt.Fprintf(w, "    ttl: %d\n", ttl)
+	}
+	if data != "" {
+		fmt.Fprintf(w, "    data: %s\n", yamlString(data))
+	}
+}
+
+// yamlString returns s as a YAML scalar, quoting it unless it is plainly
+// safe.
+func yamlString(s string) string {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".-_", c)) {
+			return strconv.Quote(s)
+		}
+	}
+	if s == "" || s == "true" || s == "false" || s == "null" || strings.Trim(s, "0123456789.") == "" {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Policies for a record that was changed by something else between listing
+// and updating it.
+const (
+	conflictRetry     = "retry"
+	conflictAbort     = "abort"
+	conflictOverwrite = "overwrite"
+)
+
+func parseConflictPolicy(s string) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(s)); p {
+	case "":
+		return conflictRetry, nil
+	case conflictRetry, conflictAbort, conflictOverwrite:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q is not one of retry, abort, overwrite", s)
+}
+
+// conflictRetryDelay is how long a retry gives the other writer before the
+// record is read again.
+var conflictRetryDelay = 2 * time.Second
+
+const maxConflictRetries = 3
+
+// recheckRecord reads r again right before it is changed, so that an edit
+// made since the listing (in the console, by Terraform, by another do-ddns)
+// is not silently overwritten. It returns the record as it is now. When it
+// differs from r, cfg.OnConflict decides:
+//
+//   - retry: wait until two reads agree and carry on from the fresh record
+//   - abort: fail the record, leaving the other edit in place
+//   - overwrite: nothing is re-read (the behaviour before the guard)
+func recheckRecord(ctx context.Context, cfg Config, r DomainRecord) (DomainRecord, error) {
+	if cfg.OnConflict == conflictOverwrite {
+		return r, nil
+	}
+	prev := r
+	for attempt := 0; ; attempt++ {
+		cur, err := getRecord(ctx, cfg, r.ID)
+		if isNotFound(err) {
+			return r, withExitCode(6, fmt.Errorf("%s %s id=%d was deleted by something else since it was listed", cfg.Type, fqdn(cfg.Name, cfg.Domain), r.ID))
+		}
+		if err != nil {
+			return r, withExitCode(6, fmt.Errorf("re-reading record id=%d: %w", r.ID, err))
+		}
+		if sameData(cfg.Type, cur.Data, prev.Data) && cur.TTL == prev.TTL {
+			return cur, nil
+		}
+		logm(msgRecordConflict, cfg.Type, cfg.Name, cfg.Domain, r.ID, describeRecord(prev), describeRecord(cur))
+		if cfg.OnConflict == conflictAbort {
+			return cur, withExitCode(6, fmt.Errorf("%s %s id=%d changed since it was listed; not overwriting it (on_conflict: abort)", cfg.Type, fqdn(cfg.Name, cfg.Domain), r.ID))
+		}
+		if attempt == maxConflictRetries {
+			return cur, withExitCode(6, fmt.Errorf("%s %s id=%d kept changing; giving up after %d re-reads", cfg.Type, fqdn(cfg.Name, cfg.Domain), r.ID, attempt+1))
+		}
+		prev = cur
+		select {
+		case <-ctx.Done():
+			return cur, ctx.Err()
+		case <-time.After(conflictRetryDelay):
+		}
+	}
+}
+
+func describeRecord(r DomainRecord) string {
+	return fmt.Sprintf("%s ttl=%d", r.Data, r.TTL)
+}
+
+// replaceRecord returns a copy of recs with the record of r's ID replaced
+// by r.
+func replaceRecord(recs []DomainRecord, r DomainRecord) []DomainRecord {
+	out := make([]DomainRecord, len(recs))
+	for i, x := range recs {
+		if x.ID == r.ID {
+			x = r
+		}
+		out[i] = x
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecheckRecord(t *testing.T) {
+	delay := conflictRetryDelay
+	conflictRetryDelay = time.Millisecond
+	t.Cleanup(func() { conflictRetryDelay = delay })
+
+	listed := DomainRecord{ID: 1, Type: "A", Name: "hq", Data: "192.0.2.1", TTL: 300}
+	tests := []struct {
+		name     string
+		policy   string
+		current  *DomainRecord // nil: deleted since the listing
+		churn    bool          // the record changes on every read
+		wantData string
+		wantErr  string
+		wantGets int
+	}{
+		{name: "unchanged", policy: conflictRetry, current: &listed,
+			wantData: "192.0.2.1", wantGets: 1},
+		{name: "retry", policy: conflictRetry, current: &DomainRecord{ID: 1, Type: "A", Name: "hq", Data: "198.51.100.2", TTL: 300},
+			wantData: "198.51.100.2", wantGets: 2},
+		{name: "retry on a TTL change", policy: conflictRetry, current: &DomainRecord{ID: 1, Type: "A", Name: "hq", Data: "192.0.2.1", TTL: 60},
+			wantData: "192.0.2.1", wantGets: 2},
+		{name: "abort", policy: conflictAbort, current: &DomainRecord{ID: 1, Type: "A", Name: "hq", Data: "198.51.100.2", TTL: 300},
+			wantData: "198.51.100.2", wantErr: "on_conflict: abort", wantGets: 1},
+		{name: "overwrite", policy: conflictOverwrite, current: &DomainRecord{ID: 1, Type: "A", Name: "hq", Data: "198.51.100.2", TTL: 300},
+			wantData: "192.0.2.1", wantGets: 0},
+		{name: "give up", policy: conflictRetry, current: &listed, churn: true,
+			wantErr: fmt.Sprintf("giving up after %d re-reads", maxConflictRetries+1), wantGets: maxConflictRetries + 1},
+		{name: "deleted", policy: conflictRetry,
+			wantErr: "was deleted by something else", wantGets: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &zone{}
+			if tt.current != nil {
+				z.records = []DomainRecord{*tt.current}
+			}
+			gets := 0
+			z.hook = func(r *http.Request) {
+				if r.Method != "GET" {
+					return
+				}
+				z.mu.Lock()
+				defer z.mu.Unlock()
+				gets++
+				if tt.churn {
+					z.records[0].Data = fmt.Sprintf("203.0.113.%d", gets)
+				}
+			}
+			fakeAPI(t, z)
+			cfg := testConfig()
+			cfg.Name, cfg.Type, cfg.OnConflict = "hq", "A", tt.policy
+
+			got, err := recheckRecord(context.Background(), cfg, listed)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil && exitCode(err) != 6 {
+				t.Errorf("exit code %d, want 6", exitCode(err))
+			}
+			if tt.wantData != "" && got.Data != tt.wantData {
+				t.Errorf("record data %q, want %q", got.Data, tt.wantData)
+			}
+			if gets != tt.wantGets {
+				t.Errorf("%d reads, want %d", gets, tt.wantGets)
+			}
+		})
+	}
+}
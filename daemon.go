@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemonStatus is the in-memory view of the daemon, served on /status.
+type daemonStatus struct {
+	mu sync.Mutex
+
+	started   time.Time
+	lastCheck time.Time
+	lastError string
+	lastRunID string
+	records   map[string]*recordStatus // by recordKey
+	groups    map[string]*groupStatus  // config groups, by name, as of the last check
+	// seeded holds the last-IP state read at startup, by recordKey, for
+	// records that have no result yet.
+	seeded map[string]string
+
+	interval     time.Duration
+	checkStarted time.Time // zero while sleeping
+	nextRun      time.Time
+
+	// Counters for /metrics.
+	checks, checkFailures int64
+	results, skips        recordCounter
+}
+
+type recordStatus struct {
+	Domain     string `json:"domain"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	IP         string `json:"ip,omitempty"`
+	LastResult string `json:"last_result,omitempty"`
+	SkipReason string `json:"skip_reason,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	Note       string `json:"note,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+	Group      string `json:"group,omitempty"`
+
+	ok bool
+}
+
+// groupStatus is a config group's state after the last check: ok, degraded
+// or rolled-back.
+type groupStatus struct {
+	Name   string   `json:"name"`
+	State  string   `json:"state"`
+	Failed []string `json:"failed,omitempty"`
+}
+
+type statusResponse struct {
+	Started time.Time `json:"started"`
+	// State is "checking" while a check cycle runs, "sleeping" between
+	// cycles. NextRun is only set while sleeping.
+	State        string      `json:"state"`
+	Interval     string      `json:"interval"`
+	CheckStarted time.Time   `json:"check_started,omitzero"`
+	NextRun      time.Time   `json:"next_run,omitzero"`
+	Retrying     []retryWait `json:"retrying,omitempty"`
+
+	LastCheck  time.Time      `json:"last_check,omitzero"`
+	LastResult string         `json:"last_result,omitempty"`
+	LastRunID  string         `json:"last_run_id,omitempty"`
+	LastError  string         `json:"last_error,omitempty"`
+	Records    []recordStatus `json:"records"`
+	Groups     []groupStatus  `json:"groups,omitempty"`
+}
+
+func (s *daemonStatus) record(results []runResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Now()
+	s.lastRunID = runID()
+	s.lastError = ""
+	s.checks++
+	if err != nil {
+		s.lastError = err.Error()
+		s.checkFailures++
+	}
+	s.groups = map[string]*groupStatus{}
+	for _, res := range results {
+		rs := s.records[res.key()]
+		if rs == nil {
+			rs = &recordStatus{Domain: res.Domain, Name: res.Name, Type: res.Type}
+			s.records[res.key()] = rs
+		}
+		if res.IP != "" {
+			rs.IP = res.IP
+		}
+		rs.Note, rs.Owner, rs.Group = res.Note, res.Owner, res.Group
+		if res.Group != "" {
+			g := s.groups[res.Group]
+			if g == nil {
+				g = &groupStatus{Name: res.Group, State: res.GroupState}
+				s.groups[res.Group] = g
+			}
+			if res.Err != nil || res.Action == "aborted" {
+				g.Failed = append(g.Failed, res.key())
+			}
+		}
+		rs.SkipReason = res.skipReason()
+		s.results.add(res.key(), res.resultName())
+		if rs.SkipReason != "" {
+			s.skips.add(res.key(), rs.SkipReason)
+		}
+		if res.Err != nil {
+			rs.LastResult = "error"
+			rs.LastError = res.Err.Error()
+			rs.ok = false
+			continue
+		}
+		rs.LastResult = res.Action
+		rs.LastError = ""
+		// Deferred changes need another reconcile once the window opens,
+		// rolled-back ones need creating again, and cooled-down ones were
+		// not checked at all.
+		rs.ok = len(res.Deferred) == 0 && res.Action != "rolled-back" && res.Action != "cooldown"
+	}
+}
+
+func (s *daemonStatus) checking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkStarted = time.Now()
+}
+
+func (s *daemonStatus) sleeping(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkStarted, s.nextRun = time.Time{}, next
+}
+
+func (s *daemonStatus) snapshot() statusResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := statusResponse{
+		Started:   s.started,
+		State:     "sleeping",
+		Interval:  s.interval.String(),
+		NextRun:   s.nextRun,
+		Retrying:  activeRetries(),
+		LastCheck: s.lastCheck,
+		LastError: s.lastError,
+		LastRunID: s.lastRunID,
+		Records:   []recordStatus{},
+	}
+	if !s.checkStarted.IsZero() {
+		out.State, out.CheckStarted, out.NextRun = "checking", s.checkStarted, time.Time{}
+	}
+	if !s.lastCheck.IsZero() {
+		out.LastResult = "ok"
+		if s.lastError != "" {
+			out.LastResult = "error"
+		}
+	}
+	for _, rs := range s.records {
+		out.Records = append(out.Records, *rs)
+	}
+	sort.Slice(out.Records, func(i, j int) bool {
+		a, b := out.Records[i], out.Records[j]
+		return recordKey(a.Domain, a.Name, a.Type) < recordKey(b.Domain, b.Name, b.Type)
+	})
+	for _, g := range s.groups {
+		out.Groups = append(out.Groups, *g)
+	}
+	sort.Slice(out.Groups, func(i, j int) bool { return out.Groups[i].Name < out.Groups[j].Name })
+	return out
+}
+
+// publishedIPs returns, per recordKey, the IP known to be in DigitalOcean.
+// Records whose last run failed are left out so they get reconciled again.
+func (s *daemonStatus) publishedIPs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]string{}
+	for k, ip := range s.seeded {
+		if s.records[k] == nil {
+			out[k] = ip
+		}
+	}
+	for k, rs := range s.records {
+		if rs.ok {
+			out[k] = rs.IP
+		}
+	}
+	return out
+}
+
+// lastPublished reads the last-IP state of every record, so a restarted
+// daemon does not call DigitalOcean for values it already published.
+func lastPublished(records []Config) map[string]string {
+	out := map[string]string{}
+	for _, cfg := range records {
+		for _, t := range cfg.Types {
+			c := cfg
+			c.Type = t
+			ip, err := readLastIP(stateFile(c))
+			if err != nil {
+				warnf("%s", msg(msgStateReadFailed, err))
+				continue
+			}
+			if ip != "" {
+				out[recordKey(c.Domain, c.Name, t)] = ip
+			}
+		}
+	}
+	return out
+}
+
+// newFailures drops failed results whose record was already failing with the
+// same error, so a persistent outage notifies once instead of every interval.
+func (s *daemonStatus) newFailures(results []runResult) []runResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []runResult
+	for _, r := range results {
+		if rs := s.records[r.key()]; r.Err != nil && rs != nil && !rs.ok && rs.LastError == r.Err.Error() {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// runDaemon reconciles records every cfg.Interval (plus jitter) until ctx is
+// cancelled. The scheduler and the status endpoint run under one supervisor:
+// if either fails the other is stopped too, and on shutdown the scheduler
+// finishes its current check before the endpoint stops answering.
+func runDaemon(ctx context.Context, cfg Config, records []Config, notify notifyConfig) error {
+	st := &daemonStatus{started: time.Now(), interval: cfg.Interval, records: map[string]*recordStatus{}, results: recordCounter{}, skips: recordCounter{}}
+	st.seeded = lastPublished(records)
+	if len(st.seeded) > 0 {
+		logm(msgDaemonSeeded, len(st.seeded))
+	}
+
+	var srv *http.Server
+	var ln net.Listener
+	if cfg.Listen != "" {
+		var err error
+		if ln, err = net.Listen("tcp", cfg.Listen); err != nil {
+			return fmt.Errorf("status endpoint: %w", err)
+		}
+		srv = &http.Server{
+			Handler:           statusHandler(st),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		logm(msgDaemonListening, cfg.Listen)
+	}
+
+	sup, ctx := newSupervisor(ctx)
+	schedulerDone := make(chan struct{})
+	sup.Go("scheduler", func(ctx context.Context) error {
+		defer close(schedulerDone)
+		schedule(ctx, cfg, records, notify, st)
+		return nil
+	})
+	if cfg.StateSyncInterval > 0 {
+		stateSync.enable()
+		sup.Go("state sync", func(ctx context.Context) error {
+			syncState(ctx, cfg.StateSyncInterval, schedulerDone)
+			return nil
+		})
+	}
+	if srv != nil {
+		sup.Go("status endpoint", func(ctx context.Context) error {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+		sup.Go("status shutdown", func(ctx context.Context) error {
+			<-ctx.Done()
+			<-schedulerDone
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				warnf("%s", msg(msgStatusShutdown, err))
+			}
+			return nil
+		})
+	}
+
+	logm(msgDaemonStarted, len(records), cfg.Interval)
+	return sup.Wait()
+}
+
+// schedule is the daemon's check loop; it returns once ctx is cancelled.
+func schedule(ctx context.Context, cfg Config, records []Config, notify notifyConfig, st *daemonStatus) {
+	for {
+		startRun()
+		warnings.Store(0)
+		st.checking()
+		res, err := runAll(ctx, records, st.publishedIPs())
+		if err == nil && cfg.Strict {
+			err = strictError()
+		}
+		if ctx.Err() == nil {
+			if err != nil {
+				logf("ERROR: %v", err)
+			}
+			notify.notify(ctx, st.newFailures(res))
+			st.record(res, err)
+		}
+		if cfg.StateSyncInterval == 0 {
+			apiUsage.flush()
+		}
+
+		wait := withJitter(cfg.Interval)
+		st.sleeping(time.Now().Add(wait))
+		if err := sleepCtx(ctx, wait); err != nil {
+			break
+		}
+	}
+	logm(msgDaemonStopping)
+}
+
+func statusHandler(st *daemonStatus) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(st.snapshot())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		st.writeMetrics(w)
+	})
+	return mux
+}
+
+// retryWait is an API request waiting to be retried, shown on /status so a
+// check stuck in a retry loop can be told apart from one that is just slow.
+type retryWait struct {
+	Request    string    `json:"request"` // e.g. "PUT /v2/domains/example.com/records/1"
+	Attempt    int       `json:"attempt"`
+	MaxRetries int       `json:"max_retries"`
+	Reason     string    `json:"reason"`
+	Until      time.Time `json:"until"`
+}
+
+var (
+	retriesMu sync.Mutex
+	retries   = map[*retryWait]bool{}
+)
+
+// sleepRetry is sleepCtx for a request backing off before its next attempt.
+func sleepRetry(ctx context.Context, w retryWait, d time.Duration) error {
+	w.Until = time.Now().Add(d)
+	retriesMu.Lock()
+	retries[&w] = true
+	retriesMu.Unlock()
+	defer func() {
+		retriesMu.Lock()
+		delete(retries, &w)
+		retriesMu.Unlock()
+	}()
+	return sleepCtx(ctx, d)
+}
+
+func urlPath(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Path
+	}
+	return raw
+}
+
+func activeRetries() []retryWait {
+	retriesMu.Lock()
+	defer retriesMu.Unlock()
+	var out []retryWait
+	for w := range retries {
+		out = append(out, *w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Until.Before(out[j].Until) })
+	return out
+}
+
+// withJitter spreads d by up to ±10% so a fleet of daemons started together
+// does not hit the IP source and the API in lockstep.
+func withJitter(d time.Duration) time.Duration {
+	j := d / 10
+	if j <= 0 {
+		return d
+	}
+	return d - j + rand.N(2*j)
+}
+
+// sleepCtx sleeps for d or until ctx is done, whichever comes first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// runStatus implements `do-ddns status`: it asks a running daemon for its
+// /status and prints it.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	addr := fs.String("addr", os.Getenv("LISTEN"), "The daemon's --listen address (or env LISTEN)")
+	asJSON := fs.Bool("json", false, "Print the raw /status JSON")
+	fs.Parse(args)
+	if *addr == "" {
+		return withExitCode(2, errors.New("status: --addr is required, e.g. --addr :8080"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", statusURL(*addr), nil)
+	if err != nil {
+		return withExitCode(2, err)
+	}
+	setRequestHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("status: is the daemon running? %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: HTTP %d", resp.StatusCode)
+	}
+	var st statusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	printStatus(st, time.Now())
+	return nil
+}
+
+// statusURL turns a listen address such as ":8080" into the /status URL.
+func statusURL(addr string) string {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return strings.TrimSuffix(addr, "/") + "/status"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr + "/status"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/status"
+}
+
+func printStatus(st statusResponse, now time.Time) {
+	ago := func(t time.Time) string { return now.Sub(t).Round(time.Second).String() }
+	switch st.State {
+	case "checking":
+		fmt.Printf("State:      checking (for %s)\n", ago(st.CheckStarted))
+	default:
+		fmt.Printf("State:      %s, next run %s (in %s)\n", st.State, st.NextRun.Format(time.RFC3339), st.NextRun.Sub(now).Round(time.Second))
+	}
+	fmt.Printf("Interval:   %s (up %s)\n", st.Interval, ago(st.Started))
+	if st.LastCheck.IsZero() {
+		fmt.Println("Last check: none yet")
+	} else {
+		fmt.Printf("Last check: %s, %s ago (run %s)\n", st.LastResult, ago(st.LastCheck), st.LastRunID)
+	}
+	if st.LastError != "" {
+		fmt.Printf("Last error: %s\n", st.LastError)
+	}
+	for _, r := range st.Retrying {
+		fmt.Printf("Retrying:   %s attempt %d/%d, %s; next try in %s\n",
+			r.Request, r.Attempt, r.MaxRetries, r.Reason, r.Until.Sub(now).Round(time.Second))
+	}
+	for _, r := range st.Records {
+		line := fmt.Sprintf("  %-5s %s  %s  %s", r.Type, displayName(r.Name, r.Domain), orNone(r.IP), r.LastResult)
+		if r.LastError != "" {
+			line += ": " + r.LastError
+		} else if r.SkipReason != "" && r.SkipReason != r.LastResult {
+			line += " (" + r.SkipReason + ")"
+		}
+		if r.Note != "" {
+			line += "  # " + r.Note
+		}
+		fmt.Println(line)
+	}
+	for _, g := range st.Groups {
+		line := fmt.Sprintf("  group %s: %s", g.Name, g.State)
+		if len(g.Failed) > 0 {
+			line += " (failed: " + strings.Join(g.Failed, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+}
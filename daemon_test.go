@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (r rewriteHost) CloseIdleConnections() {
+	if c, ok := r.rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// TestRunDaemonShutdown checks how runDaemon stops: the scheduler finishes
+// first (notifications are sent by the scheduler itself, so they are done by
+// then), the status endpoint keeps answering until it has, and no goroutine
+// is left once runDaemon returns.
+func TestRunDaemonShutdown(t *testing.T) {
+	dir := t.TempDir()
+	value := filepath.Join(dir, "value")
+	if err := os.WriteFile(value, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	lists := 0
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var unblock sync.Once
+	unblockAll := func() {
+		unblock.Do(func() {
+			close(release)
+			stateMu.Unlock()
+		})
+	}
+	notified := make(chan string, 4)
+	z := &zone{}
+	z.hook = func(r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != recordsPath {
+			return
+		}
+		z.mu.Lock()
+		lists++
+		n := lists
+		z.mu.Unlock()
+		if n == 2 {
+			// Hold the state lock so the scheduler, once cancelled, is
+			// stuck writing its state and has not returned yet.
+			once.Do(func() {
+				stateMu.Lock()
+				close(blocked)
+			})
+			<-release
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		notified <- r.URL.Path
+	})
+	mux.Handle("/", z)
+	fakeAPI(t, mux)
+	// Registered after fakeAPI so it runs first: a failed check must not
+	// leave the handler and the state lock blocked.
+	t.Cleanup(func() {
+		select {
+		case <-blocked:
+			unblockAll()
+		default:
+		}
+	})
+
+	cfg := testConfig()
+	cfg.Name, cfg.Type, cfg.Types, cfg.DataFrom = "a", "TXT", []string{"TXT"}, value
+	cfg.TTL, cfg.StateDir, cfg.Interval, cfg.Listen = 300, dir, 20*time.Millisecond, freeAddr(t)
+	notify := notifyConfig{URL: "http://hooks.invalid/hook", Kind: "webhook"}
+
+	status := &http.Client{Timeout: time.Second}
+	healthy := func() bool {
+		resp, err := status.Get("http://" + cfg.Listen + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}
+
+	base := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- runDaemon(ctx, cfg, []Config{cfg}, notify) }()
+
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first check sent no notification")
+	}
+	// A new value makes the next check call the API again.
+	if err := os.WriteFile(value, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second check did not list the records")
+	}
+
+	cancel()
+	for range 5 {
+		select {
+		case err := <-errc:
+			t.Fatalf("runDaemon returned (%v) while the scheduler was still running", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		if !healthy() {
+			t.Fatal("the status endpoint stopped before the scheduler")
+		}
+	}
+
+	unblockAll()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("runDaemon() = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runDaemon did not return after the context was cancelled")
+	}
+	if healthy() {
+		t.Error("the status endpoint still answers after runDaemon returned")
+	}
+
+	status.CloseIdleConnections()
+	http.DefaultClient.CloseIdleConnections()
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > base {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		t.Errorf("%d goroutines after runDaemon returned, %d before:\n%s", n, base, buf)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if len(z.records) != 1 || !strings.Contains(z.records[0].Data, "first") {
+		t.Errorf("zone = %+v, want the record created by the first check", z.records)
+	}
+}
+
+// A restarted daemon starts from the last-IP state instead of an empty
+// cache; a record's own result replaces what was read.
+func TestDaemonSeedsPublishedIPs(t *testing.T) {
+	dir := t.TempDir()
+	value := filepath.Join(dir, "value")
+	if err := os.WriteFile(value, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.Name, cfg.Type, cfg.Types, cfg.DataFrom, cfg.StateDir = "hq", "TXT", []string{"TXT", "CNAME"}, value, dir
+	if err := storeLastIP(stateFile(cfg), "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &daemonStatus{records: map[string]*recordStatus{}, results: recordCounter{}, skips: recordCounter{}}
+	st.seeded = lastPublished([]Config{cfg})
+	key := recordKey("example.com", "hq", "TXT")
+	if got := st.publishedIPs(); len(got) != 1 || got[key] != "hello" {
+		t.Fatalf("publishedIPs() = %v, want only the stored TXT value", got)
+	}
+
+	// No API is set up: the record must be skipped without one.
+	cfg.Types = []string{"TXT"}
+	res, err := runOnce(context.Background(), cfg, st.publishedIPs(), nil)
+	if err != nil || len(res) != 1 || res[0].Action != "skipped" {
+		t.Fatalf("runOnce = %+v, %v: want the record skipped", res, err)
+	}
+
+	st.record([]runResult{{Domain: "example.com", Name: "hq", Type: "TXT", IP: "hello", Err: context.DeadlineExceeded}}, context.DeadlineExceeded)
+	if got := st.publishedIPs(); len(got) != 0 {
+		t.Errorf("publishedIPs() = %v after the record failed, want it reconciled again", got)
+	}
+}
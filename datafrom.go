@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// A record's value can be produced by another local agent instead of the
+// built-in detectors: data_from (--data-from / DO_DATA_FROM) names an
+// http:// or https:// URL whose response body, or a file whose content, is
+// the value. It is read on every run, trimmed, and must be valid for the
+// record type (an IPv4 address for A, a hostname for CNAME, ...); a source
+// that fails or returns something invalid fails the record and leaves it
+// untouched.
+
+// maxDataFromSize bounds what is read from a data_from source.
+const maxDataFromSize = 64 << 10
+
+// checkDataFrom validates how data_from is combined with cfg's other
+// settings.
+func checkDataFrom(cfg Config) error {
+	if cfg.DataFrom == "" {
+		return nil
+	}
+	switch {
+	case cfg.Data != "":
+		return errors.New("data and data_from cannot be combined")
+	case cfg.Rotate != nil || cfg.Failover != nil || cfg.Addresses != nil:
+		return errors.New("data_from cannot be combined with rotate, failover or addresses")
+	case len(cfg.Types) != 1:
+		return fmt.Errorf("data_from needs a single record type, not %s", strings.Join(cfg.Types, ","))
+	}
+	return nil
+}
+
+// fetchData reads cfg's data_from source and validates the value for
+// cfg.Type.
+func fetchData(ctx context.Context, cfg Config) (string, error) {
+	var b []byte
+	var err error
+	src := cfg.DataFrom
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		b, err = fetchDataURL(ctx, src)
+	} else {
+		b, err = readDataFile(strings.TrimPrefix(src, "file://"))
+	}
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+	if err := validateData(cfg.Type, v); err != nil {
+		return "", err
+	}
+	switch {
+	case cfg.Type == "TXT":
+		return parseTXT(v), nil
+	case isAddressType(cfg.Type):
+		return net.ParseIP(v).String(), nil
+	}
+	return v, nil
+}
+
+func fetchDataURL(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req)
+	resp, err := ipClient("tcp", nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return readLimited(resp.Body)
+}
+
+func readDataFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxDataFromSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxDataFromSize {
+		return nil, fmt.Errorf("value is larger than %d bytes", maxDataFromSize)
+	}
+	return b, nil
+}
+
+// validateData checks that v is a plausible value for a recordType record,
+// so an error page or a half-written file is never published.
+func validateData(recordType, v string) error {
+	if v == "" {
+		return errors.New("empty value")
+	}
+	if !utf8.ValidString(v) || strings.ContainsAny(v, "\r\n") {
+		return fmt.Errorf("%s is not a single line of text", quoteValue(v))
+	}
+	switch recordType {
+	case "A", "AAAA":
+		if ip := net.ParseIP(v); ip == nil || (recordType == "A") != (ip.To4() != nil) {
+			return fmt.Errorf("%s is not a valid %s record address", quoteValue(v), recordType)
+		}
+	case "CNAME", "MX", "NS", "SRV":
+		if !validHostname(v) {
+			return fmt.Errorf("%s is not a valid hostname for a %s record", quoteValue(v), recordType)
+		}
+	}
+	return nil
+}
+
+// validHostname reports whether s is a DNS name: dot-separated labels of
+// letters, digits, hyphens and underscores, optionally ending in a dot.
+func validHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// quoteValue quotes v for an error message, shortened if it is long (an
+// error page returned instead of the value).
+func quoteValue(v string) string {
+	if len(v) > 60 {
+		cut := 60
+		for cut > 0 && !utf8.RuneStart(v[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%q...", v[:cut])
+	}
+	return fmt.Sprintf("%q", v)
+}
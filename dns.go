@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// doNameserver is queried directly so verification sees what DigitalOcean
+// serves, not a cached answer from the local resolver.
+const doNameserver = "ns1.digitalocean.com:53"
+
+func authoritativeResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, doNameserver)
+		},
+	}
+}
+
+// verifyResolver is where verification queries go: DigitalOcean's
+// nameserver, or the DNS-over-HTTPS endpoint doh (see dohURL) if set. Public
+// DoH resolvers cache, so with them a change can take up to the previous
+// answer's TTL to verify.
+func verifyResolver(doh string) (r *net.Resolver, name string, err error) {
+	if doh == "" {
+		return authoritativeResolver(), doNameserver, nil
+	}
+	u, err := dohURL(doh)
+	if err != nil {
+		return nil, "", err
+	}
+	return dohResolver(u), u, nil
+}
+
+func fqdn(name, domain string) string {
+	if name == "@" || name == "" {
+		return domain
+	}
+	return name + "." + domain
+}
+
+// lookupRecord returns the values DigitalOcean's nameserver serves for the
+// record, in the same form sameData compares.
+func lookupRecord(ctx context.Context, r *net.Resolver, host, recordType string) ([]string, error) {
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		var out []string
+		for _, ip := range ips {
+			out = append(out, ip.String())
+		}
+		return out, nil
+	case "TXT":
+		return r.LookupTXT(ctx, host)
+	case "CNAME":
+		c, err := r.LookupCNAME(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return []string{c}, nil
+	default:
+		return nil, fmt.Errorf("DNS verification is not supported for %s records", recordType)
+	}
+}
+
+// verifyDNS polls r (see verifyResolver) until host serves want, or timeout
+// elapses.
+func verifyDNS(ctx context.Context, r *net.Resolver, host, recordType, want string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last []string
+	var lastErr error
+	for {
+		vals, err := lookupRecord(ctx, r, host, recordType)
+		if err == nil {
+			for _, v := range vals {
+				if sameData(recordType, v, want) {
+					return nil
+				}
+			}
+			last, lastErr = vals, nil
+		} else {
+			lastErr = err
+		}
+		if sleepCtx(ctx, 2*time.Second) != nil {
+			break
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%s %s not verified within %s: %v", recordType, host, timeout, lastErr)
+	}
+	return fmt.Errorf("%s %s not verified within %s: serving %s, want %s", recordType, host, timeout, strings.Join(last, ","), want)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,47 +11,110 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const apiBase = "https://api.digitalocean.com/v2"
 
 type Config struct {
-	Token     string
-	Domain    string
-	Name      string
-	Type      string
-	TTL       int
-	IPSource  string
-	StateDir  string
-	PerPage   int
+	Token      string
+	Domain     string
+	Name       string
+	Type       string
+	TTL        int
+	IPSource   string
+	StateDir   string
+	PerPage    int
 	MaxRetries int
 
+	// Types is Type split on commas; each entry is reconciled in turn.
+	Types []string
+	// Data is the static value for non-address record types (TXT, CNAME...).
+	Data string
+	// DataFrom, if set, is a URL or file the value is read from on every
+	// run instead; see datafrom.go.
+	DataFrom string
+
 	CleanupDuplicates bool
 	Verbose           bool
-}
+	// Networks limits the record to the named NetworkProfiles; Network,
+	// if set, names the current profiles instead of detecting them.
+	Networks        []string
+	NetworkProfiles map[string]networkProfile
+	Network         string
+	// Settle, if set, is how long a newly detected IP must stay before it
+	// is published; see ipDetector.settle.
+	Settle time.Duration
+	// ChangeWindow, if set, holds non-urgent changes until it opens.
+	ChangeWindow *changeWindow
+	// Strict fails runs that only logged warnings (see warnf).
+	Strict bool
+	// FailFast reconciles records one at a time and stops at the first
+	// failure; the rest are reported as aborted.
+	FailFast bool
 
-type DomainRecord struct {
-	ID   int64  `json:"id"`
-	Type string `json:"type"`
-	Name string `json:"name"`
-	Data string `json:"data"`
-	TTL  int    `json:"ttl"`
+	Daemon   bool
+	Interval time.Duration
+	Listen   string
+	// StateSyncInterval, in daemon mode, buffers last-IP writes and
+	// flushes them this often; zero writes them immediately.
+	StateSyncInterval time.Duration
+
+	// Targets are named values (IPs and/or IP source URLs) the record can be
+	// switched between with `do-ddns switch`; DefaultTarget is published
+	// until the first switch.
+	Targets       map[string]string
+	DefaultTarget string
+
+	// CreateTTL and CreatePriority apply only when a missing record is
+	// created; updates keep using TTL. Zero means "same as TTL" / unset.
+	CreateTTL      int
+	CreatePriority int
+	// PreserveTTL leaves the TTL of existing records alone: updates do not
+	// send one.
+	PreserveTTL bool
+	// OnConflict is what to do when a record was changed by something else
+	// between listing and updating it: retry, abort or overwrite.
+	OnConflict string
+	// Group names the config group the record belongs to, and GroupPolicy
+	// says what a failure in it does: degrade or rollback.
+	Group, GroupPolicy string
+
+	// CrossCheck lists independent IP echo services detected addresses
+	// must be confirmed by before they are published.
+	CrossCheck string
+
+	// Rotate, if set, makes each run publish one of several addresses.
+	Rotate *rotationConfig
+	// Addresses, if set, publishes several local IPv6 addresses as AAAA
+	// records.
+	Addresses *addressSet
+	// Failover, if set, publishes the address of the first healthy uplink.
+	Failover *failoverConfig
+
+	// ExpiresIn makes the record temporary: it is deleted this long after
+	// it was first created.
+	ExpiresIn time.Duration
 }
 
-type listRecordsResponse struct {
-	DomainRecords []DomainRecord `json:"domain_records"`
-	Links         struct {
-		Pages struct {
-			Next string `json:"next"`
-			Last string `json:"last"`
-		} `json:"pages"`
-	} `json:"links"`
+type DomainRecord struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority"`
 }
 
 type errorResponse struct {
@@ -58,67 +122,171 @@ type errorResponse struct {
 	Message string `json:"message"`
 }
 
+// apiError is a non-retryable error response from the DigitalOcean API.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.Status, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var ae *apiError
+	return errors.As(err, &ae) && ae.Status == http.StatusNotFound
+}
+
 func logf(format string, args ...any) {
 	ts := time.Now().Format("2006-01-02 15:04:05")
+	if id := runID(); id != "" {
+		fmt.Fprintf(os.Stderr, "%s run=%s %s\n", ts, id, fmt.Sprintf(format, args...))
+		return
+	}
 	fmt.Fprintf(os.Stderr, "%s %s\n", ts, fmt.Sprintf(format, args...))
 }
 
+// warnings counts the WARN-level conditions logged during the current run:
+// things that did not fail it, such as an unsaved state file or a failed
+// cleanup. --strict turns them into a failure.
+var warnings atomic.Int64
+
+func warnf(format string, args ...any) {
+	warnings.Add(1)
+	logf("WARN: "+format, args...)
+}
+
+// strictError fails a run that logged warnings, under --strict.
+func strictError() error {
+	if n := warnings.Load(); n > 0 {
+		return withExitCode(8, fmt.Errorf("--strict: %d warning(s) logged during the run", n))
+	}
+	return nil
+}
+
 func mustEnvOrFlag(v string, name string) string {
 	if strings.TrimSpace(v) == "" {
-		logf("ERROR: %s is required", name)
+		logf("ERROR: %s", msg(msgFlagRequired, name))
 		os.Exit(2)
 	}
 	return v
 }
 
 func stateFile(cfg Config) string {
-	// ensure stable file name
+	// ensure stable file name; A keeps the pre-dual-stack name
 	base := fmt.Sprintf("do-ddns-%s-%s.last_ip", cfg.Domain, cfg.Name)
+	if cfg.Type != "A" {
+		base = fmt.Sprintf("do-ddns-%s-%s.%s.last_ip", cfg.Domain, cfg.Name, cfg.Type)
+	}
 	return filepath.Join(cfg.StateDir, base)
 }
 
 func readLastIP(path string) (string, error) {
+	if ip, ok := stateSync.lookup(path); ok {
+		return ip, nil
+	}
 	b, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	db, err := viewStateDB(filepath.Dir(path))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return "", nil
-		}
 		return "", err
 	}
-	s := strings.TrimSpace(string(b))
-	return s, nil
+	return db.LastIP[filepath.Base(path)], nil
 }
 
+// writeLastIP records the value last published for a record (see
+// storeLastIP), or buffers it with --state-sync-interval.
 func writeLastIP(path, ip string) error {
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, []byte(ip+"\n"), 0600); err != nil {
-		return err
+	if stateSync.hold(path, ip) {
+		return nil
 	}
-	return os.Rename(tmp, path)
+	return storeLastIP(path, ip)
 }
 
-func getPublicIP(ctx context.Context, ipSource string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", ipSource, nil)
-	if err != nil {
-		return "", err
+// storeLastIP writes the value last published for a record: to the text
+// file at path, or into the state DB when the state format says so. The
+// copy in the other place is removed, which migrates it. Nothing is written
+// if the value is already stored, so unchanged runs do not wear out flash
+// storage.
+func storeLastIP(path, ip string) error {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	if !stateFormat.plainLastIP {
+		if err := updateStateDB(dir, func(db *stateDB) bool {
+			if v, ok := db.LastIP[name]; ok && v == ip {
+				return false
+			}
+			if db.LastIP == nil {
+				db.LastIP = map[string]string{}
+			}
+			db.LastIP[name] = ip
+			return true
+		}); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+	if b, err := os.ReadFile(path); err != nil || string(b) != ip+"\n" {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(ip+"\n"), 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
-	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-	ip := strings.TrimSpace(string(b))
-	parsed := net.ParseIP(ip)
-	if parsed == nil || parsed.To4() == nil {
-		return "", fmt.Errorf("invalid IPv4 from %s: %q", ipSource, ip)
+	return dropLastIP(dir, name)
+}
+
+// clearLastIP forgets the value last published for a record, so the next
+// run reconciles it instead of trusting the cache.
+func clearLastIP(path string) error {
+	if stateSync.hold(path, "") {
+		return nil
 	}
-	return ip, nil
+	return removeLastIP(path)
+}
+
+func removeLastIP(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return dropLastIP(filepath.Dir(path), filepath.Base(path))
+}
+
+func dropLastIP(dir, name string) error {
+	return updateStateDB(dir, func(db *stateDB) bool {
+		if _, ok := db.LastIP[name]; !ok {
+			return false
+		}
+		delete(db.LastIP, name)
+		return true
+	})
 }
 
 func doRequest(ctx context.Context, cfg Config, method, url string, body []byte) ([]byte, int, http.Header, error) {
+	return doRequestBody(ctx, cfg, method, url, body, nil)
+}
+
+// doRequestStream is doRequest for large responses: a successful response's
+// body is handed to read as it arrives instead of being buffered.
+func doRequestStream(ctx context.Context, cfg Config, method, url string, body []byte, read func(io.Reader) error) error {
+	_, _, _, err := doRequestBody(ctx, cfg, method, url, body, read)
+	return err
+}
+
+func doRequestBody(ctx context.Context, cfg Config, method, url string, body []byte, read func(io.Reader) error) ([]byte, int, http.Header, error) {
 	var lastErr error
+	var lastRetryAfter time.Duration
 	backoff := 1 * time.Second
+	retry := retryWait{Request: method + " " + urlPath(url), MaxRetries: cfg.MaxRetries}
 
 	for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
 		var r io.Reader
@@ -132,45 +300,75 @@ func doRequest(ctx context.Context, cfg Config, method, url string, body []byte)
 		}
 		req.Header.Set("Authorization", "Bearer "+cfg.Token)
 		req.Header.Set("Content-Type", "application/json")
+		setRequestHeaders(req)
 
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
+			apiUsage.note(cfg, 0, nil)
 			lastErr = err
-			logf("Transient error: %v (attempt %d/%d), backoff %s", err, attempt, cfg.MaxRetries, backoff)
-			time.Sleep(backoff)
+			logm(msgAPITransient, err, attempt, cfg.MaxRetries, backoff)
+			retry.Attempt, retry.Reason = attempt, err.Error()
+			if err := sleepRetry(ctx, retry, backoff); err != nil {
+				return nil, 0, nil, err
+			}
 			backoff = minDuration(backoff*2, 64*time.Second)
 			continue
 		}
 
 		hdr := resp.Header.Clone()
 		status := resp.StatusCode
+		apiUsage.note(cfg, status, hdr)
+		if read != nil && status >= 200 && status <= 299 {
+			apiRecovered(cfg)
+			err := read(resp.Body)
+			resp.Body.Close()
+			return nil, status, hdr, err
+		}
 		data, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 
 		// Success
 		if status >= 200 && status <= 299 {
+			apiRecovered(cfg)
 			return data, status, hdr, nil
 		}
 
 		// Rate limit
 		if status == 429 {
-			wait := backoff
-			if ra := hdr.Get("Retry-After"); ra != "" {
-				if n, err := strconv.Atoi(strings.TrimSpace(ra)); err == nil && n > 0 {
-					wait = time.Duration(n) * time.Second
-				}
+			wait := cmp.Or(retryAfter(hdr), backoff)
+			logm(msgAPIRateLimited, wait, attempt, cfg.MaxRetries)
+			retry.Attempt, retry.Reason = attempt, "rate limited (HTTP 429)"
+			if err := sleepRetry(ctx, retry, wait); err != nil {
+				return nil, 0, nil, err
 			}
-			logf("Rate limited (429). Waiting %s then retrying (attempt %d/%d)...", wait, attempt, cfg.MaxRetries)
-			time.Sleep(wait)
 			backoff = minDuration(backoff*2, 64*time.Second)
 			lastErr = fmt.Errorf("rate limited")
 			continue
 		}
 
+		// API maintenance: wait longer, and as long as asked to
+		if m, ok := maintenanceResponse(status, data); ok {
+			ra := retryAfter(hdr)
+			lastRetryAfter = ra
+			wait := minDuration(cmp.Or(ra, max(backoff, apiMaintenanceMinWait)), apiMaintenanceMaxWait)
+			noteAPIMaintenance(cfg, m, ra)
+			logm(msgAPIMaintenance, m, wait, attempt, cfg.MaxRetries)
+			retry.Attempt, retry.Reason = attempt, "API maintenance (HTTP 503)"
+			if err := sleepRetry(ctx, retry, wait); err != nil {
+				return nil, 0, nil, err
+			}
+			backoff = minDuration(wait*2, apiMaintenanceMaxWait)
+			lastErr = &apiMaintenanceError{Message: m}
+			continue
+		}
+
 		// Retry 5xx
 		if status >= 500 && status <= 599 {
-			logf("Server error (HTTP %d). Waiting %s then retrying (attempt %d/%d)...", status, backoff, attempt, cfg.MaxRetries)
-			time.Sleep(backoff)
+			logm(msgAPIServerError, status, backoff, attempt, cfg.MaxRetries)
+			retry.Attempt, retry.Reason = attempt, fmt.Sprintf("server error (HTTP %d)", status)
+			if err := sleepRetry(ctx, retry, backoff); err != nil {
+				return nil, 0, nil, err
+			}
 			backoff = minDuration(backoff*2, 64*time.Second)
 			lastErr = fmt.Errorf("server error http %d", status)
 			continue
@@ -183,10 +381,20 @@ func doRequest(ctx context.Context, cfg Config, method, url string, body []byte)
 		if json.Unmarshal(data, &er) == nil && er.Message != "" {
 			msg = er.Message
 		}
-		return data, status, hdr, fmt.Errorf("HTTP %d: %s", status, msg)
+		apiErr := &apiError{Status: status, Message: msg}
+		if !(status == 404 && method == "DELETE") {
+			recordAPIError(cfg, method, url, status, hdr, apiErr)
+		}
+		return data, status, hdr, apiErr
 	}
 
-	return nil, 0, nil, fmt.Errorf("exceeded max retries (%d): last error: %v", cfg.MaxRetries, lastErr)
+	var me *apiMaintenanceError
+	if errors.As(lastErr, &me) {
+		startAPICooldown(cfg, lastRetryAfter)
+	}
+	err := fmt.Errorf("exceeded max retries (%d): last error: %w", cfg.MaxRetries, lastErr)
+	recordAPIError(cfg, method, url, 0, nil, err)
+	return nil, 0, nil, err
 }
 
 func minDuration(a, b time.Duration) time.Duration {
@@ -196,45 +404,141 @@ func minDuration(a, b time.Duration) time.Duration {
 	return b
 }
 
-func listAllRecords(ctx context.Context, cfg Config) ([]DomainRecord, error) {
-	var out []DomainRecord
+// listRecords lists the zone's records with one of the given names, or all
+// of them if no names are given. Pages are decoded one record at a time
+// straight from the response and only matches are kept, so memory use stays
+// flat even for zones with thousands of records; a single name is also
+// filtered by the API, which usually leaves a single small page.
+func listRecords(ctx context.Context, cfg Config, names ...string) ([]DomainRecord, error) {
+	q := url.Values{"per_page": {strconv.Itoa(cfg.PerPage)}, "page": {"1"}}
+	if len(names) == 1 {
+		q.Set("name", fqdn(names[0], cfg.Domain))
+	}
+	out := make([]DomainRecord, 0, min(cfg.PerPage, 64))
+	keep := func(r *DomainRecord) bool { return len(names) == 0 || slices.Contains(names, r.Name) }
 
-	url := fmt.Sprintf("%s/domains/%s/records?per_page=%d&page=1", apiBase, cfg.Domain, cfg.PerPage)
-	for {
-		b, _, _, err := doRequest(ctx, cfg, "GET", url, nil)
+	next := fmt.Sprintf("%s/domains/%s/records?%s", apiBase, cfg.Domain, q.Encode())
+	for next != "" {
+		var page string
+		err := doRequestStream(ctx, cfg, "GET", next, nil, func(r io.Reader) (err error) {
+			page, err = decodeRecordPage(r, keep, &out)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
-		var resp listRecordsResponse
-		if err := json.Unmarshal(b, &resp); err != nil {
-			return nil, fmt.Errorf("failed to parse list records response: %w", err)
+		next = strings.TrimSpace(page)
+	}
+	return out, nil
+}
+
+// decodeRecordPage streams one page of a list records response, appending
+// the records keep accepts to out, and returns the next page's URL.
+func decodeRecordPage(r io.Reader, keep func(*DomainRecord) bool, out *[]DomainRecord) (string, error) {
+	dec := json.NewDecoder(r)
+	fail := func(err error) (string, error) {
+		return "", fmt.Errorf("failed to parse list records response: %w", err)
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return fail(cmp.Or(err, errors.New("not an object")))
+	}
+	var links struct {
+		Pages struct {
+			Next string `json:"next"`
+		} `json:"pages"`
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fail(err)
 		}
-		out = append(out, resp.DomainRecords...)
-		next := strings.TrimSpace(resp.Links.Pages.Next)
-		if next == "" {
-			break
+		switch key {
+		case "domain_records":
+			if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+				return fail(cmp.Or(err, errors.New("domain_records is not a list")))
+			}
+			var rec DomainRecord
+			for dec.More() {
+				rec = DomainRecord{}
+				if err := dec.Decode(&rec); err != nil {
+					return fail(err)
+				}
+				if keep(&rec) {
+					*out = append(*out, rec)
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return fail(err)
+			}
+		case "links":
+			if err := dec.Decode(&links); err != nil {
+				return fail(err)
+			}
+		default:
+			var skip any
+			if err := dec.Decode(&skip); err != nil {
+				return fail(err)
+			}
 		}
-		url = next
 	}
-	return out, nil
+	if _, err := dec.Token(); err != nil {
+		return fail(err)
+	}
+	return links.Pages.Next, nil
+}
+
+// createTTL is the TTL a newly created record gets.
+func (c Config) createTTL() int {
+	if c.CreateTTL > 0 {
+		return c.CreateTTL
+	}
+	return c.TTL
 }
 
-func createRecord(ctx context.Context, cfg Config, ip string) error {
+func createRecord(ctx context.Context, cfg Config, ip string) (DomainRecord, error) {
 	payload := map[string]any{
 		"type": cfg.Type,
 		"name": cfg.Name,
 		"data": ip,
-		"ttl":  cfg.TTL,
+		"ttl":  cfg.createTTL(),
+	}
+	if cfg.CreatePriority > 0 && (cfg.Type == "MX" || cfg.Type == "SRV") {
+		payload["priority"] = cfg.CreatePriority
 	}
 	b, _ := json.Marshal(payload)
-	_, _, _, err := doRequest(ctx, cfg, "POST", fmt.Sprintf("%s/domains/%s/records", apiBase, cfg.Domain), b)
-	return err
+	data, _, _, err := doRequest(ctx, cfg, "POST", fmt.Sprintf("%s/domains/%s/records", apiBase, cfg.Domain), b)
+	if err != nil {
+		return DomainRecord{}, err
+	}
+	var resp struct {
+		DomainRecord DomainRecord `json:"domain_record"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return DomainRecord{}, fmt.Errorf("failed to parse create record response: %w", err)
+	}
+	return resp.DomainRecord, nil
+}
+
+func getRecord(ctx context.Context, cfg Config, id int64) (DomainRecord, error) {
+	data, _, _, err := doRequest(ctx, cfg, "GET", fmt.Sprintf("%s/domains/%s/records/%d", apiBase, cfg.Domain, id), nil)
+	if err != nil {
+		return DomainRecord{}, err
+	}
+	var resp struct {
+		DomainRecord DomainRecord `json:"domain_record"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return DomainRecord{}, fmt.Errorf("failed to parse record response: %w", err)
+	}
+	return resp.DomainRecord, nil
 }
 
 func updateRecord(ctx context.Context, cfg Config, id int64, ip string) error {
 	payload := map[string]any{
 		"data": ip,
-		"ttl":  cfg.TTL,
+	}
+	if !cfg.PreserveTTL {
+		payload["ttl"] = cfg.TTL
 	}
 	b, _ := json.Marshal(payload)
 	_, _, _, err := doRequest(ctx, cfg, "PUT", fmt.Sprintf("%s/domains/%s/records/%d", apiBase, cfg.Domain, id), b)
@@ -246,140 +550,792 @@ func deleteRecord(ctx context.Context, cfg Config, id int64) error {
 	return err
 }
 
+// subcommands are dispatched on the first argument; anything else runs the
+// updater itself.
+var subcommands = map[string]func(args []string) error{
+	"migrate":           runMigrate,
+	"apply-mail-preset": runApplyMailPreset,
+	"maintenance":       runMaintenance,
+	"pause":             runPause,
+	"note":              runNote,
+	"list":              runList,
+	"plan":              runPlan,
+	"apply":             runApply,
+	"resume":            runResume,
+	"switch":            runSwitch,
+	"config":            runConfig,
+	"status":            runStatus,
+	"state":             runState,
+	"usage":             runUsage,
+	"api":               runAPI,
+	"support-bundle":    runSupportBundle,
+	"ip-server":         runIPServer,
+}
+
+// recordFlags registers the flags every record-level subcommand shares and
+// returns the Config they fill in.
+func recordFlags(fs *flag.FlagSet) *Config {
+	cfg := &Config{}
+	fs.StringVar(&cfg.Token, "token", os.Getenv("DO_TOKEN"), "DigitalOcean API token (or env DO_TOKEN)")
+	nameFlag(fs, &cfg.Domain, "domain", "DO_DOMAIN", "Domain (or env DO_DOMAIN)")
+	nameFlag(fs, &cfg.Name, "name", "DO_NAME", "Record name (relative, e.g. hq) (or env DO_NAME)")
+	fs.StringVar(&cfg.Type, "type", envDefault("DO_TYPE", "A"), "Record type (or env DO_TYPE)")
+	fs.IntVar(&cfg.TTL, "ttl", envDefaultInt("DO_TTL", 300), "TTL seconds (or env DO_TTL)")
+	fs.BoolVar(&cfg.PreserveTTL, "preserve-ttl", envDefaultBool("DO_PRESERVE_TTL", false), "Never change the TTL of existing records (or env DO_PRESERVE_TTL)")
+	fs.StringVar(&cfg.OnConflict, "on-conflict", envDefault("DO_ON_CONFLICT", conflictRetry), "When a record changed since it was listed: retry, abort or overwrite (or env DO_ON_CONFLICT)")
+	fs.StringVar(&cfg.IPSource, "ip-source", envDefault("IP_SOURCE", defaultIPSources), "Public IP source URL(s), comma-separated (or env IP_SOURCE)")
+	fs.StringVar(&cfg.StateDir, "state-dir", envDefault("STATE_DIR", "/tmp"), "State directory (or env STATE_DIR)")
+	fs.IntVar(&cfg.PerPage, "per-page", envDefaultInt("PER_PAGE", 200), "Per-page pagination size (or env PER_PAGE)")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", envDefaultInt("MAX_RETRIES", 6), "Max retries for DO API calls (or env MAX_RETRIES)")
+	return cfg
+}
+
+// requireRecord validates the flags registered by recordFlags.
+func requireRecord(cfg *Config) {
+	cfg.Token = mustEnvOrFlag(cfg.Token, "DO_TOKEN / --token")
+	cfg.Domain = mustEnvOrFlag(cfg.Domain, "DO_DOMAIN / --domain")
+	cfg.Name = mustEnvOrFlag(cfg.Name, "DO_NAME / --name")
+	cfg.Type = strings.ToUpper(strings.TrimSpace(cfg.Type))
+	if cfg.Type == "" {
+		cfg.Type = "A"
+	}
+	cfg.Types = []string{cfg.Type}
+}
+
 func main() {
+	startRun()
+	chaosSpec, args := extractChaos(os.Args[1:])
+	os.Args = append(os.Args[:1], args...)
+	chaosSpec = cmp.Or(chaosSpec, os.Getenv("DO_DDNS_CHAOS"))
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := messagesFromEnv(); err != nil {
+				logf("ERROR: %v", err)
+				os.Exit(2)
+			}
+			if err := setStateFormat(os.Getenv("DO_STATE_FORMAT")); err != nil {
+				logf("ERROR: DO_STATE_FORMAT: %v", err)
+				os.Exit(2)
+			}
+			if chaosSpec != "" {
+				if err := enableChaos(chaosSpec); err != nil {
+					logf("ERROR: %v", err)
+					os.Exit(2)
+				}
+			}
+			err := cmd(os.Args[2:])
+			apiUsage.flush()
+			if err != nil {
+				logf("ERROR: %v", err)
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+	}
+
 	var cfg Config
+	var configPath string
+	var notify notifyConfig
+	var messagesPath string
+	var replayDir, recordDir string
+	var summaryPath string
+	var changeWindowSpec string
+	var stateFormatName string
 	flag.StringVar(&cfg.Token, "token", os.Getenv("DO_TOKEN"), "DigitalOcean API token (or env DO_TOKEN)")
-	flag.StringVar(&cfg.Domain, "domain", os.Getenv("DO_DOMAIN"), "Domain (or env DO_DOMAIN)")
-	flag.StringVar(&cfg.Name, "name", os.Getenv("DO_NAME"), "Record name (relative, e.g. hq) (or env DO_NAME)")
-	flag.StringVar(&cfg.Type, "type", envDefault("DO_TYPE", "A"), "Record type (A/AAAA/CNAME/etc) (or env DO_TYPE)")
+	nameFlag(flag.CommandLine, &cfg.Domain, "domain", "DO_DOMAIN", "Domain (or env DO_DOMAIN)")
+	nameFlag(flag.CommandLine, &cfg.Name, "name", "DO_NAME", "Record name (relative, e.g. hq) (or env DO_NAME)")
+	flag.StringVar(&cfg.Type, "type", envDefault("DO_TYPE", "A"), "Record type(s), comma-separated, e.g. A or A,AAAA (or env DO_TYPE)")
+	flag.StringVar(&cfg.Data, "data", os.Getenv("DO_DATA"), "Static record data for non-address types such as TXT or CNAME (or env DO_DATA)")
+	flag.StringVar(&cfg.DataFrom, "data-from", os.Getenv("DO_DATA_FROM"), "Read the record value from this http(s):// URL or file on every run, e.g. one written by another agent (or env DO_DATA_FROM)")
 	flag.IntVar(&cfg.TTL, "ttl", envDefaultInt("DO_TTL", 300), "TTL seconds (or env DO_TTL)")
-	flag.StringVar(&cfg.IPSource, "ip-source", envDefault("IP_SOURCE", "https://api.ipify.org"), "Public IP source URL (or env IP_SOURCE)")
+	flag.IntVar(&cfg.CreateTTL, "create-ttl", envDefaultInt("DO_CREATE_TTL", 0), "TTL for records that have to be created; defaults to --ttl (or env DO_CREATE_TTL)")
+	flag.IntVar(&cfg.CreatePriority, "create-priority", envDefaultInt("DO_CREATE_PRIORITY", 0), "Priority for MX/SRV records that have to be created (or env DO_CREATE_PRIORITY)")
+	flag.BoolVar(&cfg.PreserveTTL, "preserve-ttl", envDefaultBool("DO_PRESERVE_TTL", false), "Never change the TTL of existing records; updates only send the new value (or env DO_PRESERVE_TTL)")
+	flag.StringVar(&cfg.OnConflict, "on-conflict", envDefault("DO_ON_CONFLICT", conflictRetry), "When a record changed since it was listed: retry (re-read and decide again), abort or overwrite (or env DO_ON_CONFLICT)")
+	flag.StringVar(&cfg.IPSource, "ip-source", envDefault("IP_SOURCE", defaultIPSources), "Public IP source URL(s), comma-separated; failing sources fall back to the others (or env IP_SOURCE)")
+	flag.BoolVar(&explainDecisions, "explain", envDefaultBool("DO_EXPLAIN", false), "Log why each record was created, updated or left alone (or env DO_EXPLAIN)")
+	flag.BoolVar(&allowTemporaryIPv6, "allow-temporary-ipv6", envDefaultBool("ALLOW_TEMPORARY_IPV6", false), "Allow publishing RFC 4941 temporary IPv6 addresses of an iface: source or failover bind interface (or env ALLOW_TEMPORARY_IPV6)")
+	flag.StringVar(&cfg.CrossCheck, "cross-check", os.Getenv("CROSS_CHECK"), "Independent IP echo URL(s) that must confirm the detected IP before it is published (or env CROSS_CHECK)")
 	flag.StringVar(&cfg.StateDir, "state-dir", envDefault("STATE_DIR", "/tmp"), "State directory (or env STATE_DIR)")
+	flag.StringVar(&stateFormatName, "state-format", envDefault("DO_STATE_FORMAT", "json"), "Encoding of the state DB and audit snapshots: json, or cbor to keep all state in one compact file (or env DO_STATE_FORMAT)")
 	flag.IntVar(&cfg.PerPage, "per-page", envDefaultInt("PER_PAGE", 200), "Per-page pagination size (or env PER_PAGE)")
 	flag.IntVar(&cfg.MaxRetries, "max-retries", envDefaultInt("MAX_RETRIES", 6), "Max retries for DO API calls (or env MAX_RETRIES)")
 	flag.BoolVar(&cfg.CleanupDuplicates, "cleanup-duplicates", false, "If set, delete duplicate matching records (keeps lowest ID)")
 	flag.BoolVar(&cfg.Verbose, "v", false, "Verbose logging")
+	flag.StringVar(&cfg.Network, "network", os.Getenv("DO_NETWORK"), "Name the network profile(s) the host is on instead of detecting them (or env DO_NETWORK)")
+	flag.DurationVar(&cfg.Settle, "settle", envDefaultDuration("DO_SETTLE", 0), "Wait this long after seeing a new IP and detect it again before publishing, e.g. 90s (or env DO_SETTLE)")
+	flag.StringVar(&changeWindowSpec, "change-window", os.Getenv("DO_CHANGE_WINDOW"), "Daily local time span, e.g. 02:00-05:00, outside which TTL fixes and duplicate cleanup wait; new IPs are always published (or env DO_CHANGE_WINDOW)")
+	flag.BoolVar(&cfg.FailFast, "fail-fast", envDefaultBool("DO_FAIL_FAST", false), "Reconcile records one at a time and stop at the first failure (or env DO_FAIL_FAST)")
+	flag.StringVar(&summaryPath, "summary", os.Getenv("DO_SUMMARY"), "Write a JSON summary of the run to this file, or - for stdout (or env DO_SUMMARY)")
+	flag.BoolVar(&cfg.Strict, "strict", envDefaultBool("DO_STRICT", false), "Exit non-zero (code 8) when a run logs any warning, e.g. a state write or cleanup failure (or env DO_STRICT)")
+	flag.BoolVar(&cfg.Daemon, "daemon", envDefaultBool("DAEMON", false), "Run continuously, re-checking the public IP every --interval (or env DAEMON)")
+	flag.DurationVar(&cfg.Interval, "interval", envDefaultDuration("INTERVAL", 60*time.Second), "Check interval in daemon mode (or env INTERVAL)")
+	flag.DurationVar(&cfg.StateSyncInterval, "state-sync-interval", envDefaultDuration("DO_STATE_SYNC_INTERVAL", 0), "In daemon mode, write last-IP state at most this often, e.g. 15m, instead of on every change (or env DO_STATE_SYNC_INTERVAL)")
+	flag.StringVar(&cfg.Listen, "listen", os.Getenv("LISTEN"), "Address for the /healthz and /status endpoints in daemon mode, e.g. :8080 (or env LISTEN)")
+	flag.DurationVar(&cfg.ExpiresIn, "expires-in", envDefaultDuration("EXPIRES_IN", 0), "Delete the record this long after creating it, e.g. 2h (or env EXPIRES_IN)")
+	configFlag(flag.CommandLine, &configPath, "YAML config file defining multiple records; repeat to layer overlays (or env DO_CONFIG, comma-separated)")
+	flag.StringVar(&notify.URL, "notify-url", os.Getenv("NOTIFY_URL"), "URL to POST to when a record changes or an update fails (or env NOTIFY_URL)")
+	flag.StringVar(&notify.Kind, "notify-kind", os.Getenv("NOTIFY_KIND"), "Notification format: webhook, ntfy or slack; guessed from the URL if unset (or env NOTIFY_KIND)")
+	flag.StringVar(&messagesPath, "messages", os.Getenv("DO_MESSAGES"), "YAML file overriding the wording of log messages; see `do-ddns config messages` (or env DO_MESSAGES)")
+	flag.StringVar(&replayDir, "replay", "", "Answer all HTTP requests from the JSON fixtures in this directory instead of the network (see --record)")
+	flag.StringVar(&recordDir, "record", "", "Save every HTTP response as a JSON fixture in this directory, for --replay")
 	flag.Parse()
 
-	cfg.Token = mustEnvOrFlag(cfg.Token, "DO_TOKEN / --token")
-	cfg.Domain = mustEnvOrFlag(cfg.Domain, "DO_DOMAIN / --domain")
-	cfg.Name = mustEnvOrFlag(cfg.Name, "DO_NAME / --name")
-	if cfg.Type == "" {
-		cfg.Type = "A"
+	switch {
+	case replayDir != "" && recordDir != "":
+		logf("ERROR: %s", msg(msgFlagReplayRecord))
+		os.Exit(2)
+	case replayDir != "":
+		if err := enableReplay(replayDir); err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(2)
+		}
+	case recordDir != "":
+		if err := enableRecording(recordDir); err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(2)
+		}
+	}
+	if chaosSpec != "" {
+		if err := enableChaos(chaosSpec); err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(2)
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
-	defer cancel()
+	if err := setStateFormat(stateFormatName); err != nil {
+		logf("ERROR: --state-format: %v", err)
+		os.Exit(2)
+	}
+	if p, err := parseConflictPolicy(cfg.OnConflict); err != nil {
+		logf("ERROR: --on-conflict: %v", err)
+		os.Exit(2)
+	} else {
+		cfg.OnConflict = p
+	}
+	if w, err := parseChangeWindow(changeWindowSpec); err != nil {
+		logf("ERROR: --change-window: %v", err)
+		os.Exit(2)
+	} else {
+		cfg.ChangeWindow = w
+	}
 
-	// 1) detect IP
-	newIP, err := getPublicIP(ctx, cfg.IPSource)
-	if err != nil {
-		logf("ERROR: %v", err)
-		os.Exit(3)
+	if messagesPath != "" {
+		if err := loadMessages(messagesPath); err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(2)
+		}
 	}
-	logf("Public IP detected: %s", newIP)
 
-	// 2) skip DO calls if state says unchanged
-	
-	sf := stateFile(cfg)
-	/*
-	Disabled for now, we want to always update the record as we'll never reach Digital Ocean's API rate limit. 
-	lastIP, err := readLastIP(sf)
-	*/
-	/*
-	if err != nil {
-		logf("WARN: failed reading state file: %v", err)
+	var records []Config
+	if configPath != "" {
+		fc, err := loadConfigFile(configPath)
+		if err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(2)
+		}
+		records, err = fc.recordConfigs(cfg)
+		if err != nil {
+			logf("ERROR: %s: %v", configPath, err)
+			os.Exit(2)
+		}
+		if fc.Notify.URL != "" {
+			notify = fc.Notify
+		}
+	} else {
+		cfg.Token = mustEnvOrFlag(cfg.Token, "DO_TOKEN / --token")
+		cfg.Domain = mustEnvOrFlag(cfg.Domain, "DO_DOMAIN / --domain")
+		cfg.Name = mustEnvOrFlag(cfg.Name, "DO_NAME / --name")
+		for _, t := range splitList(cfg.Type) {
+			cfg.Types = append(cfg.Types, strings.ToUpper(t))
+		}
+		if len(cfg.Types) == 0 {
+			cfg.Types = []string{"A"}
+		}
+		cfg.Type = cfg.Types[0]
+		if err := checkDataFrom(cfg); err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(2)
+		}
+		records = []Config{cfg}
+	}
+	if err := notify.validate(); err != nil {
+		logf("ERROR: %v", err)
+		os.Exit(2)
 	}
-	if lastIP != "" && lastIP == newIP {
-		logf("IP unchanged since last run (%s). Skipping DigitalOcean API calls.", newIP)
+	for _, c := range records {
+		if a := fqdn(c.Name, c.Domain); toUnicode(a) != a {
+			logm(msgRecordIDN, toUnicode(a), a)
+		}
+	}
+
+	if cfg.Daemon {
+		if cfg.Interval <= 0 {
+			logf("ERROR: %s", msg(msgFlagInterval))
+			os.Exit(2)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runDaemon(ctx, cfg, records, notify); err != nil {
+			logf("ERROR: %v", err)
+			os.Exit(exitCode(err))
+		}
 		return
 	}
-	*/
 
-	// 3) list all records and filter
-	recs, err := listAllRecords(ctx, cfg)
+	ctx := context.Background()
+
+	// The state file is not consulted for one-shot runs: we always reconcile
+	// as we'll never reach Digital Ocean's API rate limit.
+	results, err := runAll(ctx, records, nil)
+	apiUsage.flush()
+	notify.notify(ctx, results)
+	err = runOutcome(results, err)
+	if err == nil && cfg.Strict {
+		err = strictError()
+	}
+	if summaryPath != "" {
+		if serr := writeSummary(summaryPath, results, err); serr != nil {
+			warnf("%s", msg(msgSummaryWriteFailed, serr))
+		}
+	}
 	if err != nil {
-		logf("ERROR: listing records: %v", err)
-		os.Exit(4)
+		logf("ERROR: %v", err)
+		os.Exit(exitCode(err))
 	}
+}
 
-	var matches []DomainRecord
-	for _, r := range recs {
-		if r.Type == cfg.Type && r.Name == cfg.Name {
-			matches = append(matches, r)
+// exitError carries the process exit code alongside a failed run's error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	return 1
+}
+
+// runResult describes what a single run did for one record type.
+type runResult struct {
+	Domain string
+	Name   string
+	Type   string
+	IP     string
+	Action string // created, updated, unchanged, skipped, expired, maintenance, paused, off-network, aborted, rolled-back; empty on error
+	ID     int64  // DigitalOcean record ID, when known
+	Err    error
+
+	// Deleted are the duplicates cleanup removed, and Snapshot the audit
+	// file they were saved to first.
+	Deleted  []DomainRecord
+	Snapshot string
+	// Deferred lists changes ("ttl", "cleanup") left for the change window.
+	Deferred []string
+	// Note and Owner come from the local inventory (do-ddns note).
+	Note, Owner string
+	// Why is the reasoning behind Action, with --explain.
+	Why []string
+	// Previous is the record as it was before this run updated it.
+	Previous *DomainRecord
+	// Group and GroupState ("ok", "degraded", "rolled-back") are set for
+	// records in a config group.
+	Group, GroupState string
+}
+
+// key identifies the record a result belongs to, e.g. "hq.example.com/AAAA".
+func (r runResult) key() string {
+	return recordKey(r.Domain, r.Name, r.Type)
+}
+
+func recordKey(domain, name, recordType string) string {
+	return name + "." + domain + "/" + recordType
+}
+
+// maxParallelRecords bounds how many records are reconciled at once, to stay
+// well clear of the API rate limit.
+const maxParallelRecords = 4
+
+// runAll reconciles every record concurrently, sharing one IP detection per
+// address family. Each record gets its own timeout so a slow zone does not
+// starve the others. A failing record does not stop the others unless
+// FailFast is set, in which case records run in order and those after the
+// first failure are reported as aborted. Records depending on another one
+// (see bootstrap.go) run after it.
+func runAll(ctx context.Context, records []Config, published map[string]string) ([]runResult, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	loadAPIMaintenance(records[0].StateDir)
+	sweepExpired(ctx, records[0])
+
+	det := newIPDetector(records[0].CrossCheck)
+	here, limited := currentNetworks(records)
+	deps := recordDeps(records)
+	perRecord := make([][]runResult, len(records))
+	errs := make([]error, len(records))
+	run := func(i int, cfg Config) {
+		if limited && !onNetwork(cfg, here) {
+			perRecord[i] = offNetworkResults(cfg, here)
+			return
+		}
+		if j, failed := failedDep(deps, i, perRecord, errs); failed {
+			perRecord[i] = blockedResults(cfg, records[j])
+			return
+		}
+		rctx, cancel := context.WithTimeout(ctx, 45*time.Second+maxSettleRounds*cfg.Settle)
+		defer cancel()
+		perRecord[i], errs[i] = runOnce(rctx, cfg, published, det)
+	}
+
+	if records[0].FailFast {
+		failed := false
+		for _, i := range bootstrapOrder(deps) {
+			if failed {
+				perRecord[i] = abortedResults(records[i], records[i].Types)
+				continue
+			}
+			run(i, records[i])
+			failed = errs[i] != nil
+		}
+	} else {
+		// Dependents wait for the address records they depend on.
+		done := make([]chan struct{}, len(records))
+		for i := range done {
+			done[i] = make(chan struct{})
+		}
+		sem := make(chan struct{}, maxParallelRecords)
+		var wg sync.WaitGroup
+		for i, cfg := range records {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done[i])
+				for _, j := range deps[i] {
+					<-done[j]
+				}
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				run(i, cfg)
+			}()
+		}
+		wg.Wait()
+	}
+	rollbackBootstraps(ctx, records, deps, perRecord, errs)
+	applyGroups(ctx, records, perRecord)
+
+	var results []runResult
+	for _, r := range perRecord {
+		results = append(results, r...)
+	}
+	annotate(records[0].StateDir, results)
+	return results, errors.Join(errs...)
+}
+
+// runOnce detects the public IP of each configured family and reconciles the
+// matching record with it. Families whose detected IP equals the one in
+// published (keyed by recordKey) are skipped without contacting
+// DigitalOcean. A failing family does not stop the others; the returned error
+// joins all failures.
+func runOnce(ctx context.Context, cfg Config, published map[string]string, det *ipDetector) ([]runResult, error) {
+	var results []runResult
+	var errs []error
+	var recs []DomainRecord // listed once, shared by all families
+	listed := false
+
+	for i, t := range cfg.Types {
+		if cfg.FailFast && len(errs) > 0 {
+			results = append(results, abortedResults(cfg, cfg.Types[i:])...)
+			break
+		}
+		c := cfg
+		c.Type = t
+		res := runResult{Domain: c.Domain, Name: c.Name, Type: t}
+		fail := func(err error) {
+			if len(cfg.Types) > 1 || published != nil {
+				err = fmt.Errorf("%s.%s: %w", c.Name, c.Domain, err)
+			}
+			res.Err = err
+			errs = append(errs, err)
+			results = append(results, res)
+		}
+
+		if m, ok := inMaintenance(c, res.key()); ok {
+			logm(msgRecordMaint, t, c.Name, c.Domain, m.Target, m.Since.Format(time.RFC3339))
+			res.Action = "maintenance"
+			res.explain(msgExplainMaint, m.Target)
+			results = append(results, res)
+			continue
 		}
+		if p, ok := isPaused(c); ok {
+			reason := ""
+			if p.Reason != "" {
+				reason = ": " + p.Reason
+			}
+			logm(msgRecordPaused, t, c.Name, c.Domain, p.Since.Format(time.RFC3339), reason)
+			res.Action = "paused"
+			res.explain(msgExplainPaused)
+			results = append(results, res)
+			continue
+		}
+
+		if c.ExpiresIn > 0 {
+			if e, ok := expiryTombstone(c, res.key()); ok {
+				logm(msgRecordExpired, t, c.Name, c.Domain, e.Expires.Format(time.RFC3339))
+				res.Action = "expired"
+				res.explain(msgExplainExpired)
+				results = append(results, res)
+				continue
+			}
+		} else if err := untrackExpiry(c, res.key()); err != nil {
+			warnf("%s", msg(msgStateWriteFailed, err))
+		}
+
+		// 1) detect IP (address types) or take the static value
+		newIP, err := desiredData(ctx, c, det)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		res.IP = newIP
+		res.explain(msgExplainSource, newIP, valueSource(c))
+
+		// 2) skip DO calls if state says unchanged
+		if last := published[res.key()]; last != "" && last == newIP {
+			logm(msgRecordCached, newIP, t, c.Name, c.Domain)
+			res.Action = "skipped"
+			res.explain(msgExplainCached, newIP)
+			results = append(results, res)
+			continue
+		}
+
+		// Hold off while the API asked to be left alone.
+		if until, ok := apiCooldown(); ok {
+			logm(msgRecordCooldown, t, c.Name, c.Domain, until.Format(time.RFC3339))
+			res.Action = "cooldown"
+			res.explain(msgExplainCooldown, until.Format(time.RFC3339))
+			results = append(results, res)
+			continue
+		}
+
+		// 3) list all records
+		if !listed {
+			recs, err = listRecords(ctx, c, c.Name)
+			if err != nil {
+				fail(withExitCode(4, fmt.Errorf("listing records: %w", err)))
+				break
+			}
+			listed = true
+		}
+
+		if c.Settle > 0 && detectsIP(c) {
+			if m := matchingRecords(recs, t, c.Name); len(m) > 0 && !sameData(t, m[0].Data, newIP) {
+				if newIP, err = det.settle(ctx, c.IPSource, ipNetwork(t), newIP, c.Settle); err != nil {
+					fail(withExitCode(3, fmt.Errorf("%s: %w", t, err)))
+					continue
+				}
+				res.IP = newIP
+			}
+		}
+
+		if c.Addresses != nil && t == "AAAA" {
+			err = reconcileSet(ctx, c, recs, strings.Split(newIP, ","), &res)
+		} else {
+			err = reconcile(ctx, c, recs, newIP, &res)
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		// Only records we created are made temporary; an expiry never
+		// deletes a record that existed before.
+		if c.ExpiresIn > 0 && res.Action == "created" {
+			if err := trackExpiry(c, res); err != nil {
+				warnf("%s", msg(msgStateWriteFailed, err))
+			}
+		}
+		results = append(results, res)
 	}
+	return results, errors.Join(errs...)
+}
+
+// abortedResults reports the given types of cfg as not attempted because of
+// --fail-fast.
+func abortedResults(cfg Config, types []string) []runResult {
+	var out []runResult
+	for _, t := range types {
+		logm(msgRecordAborted, t, cfg.Name, cfg.Domain)
+		out = append(out, runResult{Domain: cfg.Domain, Name: cfg.Name, Type: t, Action: "aborted"})
+	}
+	return out
+}
+
+func isAddressType(t string) bool {
+	return t == "A" || t == "AAAA"
+}
+
+// desiredData returns the value the cfg.Type record should hold: the active
+// switch target if the record has targets, otherwise what data_from returns,
+// or the detected public IP for A/AAAA and cfg.Data for everything else.
+func desiredData(ctx context.Context, cfg Config, det *ipDetector) (string, error) {
+	if name := activeTargetName(cfg); name != "" {
+		v, err := resolveTarget(ctx, cfg, cfg.Targets[name], det)
+		if err != nil {
+			return "", withExitCode(3, fmt.Errorf("%s: target %q: %w", cfg.Type, name, err))
+		}
+		return v, nil
+	}
+	if cfg.DataFrom != "" {
+		v, err := fetchData(ctx, cfg)
+		if err != nil {
+			return "", withExitCode(3, fmt.Errorf("%s: data_from %s: %w", cfg.Type, cfg.DataFrom, err))
+		}
+		return v, nil
+	}
+	if cfg.Failover != nil && isAddressType(cfg.Type) {
+		v, err := pickUplink(ctx, cfg, det)
+		if err != nil {
+			return "", withExitCode(3, fmt.Errorf("%s: %w", cfg.Type, err))
+		}
+		return v, nil
+	}
+	if cfg.Addresses != nil && cfg.Type == "AAAA" {
+		set, err := cfg.Addresses.pick()
+		if err != nil {
+			return "", withExitCode(3, fmt.Errorf("%s: %w", cfg.Type, err))
+		}
+		return strings.Join(set, ","), nil
+	}
+	if cfg.Rotate != nil && isAddressType(cfg.Type) {
+		v, err := pickRotation(ctx, cfg)
+		if err != nil {
+			return "", withExitCode(3, fmt.Errorf("%s: %w", cfg.Type, err))
+		}
+		return v, nil
+	}
+	if !isAddressType(cfg.Type) {
+		if cfg.Data == "" {
+			return "", withExitCode(2, fmt.Errorf("%s: DO_DATA / --data is required for non-address record types", cfg.Type))
+		}
+		if cfg.Type == "TXT" {
+			return parseTXT(cfg.Data), nil
+		}
+		return cfg.Data, nil
+	}
+	ip, err := det.get(ctx, cfg.IPSource, ipNetwork(cfg.Type))
+	if err != nil {
+		return "", withExitCode(3, fmt.Errorf("%s: %w", cfg.Type, err))
+	}
+	return ip, nil
+}
+
+// detectsIP reports whether cfg's value comes straight from IP detection
+// rather than a switch target, failover or rotation.
+func detectsIP(cfg Config) bool {
+	return isAddressType(cfg.Type) && activeTargetName(cfg) == "" && cfg.DataFrom == "" && cfg.Failover == nil && cfg.Rotate == nil &&
+		(cfg.Addresses == nil || cfg.Type != "AAAA")
+}
+
+// recordData converts a desired value into the data field sent to the API.
+func recordData(recordType, value string) string {
+	if recordType == "TXT" {
+		return formatTXT(value)
+	}
+	return value
+}
+
+// reconcile makes sure exactly one record of cfg.Type/cfg.Name among recs
+// points at newIP, creating or updating it as needed, and records what it
+// did in res.
+func reconcile(ctx context.Context, cfg Config, recs []DomainRecord, newIP string, res *runResult) error {
+	sf := stateFile(cfg)
+
+	matches := matchingRecords(recs, cfg.Type, cfg.Name)
 
 	if len(matches) == 0 {
-		logf("No existing %s record found for %s.%s. Creating it.", cfg.Type, cfg.Name, cfg.Domain)
-		if err := createRecord(ctx, cfg, newIP); err != nil {
-			logf("ERROR: create record: %v", err)
-			os.Exit(5)
+		logm(msgRecordCreating, cfg.Type, cfg.Name, cfg.Domain)
+		res.explain(msgExplainMissing, cfg.Type, cfg.Name)
+		created, err := createRecord(ctx, cfg, recordData(cfg.Type, newIP))
+		if err != nil {
+			return withExitCode(5, fmt.Errorf("create record: %w", err))
 		}
 		if err := writeLastIP(sf, newIP); err != nil {
-			logf("WARN: failed writing state file: %v", err)
+			warnf("%s", msg(msgStateWriteFailed, err))
 		}
-		logf("Created %s.%s -> %s (ttl=%d)", cfg.Name, cfg.Domain, newIP, cfg.TTL)
-		return
+		logm(msgRecordCreated, cfg.Name, cfg.Domain, newIP, cfg.createTTL())
+		res.Action, res.ID = "created", created.ID
+		return nil
 	}
 
-	// sort by ID and pick canonical
-	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	// matches are sorted by ID: the lowest is canonical
 	chosen := matches[0]
 
-	logf("Found %d existing %s record(s) for %s.%s. Using id=%d (current=%s).",
-		len(matches), cfg.Type, cfg.Name, cfg.Domain, chosen.ID, chosen.Data)
+	logm(msgRecordFound, len(matches), cfg.Type, cfg.Name, cfg.Domain, chosen.ID, chosen.Data)
+	if len(matches) > 1 {
+		res.explain(msgExplainCanonical, len(matches), chosen.ID)
+	}
 
-	if chosen.Data == newIP {
+	if sameData(cfg.Type, chosen.Data, newIP) {
+		res.explain(msgExplainSame, chosen.ID, chosen.Data, newIP, comparison(cfg.Type))
 		// Update state anyway so we stop calling DO next time
 		if err := writeLastIP(sf, newIP); err != nil {
-			logf("WARN: failed writing state file: %v", err)
+			warnf("%s", msg(msgStateWriteFailed, err))
 		}
-		logf("No update needed (IP unchanged in DigitalOcean).")
-		// Optionally cleanup duplicates even if IP unchanged
-		if cfg.CleanupDuplicates && len(matches) > 1 {
-			if err := cleanup(ctx, cfg, matches[1:]); err != nil {
-				logf("WARN: cleanup duplicates failed: %v", err)
+		if isAddressType(cfg.Type) {
+			logm(msgRecordSameIP)
+		} else {
+			logm(msgRecordSameValue)
+		}
+		res.Action, res.ID = "unchanged", chosen.ID
+		// A drifted TTL is corrected, inside the change window if one is set.
+		// The TTL a record was created with is not drift.
+		if !cfg.PreserveTTL && chosen.TTL != cfg.TTL && chosen.TTL != cfg.createTTL() && !deferChange(cfg, res, "ttl") {
+			res.explain(msgExplainTTL, chosen.TTL, cfg.TTL)
+			if fresh, err := recheckRecord(ctx, cfg, chosen); err != nil {
+				return err
+			} else if fresh != chosen {
+				cfg.OnConflict = conflictOverwrite // just re-read
+				return reconcile(ctx, cfg, replaceRecord(recs, fresh), newIP, res)
 			}
+			if err := updateRecord(ctx, cfg, chosen.ID, recordData(cfg.Type, newIP)); err != nil {
+				return withExitCode(6, fmt.Errorf("update record id=%d: %w", chosen.ID, err))
+			}
+			logm(msgRecordUpdated, cfg.Name, cfg.Domain, newIP, cfg.TTL)
+			res.Action, res.Previous = "updated", &chosen
 		}
-		return
+		// Optionally cleanup duplicates even if IP unchanged
+		if cfg.CleanupDuplicates && len(matches) > 1 && (staleDuplicates(cfg.Type, matches[1:], newIP) || !deferChange(cfg, res, "cleanup")) {
+			cleanup(ctx, cfg, matches, res)
+		} else if !cfg.CleanupDuplicates && len(matches) > 1 {
+			res.explain(msgExplainDuplicates, len(matches)-1)
+		}
+		return nil
 	}
+	res.explain(msgExplainDiffers, chosen.ID, chosen.Data, newIP, comparison(cfg.Type))
 
-	// 4) Update canonical record only
-	if err := updateRecord(ctx, cfg, chosen.ID, newIP); err != nil {
-		logf("ERROR: update record id=%d: %v", chosen.ID, err)
-		os.Exit(6)
+	// 4) Update canonical record only, deciding again if it changed since
+	// it was listed
+	if fresh, err := recheckRecord(ctx, cfg, chosen); err != nil {
+		return err
+	} else if fresh != chosen {
+		cfg.OnConflict = conflictOverwrite // just re-read
+		return reconcile(ctx, cfg, replaceRecord(recs, fresh), newIP, res)
+	}
+	if err := updateRecord(ctx, cfg, chosen.ID, recordData(cfg.Type, newIP)); err != nil {
+		return withExitCode(6, fmt.Errorf("update record id=%d: %w", chosen.ID, err))
 	}
 	if err := writeLastIP(sf, newIP); err != nil {
-		logf("WARN: failed writing state file: %v", err)
+		warnf("%s", msg(msgStateWriteFailed, err))
 	}
-	logf("Updated %s.%s -> %s (ttl=%d)", cfg.Name, cfg.Domain, newIP, cfg.TTL)
+	ttl := cfg.TTL
+	if cfg.PreserveTTL {
+		ttl = chosen.TTL
+	}
+	logm(msgRecordUpdated, cfg.Name, cfg.Domain, newIP, ttl)
+
+	res.Action, res.ID, res.Previous = "updated", chosen.ID, &chosen
 
 	// 5) Optional cleanup duplicates after successful update
-	if cfg.CleanupDuplicates && len(matches) > 1 {
-		if err := cleanup(ctx, cfg, matches[1:]); err != nil {
-			logf("WARN: cleanup duplicates failed: %v", err)
+	if cfg.CleanupDuplicates && len(matches) > 1 && (staleDuplicates(cfg.Type, matches[1:], newIP) || !deferChange(cfg, res, "cleanup")) {
+		cleanup(ctx, cfg, matches, res)
+	} else if !cfg.CleanupDuplicates && len(matches) > 1 {
+		res.explain(msgExplainDuplicates, len(matches)-1)
+	}
+	return nil
+}
+
+// matchingRecords returns the records of the given type and name, sorted by
+// ID so the first one is the canonical record.
+func matchingRecords(recs []DomainRecord, recordType, name string) []DomainRecord {
+	var matches []DomainRecord
+	for _, r := range recs {
+		if r.Type == recordType && r.Name == name {
+			matches = append(matches, r)
 		}
 	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches
 }
 
-func cleanup(ctx context.Context, cfg Config, dups []DomainRecord) error {
-	if len(dups) == 0 {
-		return nil
+// sameData compares record data, treating differently spelled but equal
+// addresses (e.g. IPv6 zero compression), TXT values split into different
+// character-strings, and hostnames with or without the trailing dot as the
+// same.
+func sameData(recordType, a, b string) bool {
+	switch recordType {
+	case "TXT":
+		return parseTXT(a) == parseTXT(b)
+	case "CNAME", "MX", "NS":
+		return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
 	}
-	logf("Cleanup enabled: deleting %d duplicate record(s)...", len(dups))
+	if isAddressType(recordType) {
+		if ia, ib := net.ParseIP(a), net.ParseIP(b); ia != nil && ib != nil {
+			return ia.Equal(ib)
+		}
+	}
+	return a == b
+}
+
+// cleanupSnapshot is written to <state dir>/audit before duplicates are
+// deleted, so a wrongly deleted record can be restored by hand.
+type cleanupSnapshot struct {
+	Time    time.Time      `json:"time"`
+	RunID   string         `json:"run_id,omitempty"`
+	Record  string         `json:"record"`
+	Kept    DomainRecord   `json:"kept"`
+	Deleted []DomainRecord `json:"deleted"`
+}
+
+// cleanup deletes matches[1:], the duplicates of the canonical matches[0],
+// and adds the ones it removed to res. Nothing is deleted unless the audit
+// snapshot could be written first. Failures are only warnings: the record
+// itself is already correct.
+func cleanup(ctx context.Context, cfg Config, matches []DomainRecord, res *runResult) {
+	dups := matches[1:]
+	snap, err := writeCleanupSnapshot(cfg, res.key(), matches[0], dups)
+	if err != nil {
+		warnf("%s", msg(msgCleanupFailed, fmt.Errorf("not deleting without an audit snapshot: %w", err)))
+		return
+	}
+	res.Snapshot = snap
+	logm(msgCleanupStart, len(dups))
 	var errs []string
 	for _, r := range dups {
 		if err := deleteRecord(ctx, cfg, r.ID); err != nil {
 			errs = append(errs, fmt.Sprintf("id=%d: %v", r.ID, err))
 			continue
 		}
-		logf("Deleted duplicate record id=%d (data=%s)", r.ID, r.Data)
+		res.Deleted = append(res.Deleted, r)
+		logm(msgCleanupDeleted, r.ID, r.Data)
 	}
 	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, "; "))
+		warnf("%s", msg(msgCleanupFailed, strings.Join(errs, "; ")))
 	}
-	return nil
+}
+
+func writeCleanupSnapshot(cfg Config, key string, kept DomainRecord, dups []DomainRecord) (string, error) {
+	dir := filepath.Join(cfg.StateDir, "audit")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	b, err := stateFormat.marshal(cleanupSnapshot{Time: now, RunID: runID(), Record: key, Kept: kept, Deleted: dups})
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("cleanup-%s-%s%s", now.Format("20060102T150405Z"), unsafePathChars.ReplaceAllString(key, "_"), stateFormat.ext)
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, b, 0600)
 }
 
 func envDefault(key, def string) string {
@@ -401,3 +1357,27 @@ func envDefaultInt(key string, def int) int {
 	}
 	return n
 }
+
+func envDefaultBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+func envDefaultDuration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI sends every request made through http.DefaultClient (the
+// DigitalOcean API included) to h for the rest of the test.
+func fakeAPI(t testing.TB, h http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	target, _ := url.Parse(srv.URL)
+	prev := http.DefaultClient.Transport
+	http.DefaultClient.Transport = rewriteHost{target: target, rt: srv.Client().Transport}
+	t.Cleanup(func() {
+		http.DefaultClient.Transport = prev
+		srv.Close()
+	})
+}
+
+type rewriteHost struct {
+	target *url.URL
+	rt     http.RoundTripper
+}
+
+func (r rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return r.rt.RoundTrip(req)
+}
+
+// zone is a fake example.com zone behind the DigitalOcean records API.
+// Listing supports the name filter (a full hostname), per_page/page
+// pagination and links.pages.next; records can be created, updated and
+// deleted. It remembers the list queries and the changes made.
+type zone struct {
+	// hook, if set, is called with every request before it is answered.
+	hook func(r *http.Request)
+
+	mu      sync.Mutex
+	records []DomainRecord
+	nextID  int64
+	queries []url.Values
+	changes []string // e.g. "POST TXT a", "DELETE 1001"
+}
+
+func newZone(n int) *zone {
+	z := &zone{}
+	for i := range n {
+		z.records = append(z.records, DomainRecord{
+			ID: int64(1000 + i), Type: "A", Name: fmt.Sprintf("host%05d", i),
+			Data: fmt.Sprintf("192.0.2.%d", i%250+1), TTL: 300,
+		})
+	}
+	return z
+}
+
+const recordsPath = "/v2/domains/example.com/records"
+
+func (z *zone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if z.hook != nil {
+		z.hook(r)
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == recordsPath && r.Method == "GET":
+		z.list(w, r)
+	case r.URL.Path == recordsPath && r.Method == "POST":
+		var rec DomainRecord
+		json.NewDecoder(r.Body).Decode(&rec)
+		z.nextID = max(z.nextID, 5000) + 1
+		rec.ID = z.nextID
+		z.records = append(z.records, rec)
+		z.changes = append(z.changes, fmt.Sprintf("POST %s %s", rec.Type, rec.Name))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"domain_record": rec})
+	case strings.HasPrefix(r.URL.Path, recordsPath+"/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, recordsPath+"/"), 10, 64)
+		i := slices.IndexFunc(z.records, func(d DomainRecord) bool { return d.ID == id })
+		if i < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"id":"not_found","message":"The resource you were accessing could not be found."}`)
+			return
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			var upd struct {
+				Data *string `json:"data"`
+				TTL  *int    `json:"ttl"`
+			}
+			json.NewDecoder(r.Body).Decode(&upd)
+			if upd.Data != nil {
+				z.records[i].Data = *upd.Data
+			}
+			if upd.TTL != nil {
+				z.records[i].TTL = *upd.TTL
+			}
+			z.changes = append(z.changes, fmt.Sprintf("PUT %d", id))
+		case "DELETE":
+			z.records = slices.Delete(z.records, i, i+1)
+			z.changes = append(z.changes, fmt.Sprintf("DELETE %d", id))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"domain_record": z.records[i]})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (z *zone) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	z.queries = append(z.queries, q)
+	recs := z.records
+	if name := q.Get("name"); name != "" {
+		recs = slices.DeleteFunc(slices.Clone(recs), func(d DomainRecord) bool { return d.Name+".example.com" != name })
+	}
+	perPage, _ := strconv.Atoi(q.Get("per_page"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if perPage <= 0 {
+		perPage = 20
+	}
+	page = max(page, 1)
+	from, to := min((page-1)*perPage, len(recs)), min(page*perPage, len(recs))
+	w.Write(recordPage(recs[from:to], nextPage(r, to < len(recs), page), len(recs)))
+}
+
+func nextPage(r *http.Request, more bool, page int) string {
+	if !more {
+		return ""
+	}
+	q := r.URL.Query()
+	q.Set("page", strconv.Itoa(page+1))
+	return apiBase + "/domains/example.com/records?" + q.Encode()
+}
+
+// recordPage renders a list records response, with the extra fields
+// DigitalOcean sends that decodeRecordPage has to skip.
+func recordPage(recs []DomainRecord, next string, total int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"domain_records":[`)
+	for i, rec := range recs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"type":%q,"name":%q,"data":%q,"priority":null,"port":null,"ttl":%d,"weight":null,"flags":null,"tag":null}`,
+			rec.ID, rec.Type, rec.Name, rec.Data, rec.TTL)
+	}
+	b.WriteString(`],"links":{`)
+	if next != "" {
+		fmt.Fprintf(&b, `"pages":{"next":%q}`, next)
+	}
+	fmt.Fprintf(&b, `},"meta":{"total":%d}}`, total)
+	return b.Bytes()
+}
+
+func testConfig() Config {
+	return Config{Token: "test", Domain: "example.com", PerPage: 200, MaxRetries: 1}
+}
+
+func TestListRecords(t *testing.T) {
+	z := newZone(25)
+	fakeAPI(t, z)
+	cfg := testConfig()
+	cfg.PerPage = 10
+
+	tests := []struct {
+		name      string
+		names     []string
+		want      []string
+		pages     int
+		nameQuery string
+	}{
+		{name: "whole zone", names: nil, pages: 3},
+		{name: "one name filtered by the API", names: []string{"host00003"}, want: []string{"host00003"}, pages: 1, nameQuery: "host00003.example.com"},
+		{name: "several names kept locally", names: []string{"host00001", "host00024", "missing"}, want: []string{"host00001", "host00024"}, pages: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z.mu.Lock()
+			z.queries = nil
+			z.mu.Unlock()
+			got, err := listRecords(context.Background(), cfg, tt.names...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range got {
+				names = append(names, r.Name)
+			}
+			want := tt.want
+			if tt.names == nil {
+				for _, r := range z.records {
+					want = append(want, r.Name)
+				}
+			}
+			if !slices.Equal(names, want) {
+				t.Errorf("records = %v, want %v", names, want)
+			}
+			z.mu.Lock()
+			queries := z.queries
+			z.mu.Unlock()
+			if len(queries) != tt.pages {
+				t.Fatalf("%d requests, want %d", len(queries), tt.pages)
+			}
+			for i, q := range queries {
+				if q.Get("name") != tt.nameQuery {
+					t.Errorf("request %d: name=%q, want %q", i, q.Get("name"), tt.nameQuery)
+				}
+				if q.Get("page") != strconv.Itoa(i+1) || q.Get("per_page") != "10" {
+					t.Errorf("request %d: page=%s per_page=%s", i, q.Get("page"), q.Get("per_page"))
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeRecordPage(t *testing.T) {
+	keepAll := func(*DomainRecord) bool { return true }
+	tests := []struct {
+		name    string
+		body    string
+		want    int
+		next    string
+		wantErr bool
+	}{
+		{name: "records and next", body: `{"domain_records":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"links":{"pages":{"last":"x","next":"https://n"}},"meta":{"total":4}}`, want: 2, next: "https://n"},
+		{name: "links first", body: `{"links":{},"domain_records":[{"id":1}]}`, want: 1},
+		{name: "no records", body: `{"domain_records":[],"links":{}}`},
+		{name: "unknown nested fields", body: `{"x":{"y":[1,{"z":null}]},"domain_records":[{"id":1,"extra":{"a":[]}}]}`, want: 1},
+		{name: "not an object", body: `[]`, wantErr: true},
+		{name: "records not a list", body: `{"domain_records":{}}`, wantErr: true},
+		{name: "truncated", body: `{"domain_records":[{"id":1},`, wantErr: true},
+		{name: "bad record", body: `{"domain_records":[{"id":"one"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out []DomainRecord
+			next, err := decodeRecordPage(strings.NewReader(tt.body), keepAll, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (len(out) != tt.want || next != tt.next) {
+				t.Errorf("got %d records, next %q; want %d, %q", len(out), next, tt.want, tt.next)
+			}
+		})
+	}
+
+	var out []DomainRecord
+	keepB := func(r *DomainRecord) bool { return r.Name == "b" }
+	if _, err := decodeRecordPage(strings.NewReader(`{"domain_records":[{"id":1,"name":"a"},{"id":2,"name":"b","ttl":60}]}`), keepB, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != (DomainRecord{ID: 2, Name: "b", TTL: 60}) {
+		t.Errorf("keep: got %+v", out)
+	}
+}
+
+// heapSampler is a reader that tracks how far the heap grows above base
+// while the response is being decoded.
+type heapSampler struct {
+	r          io.Reader
+	base, peak uint64
+	n          int
+}
+
+func (h *heapSampler) Read(p []byte) (int, error) {
+	h.n++
+	if h.n%4 == 0 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		h.peak = max(h.peak, ms.HeapAlloc-min(ms.HeapAlloc, h.base))
+	}
+	return h.r.Read(p)
+}
+
+// TestDecodeRecordPageMemory checks the claim in listRecords: a 10k-record
+// zone is listed in well under 10MB, even as a single page.
+func TestDecodeRecordPageMemory(t *testing.T) {
+	z := newZone(10000)
+	page := recordPage(z.records, "", len(z.records))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := &heapSampler{r: bytes.NewReader(page), base: ms.HeapAlloc}
+	var out []DomainRecord
+	keep := func(r *DomainRecord) bool { return r.Name == "host09999" }
+	if _, err := decodeRecordPage(s, keep, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("kept %d records, want 1", len(out))
+	}
+	t.Logf("page %d bytes, peak heap growth %d bytes", len(page), s.peak)
+	if s.peak > 10<<20 {
+		t.Errorf("peak heap growth %d bytes, want under 10MB", s.peak)
+	}
+}
+
+func BenchmarkDecodeRecordPage(b *testing.B) {
+	page := recordPage(newZone(10000).records, "", 10000)
+	keep := func(r *DomainRecord) bool { return r.Name == "host05000" }
+	b.SetBytes(int64(len(page)))
+	b.ReportAllocs()
+	for b.Loop() {
+		var out []DomainRecord
+		if _, err := decodeRecordPage(bytes.NewReader(page), keep, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListRecords(b *testing.B) {
+	fakeAPI(b, newZone(10000))
+	cfg := testConfig()
+	ctx := context.Background()
+	for _, names := range [][]string{nil, {"host05000", "host09999"}} {
+		b.Run(fmt.Sprintf("names=%d", len(names)), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := listRecords(ctx, cfg, names...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// An empty config must not index records[0].
+func TestRunAllNoRecords(t *testing.T) {
+	results, err := runAll(context.Background(), nil, nil)
+	if results != nil || err != nil {
+		t.Errorf("runAll(nil) = %v, %v", results, err)
+	}
+}
+
+// A source that fails while another answers is a fallback, not a warning,
+// so it does not fail a --strict run; losing every source is.
+func TestIPSourceFallbackWarnings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "203.0.113.7\n")
+	}))
+	defer srv.Close()
+	down := "http://" + freeAddr(t) + "/"
+
+	tests := []struct {
+		name, sources string
+		wantIP        string
+		wantWarnings  int64
+	}{
+		{"fallback", down + "," + srv.URL, "203.0.113.7", 0},
+		{"all down", down + "," + down, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := warnings.Load()
+			ip, err := getPublicIP(context.Background(), tt.sources, "tcp4", nil)
+			if ip != tt.wantIP || (err == nil) != (tt.wantIP != "") {
+				t.Errorf("getPublicIP = %q, %v; want %q", ip, err, tt.wantIP)
+			}
+			if n := warnings.Load() - before; n != tt.wantWarnings {
+				t.Errorf("%d warning(s), want %d", n, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dohServers are the DNS-over-HTTPS endpoints --doh accepts by name.
+var dohServers = map[string]string{
+	"cloudflare": "https://cloudflare-dns.com/dns-query",
+	"google":     "https://dns.google/dns-query",
+}
+
+// dohURL resolves a --doh value: a name from dohServers or an https:// URL.
+func dohURL(s string) (string, error) {
+	if u, ok := dohServers[strings.ToLower(s)]; ok {
+		return u, nil
+	}
+	if strings.HasPrefix(s, "https://") {
+		return s, nil
+	}
+	return "", fmt.Errorf("--doh: want cloudflare, google or an https:// URL, got %q", s)
+}
+
+// dohResolver returns a resolver that sends every query to the RFC 8484
+// endpoint url instead of over port 53, for networks that block or
+// intercept plain DNS.
+func dohResolver(url string) *net.Resolver {
+	client := &http.Client{Transport: wrapTransport(http.DefaultTransport), Timeout: 10 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, url: url}, nil
+		},
+	}
+}
+
+// dohConn carries the resolver's queries over HTTPS. It is not a
+// net.PacketConn, so the resolver frames messages as over TCP (two-byte
+// length prefix): each written query becomes one POST, and the answer is
+// read back with the same framing.
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+	answer   *bytes.Reader
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(b)-2 {
+		return 0, errors.New("doh: expected one length-prefixed DNS message")
+	}
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	setRequestHeaders(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("doh: HTTP %d from %s", resp.StatusCode, c.url)
+	}
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return 0, err
+	}
+	c.answer = bytes.NewReader(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.answer == nil {
+		return 0, io.EOF
+	}
+	return c.answer.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "doh" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,137 @@
+#!/usr/bin/env bash
+# End-to-end test of the full binary against a real DigitalOcean zone.
+#
+# It creates, updates and deletes records, so it is opt-in: it only runs with
+# DO_E2E=1 and a token and domain set aside for testing.
+#
+#   DO_E2E=1 DO_E2E_TOKEN=dop_v1_... DO_E2E_DOMAIN=sandbox.example.com e2e/run.sh
+#
+# All records it touches are named e2e-<time>-<random> and are deleted on
+# exit, whatever happens. Each step is checked through the API and against
+# the zone's authoritative nameserver, so dig is required.
+#
+#   DO_E2E_NAMESERVER   nameserver to verify with (default ns1.digitalocean.com)
+#   DO_E2E_DNS_TIMEOUT  seconds to wait for it to serve a change (default 120)
+#   DO_E2E_BIN          binary to test instead of building the working tree
+set -euo pipefail
+
+if [[ "${DO_E2E:-}" != 1 ]]; then
+  echo "e2e: skipped (set DO_E2E=1, DO_E2E_TOKEN and DO_E2E_DOMAIN to run)"
+  exit 0
+fi
+: "${DO_E2E_TOKEN:?DO_E2E_TOKEN is required}"
+: "${DO_E2E_DOMAIN:?DO_E2E_DOMAIN is required}"
+ns=${DO_E2E_NAMESERVER:-ns1.digitalocean.com}
+dns_timeout=${DO_E2E_DNS_TIMEOUT:-120}
+command -v dig >/dev/null || { echo "e2e: dig is required" >&2; exit 2; }
+
+work=$(mktemp -d)
+bin=${DO_E2E_BIN:-$work/do-ddns}
+if [[ -z "${DO_E2E_BIN:-}" ]]; then
+  (cd "$(dirname "$0")/.." && go build -o "$bin" .)
+fi
+
+domain=$DO_E2E_DOMAIN
+name=e2e-$(date +%s)-$RANDOM
+host=$name.$domain
+value=$work/value
+mkdir "$work/state"
+
+# ddns runs the binary with only the settings given here, so nothing from
+# the caller's environment (DO_CONFIG, IP_SOURCE, ...) leaks into the test.
+ddns() {
+  env -i PATH="$PATH" HOME="$HOME" DO_TOKEN="$DO_E2E_TOKEN" DO_DOMAIN="$domain" DO_NAME="$name" \
+    STATE_DIR="$work/state" "$bin" "$@"
+}
+
+records() {
+  ddns api GET "/domains/$domain/records?type=A&name=$host&per_page=200"
+}
+
+ids() {
+  records | sed -n 's/^ *"id": \([0-9]*\).*/\1/p'
+}
+
+cleanup() {
+  local id
+  for id in $(ids 2>/dev/null); do
+    ddns api DELETE "/domains/$domain/records/$id" >/dev/null 2>&1 ||
+      echo "e2e: could not delete record $id of $host; remove it by hand" >&2
+  done
+  rm -rf "$work"
+}
+trap cleanup EXIT
+
+step() {
+  printf '\n== %s\n' "$*"
+}
+
+fail() {
+  echo "e2e: FAIL: $*" >&2
+  exit 1
+}
+
+# update runs the updater with --data-from $value and checks the action it
+# reports in the summary.
+update() {
+  local want=$1
+  shift
+  ddns --data-from "$value" --ttl 30 --summary "$work/summary.json" "$@" || fail "do-ddns exited with code $?"
+  grep -q "\"action\": \"$want\"" "$work/summary.json" || fail "want action $want, got: $(cat "$work/summary.json")"
+}
+
+# expect_api checks how many A records the API lists for the host.
+expect_api() {
+  local got
+  got=$(ids | wc -l | tr -d ' ')
+  [[ "$got" == "$1" ]] || fail "the API lists $got A record(s) for $host, want $1"
+}
+
+# expect_dns waits until the nameserver answers exactly the given addresses
+# (comma-separated, sorted; empty for none) for the host.
+expect_dns() {
+  local want=$1 got deadline=$((SECONDS + dns_timeout))
+  while :; do
+    got=$(dig +short +norecurse A "$host" "@$ns" | sort | paste -sd, -)
+    [[ "$got" == "$want" ]] && return
+    ((SECONDS < deadline)) || fail "$ns answers '$got' for $host, want '$want'"
+    sleep 5
+  done
+}
+
+step "create $host"
+echo 192.0.2.10 >"$value"
+update created
+expect_api 1
+expect_dns 192.0.2.10
+
+step "leave it alone when nothing changed"
+update unchanged
+expect_api 1
+
+step "update it"
+echo 192.0.2.11 >"$value"
+update updated
+expect_api 1
+expect_dns 192.0.2.11
+
+step "clean up a duplicate"
+ddns api POST "/domains/$domain/records" "{\"type\": \"A\", \"name\": \"$name\", \"data\": \"192.0.2.12\", \"ttl\": 30}" >/dev/null
+expect_api 2
+update unchanged --cleanup-duplicates
+grep -q '"deleted"' "$work/summary.json" || fail "no duplicate deleted: $(cat "$work/summary.json")"
+expect_api 1
+expect_dns 192.0.2.11
+
+step "delete it (temporary record)"
+for id in $(ids); do
+  ddns api DELETE "/domains/$domain/records/$id" >/dev/null
+done
+update created --expires-in 1s
+expect_api 1
+sleep 2
+update expired --expires-in 1s
+expect_api 0
+expect_dns ""
+
+printf '\ne2e: all steps passed\n'
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// expiringRecord is a temporary record created with --expires-in. Once it
+// has been deleted the entry stays behind as a tombstone (Deleted) so the
+// run that created it does not simply recreate it.
+type expiringRecord struct {
+	Domain  string    `json:"domain"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	ID      int64     `json:"id"`
+	Data    string    `json:"data"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+func (e expiringRecord) key() string {
+	return recordKey(e.Domain, e.Name, e.Type)
+}
+
+// duration is a time.Duration that reads from config files as "2h", "90s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("expected a duration string such as \"90s\" or \"2h\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// sweepExpired deletes every tracked temporary record in cfg.StateDir whose
+// expiry has passed, whichever run created it.
+func sweepExpired(ctx context.Context, cfg Config) {
+	db, err := viewStateDB(cfg.StateDir)
+	if err != nil {
+		warnf("%s", msg(msgStateReadFailed, err))
+		return
+	}
+	now := time.Now()
+	var due []expiringRecord
+	for _, e := range db.Expiring {
+		if !e.Deleted && now.After(e.Expires) {
+			due = append(due, e)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	done := map[string]bool{}
+	for _, e := range due {
+		c := cfg
+		c.Domain = e.Domain
+		if err := deleteRecord(ctx, c, e.ID); err != nil && !isNotFound(err) {
+			warnf("%s", msg(msgExpiryDeleteFailed, e.Type, e.Name, e.Domain, e.ID, err))
+			continue
+		}
+		logm(msgExpiryDeleted, e.Type, e.Name, e.Domain, e.ID, e.Data, e.Expires.Format(time.RFC3339))
+		done[e.key()] = true
+	}
+
+	err = updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		for i := range db.Expiring {
+			if done[db.Expiring[i].key()] {
+				db.Expiring[i].Deleted = true
+			}
+		}
+		return len(done) > 0
+	})
+	if err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+}
+
+// expiryTombstone reports whether the record for key was created with an
+// expiry and has since been deleted.
+func expiryTombstone(cfg Config, key string) (expiringRecord, bool) {
+	db, err := viewStateDB(cfg.StateDir)
+	if err != nil {
+		return expiringRecord{}, false
+	}
+	for _, e := range db.Expiring {
+		if e.key() == key && e.Deleted {
+			return e, true
+		}
+	}
+	return expiringRecord{}, false
+}
+
+// trackExpiry records the expiry of a temporary record we just created.
+// Existing entries are kept as they are: later runs do not push the expiry
+// out, otherwise a periodic run with --expires-in would keep the record alive
+// forever.
+func trackExpiry(cfg Config, res runResult) error {
+	return updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		for _, e := range db.Expiring {
+			if e.key() == res.key() {
+				return false
+			}
+		}
+		now := time.Now()
+		e := expiringRecord{
+			Domain:  res.Domain,
+			Name:    res.Name,
+			Type:    res.Type,
+			ID:      res.ID,
+			Data:    res.IP,
+			Created: now,
+			Expires: now.Add(cfg.ExpiresIn),
+		}
+		db.Expiring = append(db.Expiring, e)
+		logm(msgExpiryScheduled, e.Type, e.Name, e.Domain, e.Expires.Format(time.RFC3339))
+		return true
+	})
+}
+
+// untrackExpiry forgets a record's expiry (or tombstone) once it is managed
+// without --expires-in again.
+func untrackExpiry(cfg Config, key string) error {
+	return updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		kept := db.Expiring[:0]
+		for _, e := range db.Expiring {
+			if e.key() != key {
+				kept = append(kept, e)
+			}
+		}
+		changed := len(kept) != len(db.Expiring)
+		db.Expiring = kept
+		return changed
+	})
+}
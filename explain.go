@@ -0,0 +1,52 @@
+package main
+
+import "fmt"
+
+// explainDecisions (--explain) logs the reasoning behind each record's
+// outcome as "why:" lines among the regular output, and adds it to the
+// --summary JSON as "why".
+var explainDecisions bool
+
+// explain records one step of the reasoning for r.
+func (r *runResult) explain(id string, args ...any) {
+	if !explainDecisions {
+		return
+	}
+	why := msg(id, args...)
+	r.Why = append(r.Why, why)
+	logf("  %s", msg(msgExplainWhy, fqdn(r.Name, r.Domain), r.Type, why))
+}
+
+// valueSource says where the desired value of cfg.Type comes from, mirroring
+// the order desiredData tries them in.
+func valueSource(cfg Config) string {
+	switch {
+	case activeTargetName(cfg) != "":
+		return fmt.Sprintf("switch target %q", activeTargetName(cfg))
+	case cfg.DataFrom != "":
+		return "data_from " + cfg.DataFrom
+	case cfg.Failover != nil && isAddressType(cfg.Type):
+		return "the first healthy failover uplink"
+	case cfg.Addresses != nil && cfg.Type == "AAAA":
+		return "the addresses of interface " + cfg.Addresses.Interface
+	case cfg.Rotate != nil && isAddressType(cfg.Type):
+		return "the current rotation target"
+	case !isAddressType(cfg.Type):
+		return "the configured data"
+	}
+	return "IP detection (" + cfg.IPSource + ")"
+}
+
+// comparison describes how sameData compares values of recordType.
+func comparison(recordType string) string {
+	switch recordType {
+	case "TXT":
+		return "TXT text, ignoring how it is split into strings"
+	case "CNAME", "MX", "NS":
+		return "hostnames, ignoring case and the trailing dot"
+	}
+	if isAddressType(recordType) {
+		return "IP addresses"
+	}
+	return "exact text"
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// failoverConfig publishes the public address of the first healthy uplink
+// of a multi-WAN site. Uplinks are listed in order of preference.
+type failoverConfig struct {
+	Check   string   `json:"check,omitempty"` // default probe for every uplink
+	Uplinks []uplink `json:"uplinks"`
+}
+
+type uplink struct {
+	Name string `json:"name"`
+	// Bind is the local address (or interface name) detection and health
+	// probes are sent from. The OS must route traffic from it through the
+	// uplink, e.g. with a source-based policy routing rule.
+	Bind     string `json:"bind"`
+	Check    string `json:"check,omitempty"`
+	IPSource string `json:"ip_source,omitempty"`
+}
+
+func (fc *failoverConfig) validate() error {
+	if len(fc.Uplinks) == 0 {
+		return errors.New("failover: no uplinks")
+	}
+	if err := validateCheck(fc.Check); err != nil {
+		return fmt.Errorf("failover: %w", err)
+	}
+	seen := map[string]bool{}
+	for i, u := range fc.Uplinks {
+		if u.Name == "" || u.Bind == "" {
+			return fmt.Errorf("failover: uplinks[%d]: name and bind are required", i)
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("failover: uplink %q is defined more than once", u.Name)
+		}
+		seen[u.Name] = true
+		if err := validateCheck(u.Check); err != nil {
+			return fmt.Errorf("failover: uplink %q: %w", u.Name, err)
+		}
+	}
+	return nil
+}
+
+// localAddr resolves an uplink's bind setting to a source address of the
+// given family ("tcp4" or "tcp6").
+func localAddr(bind, network string) (net.IP, error) {
+	want4 := network == "tcp4"
+	if ip := net.ParseIP(bind); ip != nil {
+		if (ip.To4() != nil) != want4 {
+			return nil, fmt.Errorf("%s is not an %s address", bind, familyName(network))
+		}
+		return ip, nil
+	}
+	return interfaceAddr(bind, network)
+}
+
+// pickUplink returns the public address of the first uplink that passes its
+// health probe and whose address can be detected through it. Uplinks that
+// are down are only warnings when no uplink is left, so a successful
+// failover does not fail a --strict run.
+func pickUplink(ctx context.Context, cfg Config, det *ipDetector) (string, error) {
+	network := ipNetwork(cfg.Type)
+	var down []string
+	for _, u := range cfg.Failover.Uplinks {
+		ip, err := uplinkAddress(ctx, cfg, u, network, det)
+		if err != nil {
+			down = append(down, msg(msgUplinkDown, u.Name, familyName(network), err))
+			continue
+		}
+		for _, m := range down {
+			logf("%s", m)
+		}
+		logm(msgUplinkUsed, u.Name, cfg.Type, cfg.Name, cfg.Domain, ip)
+		return ip, nil
+	}
+	for _, m := range down {
+		warnf("%s", m)
+	}
+	return "", fmt.Errorf("failover: all %d uplinks are down", len(cfg.Failover.Uplinks))
+}
+
+func uplinkAddress(ctx context.Context, cfg Config, u uplink, network string, det *ipDetector) (string, error) {
+	local, err := localAddr(u.Bind, network)
+	if err != nil {
+		return "", err
+	}
+	check := u.Check
+	if check == "" {
+		check = cfg.Failover.Check
+	}
+	if err := probe(ctx, check, local); err != nil {
+		return "", fmt.Errorf("health check %s: %w", check, err)
+	}
+	sources := u.IPSource
+	if sources == "" {
+		sources = cfg.IPSource
+	}
+	return det.getVia(ctx, sources, network, local)
+}
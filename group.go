@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Records that belong together, e.g. a host's A, AAAA and TXT records, can
+// be put in a group (`group: hq` on each entry). After each run a group
+// whose members did not all succeed is handled by group_policy:
+//
+//   - degrade (default): keep what succeeded and mark the group degraded;
+//     the failed members are retried on the next run, and the group is
+//     complete again once they succeed
+//   - rollback: undo this run's changes to the other members (delete the
+//     records it created, restore the previous value of those it updated),
+//     so the group is either updated as a whole or left as it was
+//
+// Groups that are not complete are kept in the state DB (groups) and shown
+// on /status.
+
+const (
+	groupDegrade  = "degrade"
+	groupRollback = "rollback"
+)
+
+func parseGroupPolicy(s string) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(s)); p {
+	case "":
+		return groupDegrade, nil
+	case groupDegrade, groupRollback:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown group policy %q (want degrade or rollback)", s)
+}
+
+// groupState is a group that is not complete, in the state DB.
+type groupState struct {
+	State  string    `json:"state"` // degraded or rolled-back
+	Since  time.Time `json:"since"`
+	Failed []string  `json:"failed"` // record keys
+	Error  string    `json:"error,omitempty"`
+}
+
+// applyGroups applies the group policy to the results of a run and records
+// each result's group and group state.
+func applyGroups(ctx context.Context, records []Config, perRecord [][]runResult) {
+	groups := map[string][]int{}
+	for i, c := range records {
+		if c.Group != "" {
+			groups[c.Group] = append(groups[c.Group], i)
+		}
+	}
+	if len(groups) == 0 {
+		return
+	}
+	states := map[string]*groupState{}
+	for _, name := range slices.Sorted(maps.Keys(groups)) {
+		members := groups[name]
+		var failed []string
+		var firstErr error
+		for _, i := range members {
+			for _, r := range perRecord[i] {
+				if r.Err != nil || r.Action == "aborted" {
+					failed = append(failed, r.key())
+					if firstErr == nil {
+						firstErr = r.Err
+					}
+				}
+			}
+		}
+		state := "ok"
+		if len(failed) > 0 {
+			state = "degraded"
+			if records[members[0]].GroupPolicy == groupRollback {
+				logm(msgGroupRollback, name, strings.Join(failed, ", "))
+				if undoGroup(ctx, records, members, perRecord, name) {
+					state = "rolled-back"
+				}
+			} else {
+				logm(msgGroupDegraded, name, strings.Join(failed, ", "))
+			}
+			gs := &groupState{State: state, Failed: failed}
+			if firstErr != nil {
+				gs.Error = firstErr.Error()
+			}
+			states[name] = gs
+		}
+		for _, i := range members {
+			for k := range perRecord[i] {
+				perRecord[i][k].Group, perRecord[i][k].GroupState = name, state
+			}
+		}
+	}
+	saveGroupStates(records[0].StateDir, groups, states)
+}
+
+// undoGroup undoes this run's changes to the members of a group and
+// reports whether all of them could be undone.
+func undoGroup(ctx context.Context, records []Config, members []int, perRecord [][]runResult, group string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
+	defer cancel()
+	ok := true
+	for _, i := range members {
+		for k := range perRecord[i] {
+			if !undoChange(ctx, records[i], &perRecord[i][k], group) {
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// saveGroupStates updates the state DB's groups: incomplete ones are added
+// (keeping when they first failed), complete and no longer configured ones
+// removed.
+func saveGroupStates(dir string, groups map[string][]int, states map[string]*groupState) {
+	var healthy []string
+	if err := updateStateDB(dir, func(db *stateDB) bool {
+		changed := false
+		for name := range db.Groups {
+			if _, ok := states[name]; ok {
+				continue
+			}
+			if _, configured := groups[name]; configured {
+				healthy = append(healthy, name)
+			}
+			delete(db.Groups, name)
+			changed = true
+		}
+		for name, gs := range states {
+			gs.Since = time.Now()
+			if old := db.Groups[name]; old != nil {
+				gs.Since = old.Since
+				if old.State == gs.State && slices.Equal(old.Failed, gs.Failed) && old.Error == gs.Error {
+					continue
+				}
+			}
+			if db.Groups == nil {
+				db.Groups = map[string]*groupState{}
+			}
+			db.Groups[name] = gs
+			changed = true
+		}
+		return changed
+	}); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+	slices.Sort(healthy)
+	for _, name := range healthy {
+		logm(msgGroupComplete, name)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApplyGroups(t *testing.T) {
+	failure := errors.New("HTTP 422")
+	tests := []struct {
+		name       string
+		policy     string
+		vpn        runResult // the result of the member that is not hq
+		wantState  string
+		wantAction string // of hq
+		wantHQ     DomainRecord
+	}{
+		{name: "complete", policy: groupRollback,
+			vpn:       runResult{Action: "unchanged"},
+			wantState: "ok", wantAction: "updated", wantHQ: DomainRecord{Data: "203.0.113.7", TTL: 300}},
+		{name: "degrade", policy: groupDegrade,
+			vpn:       runResult{Action: "error", Err: failure},
+			wantState: "degraded", wantAction: "updated", wantHQ: DomainRecord{Data: "203.0.113.7", TTL: 300}},
+		{name: "degrade on an aborted member", policy: groupDegrade,
+			vpn:       runResult{Action: "aborted"},
+			wantState: "degraded", wantAction: "updated", wantHQ: DomainRecord{Data: "203.0.113.7", TTL: 300}},
+		// The update of hq is undone: its previous value and TTL come back.
+		{name: "rollback", policy: groupRollback,
+			vpn:       runResult{Action: "error", Err: failure},
+			wantState: "rolled-back", wantAction: "rolled-back", wantHQ: DomainRecord{Data: "192.0.2.1", TTL: 3600}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &zone{records: []DomainRecord{{ID: 1, Type: "A", Name: "hq", Data: "203.0.113.7", TTL: 300}}}
+			fakeAPI(t, z)
+			dir := t.TempDir()
+			hq, vpn := testConfig(), testConfig()
+			hq.Name, hq.Type, hq.Types, hq.TTL = "hq", "A", []string{"A"}, 300
+			vpn.Name, vpn.Type, vpn.Types = "vpn", "TXT", []string{"TXT"}
+			for _, c := range []*Config{&hq, &vpn} {
+				c.Group, c.GroupPolicy, c.StateDir = "site", tt.policy, dir
+			}
+
+			tt.vpn.Domain, tt.vpn.Name, tt.vpn.Type = "example.com", "vpn", "TXT"
+			perRecord := [][]runResult{
+				{{Domain: "example.com", Name: "hq", Type: "A", Action: "updated", ID: 1,
+					Previous: &DomainRecord{ID: 1, Type: "A", Name: "hq", Data: "192.0.2.1", TTL: 3600}}},
+				{tt.vpn},
+			}
+			applyGroups(context.Background(), []Config{hq, vpn}, perRecord)
+
+			for _, r := range []runResult{perRecord[0][0], perRecord[1][0]} {
+				if r.Group != "site" || r.GroupState != tt.wantState {
+					t.Errorf("%s: group %q, state %q; want site, %q", r.Name, r.Group, r.GroupState, tt.wantState)
+				}
+			}
+			if got := perRecord[0][0].Action; got != tt.wantAction {
+				t.Errorf("hq action %q, want %q", got, tt.wantAction)
+			}
+			z.mu.Lock()
+			if got := z.records[0]; got.Data != tt.wantHQ.Data || got.TTL != tt.wantHQ.TTL {
+				t.Errorf("hq = %s ttl=%d, want %s ttl=%d", got.Data, got.TTL, tt.wantHQ.Data, tt.wantHQ.TTL)
+			}
+			z.mu.Unlock()
+
+			db, err := viewStateDB(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gs := db.Groups["site"]
+			switch {
+			case tt.wantState == "ok" && gs != nil:
+				t.Errorf("complete group kept in the state DB: %+v", gs)
+			case tt.wantState != "ok" && (gs == nil || gs.State != tt.wantState || !slices.Equal(gs.Failed, []string{tt.vpn.key()})):
+				t.Errorf("state DB group = %+v, want %s with %s failed", gs, tt.wantState, tt.vpn.key())
+			}
+		})
+	}
+}
+
+// A group that stays degraded keeps the time it first failed; once it is
+// complete again it leaves the state DB.
+func TestSaveGroupStatesSince(t *testing.T) {
+	dir := t.TempDir()
+	groups := map[string][]int{"site": {0, 1}, "other": {2}}
+	degraded := func() map[string]*groupState {
+		return map[string]*groupState{"site": {State: "degraded", Failed: []string{"example.com/vpn/TXT"}}}
+	}
+	since := func() time.Time {
+		db, err := viewStateDB(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.Groups["site"] == nil {
+			return time.Time{}
+		}
+		return db.Groups["site"].Since
+	}
+
+	saveGroupStates(dir, groups, degraded())
+	first := since()
+	if first.IsZero() {
+		t.Fatal("degraded group not saved")
+	}
+	time.Sleep(10 * time.Millisecond)
+	saveGroupStates(dir, groups, degraded())
+	if got := since(); !got.Equal(first) {
+		t.Errorf("since = %s on the second run, want %s", got, first)
+	}
+
+	// A different failure is saved, still with the first time.
+	changed := degraded()
+	changed["site"].Error = "HTTP 422"
+	saveGroupStates(dir, groups, changed)
+	db, _ := viewStateDB(dir)
+	if gs := db.Groups["site"]; gs == nil || gs.Error != "HTTP 422" || !gs.Since.Equal(first) {
+		t.Errorf("group after a new error = %+v, want since %s", gs, first)
+	}
+
+	saveGroupStates(dir, groups, nil)
+	if got := since(); !got.IsZero() {
+		t.Errorf("complete group still in the state DB (since %s)", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const healthTimeout = 5 * time.Second
+
+// probe runs a health check. Supported forms:
+//
+//	tcp://host:port       TCP connect
+//	http(s)://host/path   GET answering 2xx or 3xx
+//
+// An empty check always passes. A non-nil local sends the probe from that
+// address, so it tests the uplink the address belongs to.
+func probe(ctx context.Context, check string, local net.IP) error {
+	if check == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	switch {
+	case strings.HasPrefix(check, "tcp://"):
+		d := &net.Dialer{}
+		if local != nil {
+			d.LocalAddr = &net.TCPAddr{IP: local}
+		}
+		conn, err := d.DialContext(ctx, "tcp", strings.TrimPrefix(check, "tcp://"))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case strings.HasPrefix(check, "http://"), strings.HasPrefix(check, "https://"):
+		req, err := http.NewRequestWithContext(ctx, "GET", check, nil)
+		if err != nil {
+			return err
+		}
+		setRequestHeaders(req)
+		resp, err := probeClient(local).Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported health check %q (want tcp://host:port or an http(s) URL)", check)
+	}
+}
+
+// probeClients are the HTTP clients of the health checks, one per local
+// address (uplink), created on first use. Keep-alives are off so every probe
+// opens a new connection through the uplink and none are left idle.
+var (
+	probeClientsMu sync.Mutex
+	probeClients   = map[string]*http.Client{}
+)
+
+func probeClient(local net.IP) *http.Client {
+	key := ""
+	if local != nil {
+		key = local.String()
+	}
+	probeClientsMu.Lock()
+	defer probeClientsMu.Unlock()
+	c := probeClients[key]
+	if c == nil {
+		d := &net.Dialer{}
+		if local != nil {
+			d.LocalAddr = &net.TCPAddr{IP: local}
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.DialContext = d.DialContext
+		tr.DisableKeepAlives = true
+		c = &http.Client{
+			Transport: wrapTransport(tr),
+			// A redirect already proves the target is up.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		probeClients[key] = c
+	}
+	return c
+}
+
+func validateCheck(check string) error {
+	if check == "" || strings.HasPrefix(check, "tcp://") || strings.HasPrefix(check, "http://") || strings.HasPrefix(check, "https://") {
+		return nil
+	}
+	return fmt.Errorf("unsupported health check %q (want tcp://host:port or an http(s) URL)", check)
+}
+
+// probeAll runs checks concurrently and returns one error (nil = healthy)
+// per check.
+func probeAll(ctx context.Context, checks []string) []error {
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = probe(ctx, c, nil)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
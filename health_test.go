@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	var keepAlive atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.Close {
+			keepAlive.Store(true)
+		}
+		switch r.URL.Path {
+		case "/up":
+		case "/moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	down := freeAddr(t)
+
+	tests := []struct {
+		check   string
+		wantErr string
+	}{
+		{"", ""},
+		{srv.URL + "/up", ""},
+		{srv.URL + "/moved", ""},
+		{srv.URL + "/down", "HTTP 503"},
+		{"tcp://" + srv.Listener.Addr().String(), ""},
+		{"tcp://" + down, "refused"},
+		{"http://" + down + "/", "refused"},
+		{"icmp://192.0.2.1", "unsupported health check"},
+	}
+	for _, tt := range tests {
+		err := probe(context.Background(), tt.check, net.ParseIP("127.0.0.1"))
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("probe(%q) = %v, want %q", tt.check, err, tt.wantErr)
+		}
+	}
+	if keepAlive.Load() {
+		t.Error("a probe asked to keep its connection open")
+	}
+	if probeClient(nil) != probeClient(nil) || probeClient(nil) == probeClient(net.ParseIP("127.0.0.1")) {
+		t.Error("probe clients are not shared per local address")
+	}
+}
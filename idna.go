@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Internationalized domain names are converted to their ASCII (punycode,
+// RFC 3492) form when they are read from flags, the environment or the
+// config file, so API calls, state files and the state DB only ever see
+// "xn--" labels. Labels are lowercased before encoding; full UTS #46
+// mapping and normalisation are not applied, so names should be typed in
+// NFC (as nearly every keyboard produces them).
+
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+	acePrefix       = "xn--"
+)
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyThreshold(k, bias int) int {
+	return min(max(k-bias, punyTMin), punyTMax)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyValue(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A')
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26
+	}
+	return -1
+}
+
+// punyEncode encodes one label's code points, without the "xn--" prefix.
+func punyEncode(label string) string {
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for handled < len(runes) {
+		m := math.MaxInt32
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+var errPunycode = errors.New("invalid punycode")
+
+// punyDecode decodes one label without the "xn--" prefix.
+func punyDecode(s string) (string, error) {
+	var out []rune
+	pos := 0
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		for _, c := range []byte(s[:i]) {
+			if c >= 0x80 {
+				return "", errPunycode
+			}
+			out = append(out, rune(c))
+		}
+		pos = i + 1
+	}
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for pos < len(s) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(s) {
+				return "", errPunycode
+			}
+			d := punyValue(s[pos])
+			pos++
+			if d < 0 || d > (math.MaxInt32-i)/w {
+				return "", errPunycode
+			}
+			i += d * w
+			t := punyThreshold(k, bias)
+			if d < t {
+				break
+			}
+			w *= punyBase - t
+		}
+		bias = punyAdapt(i-oldi, len(out)+1, oldi == 0)
+		n += i / (len(out) + 1)
+		if n > math.MaxInt32 {
+			return "", errPunycode
+		}
+		i %= len(out) + 1
+		out = append(out[:i], append([]rune{rune(n)}, out[i:]...)...)
+		i++
+	}
+	return string(out), nil
+}
+
+// toASCII converts a domain or record name with Unicode labels to its
+// ASCII form; ASCII labels (including "@" and "*") are left as they are.
+func toASCII(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, l := range labels {
+		if isASCII(l) {
+			continue
+		}
+		a := acePrefix + punyEncode(strings.ToLower(l))
+		if len(a) > 63 {
+			return "", fmt.Errorf("%q: label %q is longer than 63 bytes as %s", name, l, a)
+		}
+		labels[i] = a
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// toUnicode is the inverse of toASCII; labels that do not decode are kept.
+// Like toASCII it lowercases, so "XN--MNCHEN-3YA" reads as "münchen".
+func toUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, l := range labels {
+		if len(l) > len(acePrefix) && strings.EqualFold(l[:len(acePrefix)], acePrefix) {
+			if u, err := punyDecode(strings.ToLower(l[len(acePrefix):])); err == nil {
+				labels[i] = u
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// displayName is fqdn for people: the ASCII name, followed by its Unicode
+// form if it has one.
+func displayName(name, domain string) string {
+	a := fqdn(name, domain)
+	if u := toUnicode(a); u != a {
+		return a + " (" + u + ")"
+	}
+	return a
+}
+
+// idnaValue is a flag for a domain or record name; Unicode input is stored
+// in ASCII form.
+type idnaValue struct{ p *string }
+
+func (v idnaValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v idnaValue) Set(s string) error {
+	a, err := toASCII(s)
+	if err != nil {
+		return err
+	}
+	*v.p = a
+	return nil
+}
+
+// nameFlag registers a domain/name flag defaulting to env.
+func nameFlag(fs *flag.FlagSet, p *string, name, env, usage string) {
+	*p = os.Getenv(env)
+	if a, err := toASCII(*p); err == nil {
+		*p = a
+	}
+	fs.Var(idnaValue{p}, name, usage)
+}
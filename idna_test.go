@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The sample strings of RFC 3492 section 7.1.
+var punycodeSamples = []struct {
+	name, unicode, puny string
+}{
+	{"(A) Arabic", "ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+	{"(B) Chinese (simplified)", "他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+	{"(C) Chinese (traditional)", "他們爲什麽不說中文", "ihqwctvzc91f659drss3x8bo0yb"},
+	{"(D) Czech", "Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+	{"(E) Hebrew", "למההםפשוטלאמדבריםעברית", "4dbcagdahymbxekheh6e0a7fei0b"},
+	{"(F) Hindi", "यहलोगहिन्दीक्योंनहींबोलसकतेहैं", "i1baa7eci9glrd9b2ae1bj0hfcgg6iyaf8o0a1dig0cd"},
+	{"(G) Japanese", "なぜみんな日本語を話してくれないのか", "n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa"},
+	{"(I) Russian", "почемужеонинеговорятпорусски", "b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+	{"(K) Vietnamese", "TạisaohọkhôngthểchỉnóitiếngViệt", "TisaohkhngthchnitingVit-kjcr8268qyxafd2f1b9g"},
+	{"(L) 3<nen>B<gumi><kinpachi><sensei>", "3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+	{"(M) <amuro><namie>-with-SUPER-MONKEYS", "安室奈美恵-with-SUPER-MONKEYS", "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+	{"(S) -> $1.00 <-", "-> $1.00 <-", "-> $1.00 <--"},
+}
+
+func TestPunycodeSamples(t *testing.T) {
+	for _, s := range punycodeSamples {
+		if got := punyEncode(s.unicode); got != s.puny {
+			t.Errorf("%s: punyEncode = %q, want %q", s.name, got, s.puny)
+		}
+		if got, err := punyDecode(s.puny); err != nil || got != s.unicode {
+			t.Errorf("%s: punyDecode(%q) = %q, %v", s.name, s.puny, got, err)
+		}
+	}
+	// Digits decode in either case; the RFC prints (I) with mixed case.
+	if got, err := punyDecode("b1abfaaepdrnnbgefbaDotcwatmq2g4l"); err != nil || got != punycodeSamples[7].unicode {
+		t.Errorf("mixed-case punyDecode = %q, %v", got, err)
+	}
+}
+
+func TestPunyDecodeInvalid(t *testing.T) {
+	for _, s := range []string{"ü-a", "a-!", "a-b", "99999999999"} {
+		if got, err := punyDecode(s); err == nil {
+			t.Errorf("punyDecode(%q) = %q, want an error", s, got)
+		}
+	}
+}
+
+func TestToASCII(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"münchen.example", "xn--mnchen-3ya.example"},
+		{"MÜNCHEN.example", "xn--mnchen-3ya.example"},
+		{"www.bücher.example", "www.xn--bcher-kva.example"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"@", "@"},
+		{"*.café", "*.xn--caf-dma"},
+		{"already.xn--mnchen-3ya.example", "already.xn--mnchen-3ya.example"},
+		{"XN--MNCHEN-3YA.example", "XN--MNCHEN-3YA.example"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := toASCII(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("toASCII(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			continue
+		}
+		// Converting again changes nothing.
+		if again, err := toASCII(got); err != nil || again != got {
+			t.Errorf("toASCII(%q) = %q, %v: not idempotent", got, again, err)
+		}
+	}
+
+	if _, err := toASCII(strings.Repeat("ü", 60) + ".example"); err == nil || !strings.Contains(err.Error(), "longer than 63 bytes") {
+		t.Errorf("overlong label: err = %v", err)
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"xn--mnchen-3ya.example", "münchen.example"},
+		{"XN--MNCHEN-3YA.example", "münchen.example"},
+		{"www.xn--bcher-kva.example", "www.bücher.example"},
+		{"plain.example", "plain.example"},
+		{"xn--.example", "xn--.example"},
+		{"xn--a-!.example", "xn--a-!.example"}, // does not decode: kept
+	}
+	for _, tt := range tests {
+		if got := toUnicode(tt.in); got != tt.want {
+			t.Errorf("toUnicode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := displayName("www", "xn--mnchen-3ya.example"); got != "www.xn--mnchen-3ya.example (www.münchen.example)" {
+		t.Errorf("displayName = %q", got)
+	}
+}
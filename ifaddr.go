@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// allowTemporaryIPv6 lets interface lookups return RFC 4941 temporary
+// (privacy) addresses (--allow-temporary-ipv6). They rotate every few hours,
+// so publishing one makes the AAAA record useless soon after.
+var allowTemporaryIPv6 bool
+
+// Address flags as shown in /proc/net/if_inet6.
+const (
+	ifaTemporary  = 0x01
+	ifaDeprecated = 0x20
+)
+
+// interfaceAddr returns the interface's global address of the given family
+// ("tcp4" or "tcp6"), the first of interfaceAddrs.
+func interfaceAddr(name, network string) (net.IP, error) {
+	addrs, err := interfaceAddrs(name, network)
+	if err != nil {
+		return nil, err
+	}
+	return addrs[0], nil
+}
+
+// interfaceAddrs returns the interface's global addresses of the given
+// family, best first. Among IPv6 addresses stable ones (EUI-64 or
+// stable-privacy) are preferred over deprecated ones and public ones over
+// unique local ones; temporary addresses are skipped unless
+// allowTemporaryIPv6 is set.
+func interfaceAddrs(name, network string) ([]net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	want4 := network == "tcp4"
+	var ips []net.IP
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if ok && (ipn.IP.To4() != nil) == want4 && ipn.IP.IsGlobalUnicast() {
+			ips = append(ips, ipn.IP)
+		}
+	}
+	var flags map[string]int
+	if !want4 {
+		flags = ipv6AddrFlags(name)
+	}
+	ranked, temporary := rankAddrs(ips, flags, allowTemporaryIPv6)
+	if len(ranked) == 0 {
+		if temporary > 0 {
+			return nil, fmt.Errorf("interface %s only has temporary IPv6 addresses (--allow-temporary-ipv6 to use them)", name)
+		}
+		return nil, fmt.Errorf("interface %s has no %s address", name, familyName(network))
+	}
+	return ranked, nil
+}
+
+// rankAddrs orders ips best first by their /proc/net/if_inet6 flags: not
+// deprecated, then public, then not temporary. Temporary addresses are
+// dropped, and counted, unless allowTemporary is set.
+func rankAddrs(ips []net.IP, flags map[string]int, allowTemporary bool) ([]net.IP, int) {
+	type candidate struct {
+		ip    net.IP
+		score int
+	}
+	var out []candidate
+	temporary := 0
+	for _, ip := range ips {
+		f := flags[ip.String()]
+		if f&ifaTemporary != 0 && !allowTemporary {
+			temporary++
+			continue
+		}
+		score := 0
+		if f&ifaDeprecated == 0 {
+			score += 4
+		}
+		if !ip.IsPrivate() {
+			score += 2
+		}
+		if f&ifaTemporary == 0 {
+			score++
+		}
+		out = append(out, candidate{ip, score})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score > out[j].score })
+	ranked := make([]net.IP, len(out))
+	for i, c := range out {
+		ranked[i] = c.ip
+	}
+	return ranked, temporary
+}
+
+// ipv6AddrFlags reads the flags of the interface's IPv6 addresses from
+// /proc/net/if_inet6, keyed by address. Elsewhere it returns nil and all
+// addresses count as stable.
+func ipv6AddrFlags(name string) map[string]int {
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseIfInet6(f, name)
+}
+
+func parseIfInet6(r io.Reader, name string) map[string]int {
+	out := map[string]int{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// address ifindex prefixlen scope flags name
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 6 || fields[5] != name {
+			continue
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != net.IPv6len {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		out[net.IP(b).String()] = int(flags)
+	}
+	return out
+}
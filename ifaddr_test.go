@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A sample of /proc/net/if_inet6 for eth0: a stable address, a temporary
+// one, a deprecated stable one and a unique local one.
+const ifInet6 = `20010db800000000021122fffe334455 02 40 00 00 eth0
+20010db8000000001c7e9a4b2d3f6e10 02 40 00 01 eth0
+20010db8000000000000000000000042 02 40 00 20 eth0
+fd00000000000000021122fffe334455 02 40 00 00 eth0
+fe80000000000000021122fffe334455 02 40 20 80 eth0
+20010db8000000000000000000000099 03 40 00 00 wlan0
+not-hex 02 40 00 00 eth0
+`
+
+func TestParseIfInet6(t *testing.T) {
+	flags := parseIfInet6(strings.NewReader(ifInet6), "eth0")
+	want := map[string]int{
+		"2001:db8::211:22ff:fe33:4455":  0x00,
+		"2001:db8::1c7e:9a4b:2d3f:6e10": 0x01,
+		"2001:db8::42":                  0x20,
+		"fd00::211:22ff:fe33:4455":      0x00,
+		"fe80::211:22ff:fe33:4455":      0x80,
+	}
+	if len(flags) != len(want) {
+		t.Errorf("flags = %v, want %v", flags, want)
+	}
+	for ip, f := range want {
+		if got, ok := flags[ip]; !ok || got != f {
+			t.Errorf("flags[%s] = %#x, %t; want %#x", ip, got, ok, f)
+		}
+	}
+}
+
+func TestRankAddrs(t *testing.T) {
+	flags := parseIfInet6(strings.NewReader(ifInet6), "eth0")
+	var (
+		stable     = "2001:db8::211:22ff:fe33:4455"
+		temporary  = "2001:db8::1c7e:9a4b:2d3f:6e10"
+		deprecated = "2001:db8::42"
+		ula        = "fd00::211:22ff:fe33:4455"
+	)
+	tests := []struct {
+		name           string
+		ips            []string
+		allowTemporary bool
+		want           []string
+		wantTemporary  int
+	}{
+		// Not deprecated outweighs public: a unique local stable address
+		// beats a deprecated public one.
+		{"stable first", []string{deprecated, ula, temporary, stable}, false,
+			[]string{stable, ula, deprecated}, 1},
+		{"public before unique local", []string{ula, stable}, false,
+			[]string{stable, ula}, 0},
+		{"temporary allowed, ranked after stable", []string{temporary, stable}, true,
+			[]string{stable, temporary}, 0},
+		{"only temporary", []string{temporary}, false,
+			[]string{}, 1},
+		{"no flags known", []string{"2001:db8::1", "2001:db8::2"}, false,
+			[]string{"2001:db8::1", "2001:db8::2"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, s := range tt.ips {
+				ips = append(ips, net.ParseIP(s))
+			}
+			ranked, temp := rankAddrs(ips, flags, tt.allowTemporary)
+			got := []string{}
+			for _, ip := range ranked {
+				got = append(got, ip.String())
+			}
+			if !slices.Equal(got, tt.want) || temp != tt.wantTemporary {
+				t.Errorf("rankAddrs = %v, %d temporary; want %v, %d", got, temp, tt.want, tt.wantTemporary)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultIPSources = "https://api64.ipify.org,https://icanhazip.com"
+
+// ipClients force the transport address family so a dual-stack IP source
+// reports the address of the family we are about to publish. Clients are
+// created on first use, one per family and local address (uplink).
+var (
+	ipClientsMu sync.Mutex
+	ipClients   = map[string]*http.Client{}
+)
+
+func familyClient(network string, local net.IP) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if local != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: local}
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Transport: wrapTransport(tr), Timeout: 15 * time.Second}
+}
+
+// ipClient returns the client for network, sending from local if it is set.
+func ipClient(network string, local net.IP) *http.Client {
+	key := network
+	if local != nil {
+		key += " " + local.String()
+	}
+	ipClientsMu.Lock()
+	defer ipClientsMu.Unlock()
+	c := ipClients[key]
+	if c == nil {
+		c = familyClient(network, local)
+		ipClients[key] = c
+	}
+	return c
+}
+
+// ipNetwork returns the transport family used to detect the address for a
+// record type: AAAA records need IPv6, everything else IPv4.
+func ipNetwork(recordType string) string {
+	if recordType == "AAAA" {
+		return "tcp6"
+	}
+	return "tcp4"
+}
+
+func familyName(network string) string {
+	if network == "tcp6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ipDetector memoizes public IP lookups for one run, so every record of the
+// same family is published with the same detected address.
+type ipDetector struct {
+	mu      sync.Mutex
+	lookups map[string]*ipLookup
+	settles map[string]*ipLookup
+
+	// crossCheck lists independent IP echo services every detected address
+	// is confirmed with before it is published; see crossCheckIP.
+	crossCheck string
+}
+
+type ipLookup struct {
+	once sync.Once
+	ip   string
+	err  error
+}
+
+func newIPDetector(crossCheck string) *ipDetector {
+	return &ipDetector{lookups: map[string]*ipLookup{}, settles: map[string]*ipLookup{}, crossCheck: crossCheck}
+}
+
+func (d *ipDetector) get(ctx context.Context, ipSources, network string) (string, error) {
+	return d.getVia(ctx, ipSources, network, nil)
+}
+
+// getVia is get with detection requests sent from the local address local,
+// i.e. through the uplink that address belongs to.
+func (d *ipDetector) getVia(ctx context.Context, ipSources, network string, local net.IP) (string, error) {
+	d.mu.Lock()
+	key := network + " " + ipSources
+	if local != nil {
+		key += " " + local.String()
+	}
+	l := d.lookups[key]
+	if l == nil {
+		l = &ipLookup{}
+		d.lookups[key] = l
+	}
+	d.mu.Unlock()
+
+	l.once.Do(func() {
+		l.ip, l.err = getPublicIP(ctx, ipSources, network, local)
+		if l.err == nil && d.crossCheck != "" {
+			l.err = crossCheckIP(ctx, l.ip, d.crossCheck, network, local)
+		}
+		if l.err == nil && local != nil {
+			logm(msgIPDetectedVia, familyName(network), local, l.ip)
+		} else if l.err == nil {
+			logm(msgIPDetected, familyName(network), l.ip)
+		}
+	})
+	return l.ip, l.err
+}
+
+// maxSettleRounds bounds how often settle waits for an address that keeps
+// changing.
+const maxSettleRounds = 3
+
+// settle waits before a newly observed address ip is published and detects
+// again, so a short-lived transition address (e.g. while PPPoE reconnects)
+// is never published. If the address changed meanwhile the new one has to
+// survive a wait of its own. Records of the same family share one settle
+// per run.
+func (d *ipDetector) settle(ctx context.Context, ipSources, network, ip string, wait time.Duration) (string, error) {
+	d.mu.Lock()
+	key := network + " " + ipSources + " " + ip
+	l := d.settles[key]
+	if l == nil {
+		l = &ipLookup{}
+		d.settles[key] = l
+	}
+	d.mu.Unlock()
+
+	l.once.Do(func() {
+		for round := 1; ; round++ {
+			logm(msgIPSettling, familyName(network), ip, wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				l.err = ctx.Err()
+				return
+			}
+			again, err := getPublicIP(ctx, ipSources, network, nil)
+			if err == nil && d.crossCheck != "" {
+				err = crossCheckIP(ctx, again, d.crossCheck, network, nil)
+			}
+			if err != nil {
+				l.err = err
+				return
+			}
+			if again == ip {
+				logm(msgIPSettled, familyName(network), ip)
+				l.ip = ip
+				return
+			}
+			if round == maxSettleRounds {
+				l.err = fmt.Errorf("public %s address did not settle (last %s, now %s)", familyName(network), ip, again)
+				return
+			}
+			logm(msgIPUnsettled, familyName(network), ip, again)
+			ip = again
+		}
+	})
+	return l.ip, l.err
+}
+
+// getPublicIP asks every source in the comma-separated ipSources list for our
+// public address of the given family ("tcp4" or "tcp6"). Failing sources are
+// ignored; if the working ones disagree the most common answer wins, ties
+// going to the source listed first. A non-nil local binds the requests to
+// that source address.
+func getPublicIP(ctx context.Context, ipSources, network string, local net.IP) (string, error) {
+	sources := splitList(ipSources)
+	if len(sources) == 0 {
+		return "", fmt.Errorf("no IP source configured")
+	}
+
+	client := ipClient(network, local)
+	ips := make([]string, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips[i], errs[i] = fetchIP(ctx, client, src, network, local)
+		}()
+	}
+	wg.Wait()
+
+	votes := map[string]int{}
+	best := ""
+	for i, ip := range ips {
+		if errs[i] != nil {
+			continue
+		}
+		votes[ip]++
+		if best == "" || votes[ip] > votes[best] {
+			best = ip
+		}
+	}
+	if len(sources) > 1 {
+		// Falling back to another source is not a warning; losing them all is.
+		report := logf
+		if best == "" {
+			report = warnf
+		}
+		for i, err := range errs {
+			if err != nil {
+				report("%s", msg(msgIPSourceFailed, sources[i], err))
+			}
+		}
+	}
+	if best == "" {
+		if len(sources) == 1 {
+			return "", errs[0]
+		}
+		return "", fmt.Errorf("all %d IP sources failed", len(sources))
+	}
+	if len(votes) > 1 {
+		warnf("%s", msg(msgIPSourcesDisagree, votes, best))
+	}
+	return best, nil
+}
+
+// cgnatRange is the shared address space carriers use behind CGNAT
+// (RFC 6598); an address there can never be reached from outside.
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// crossCheckIP confirms a detected address with every source in
+// crossSources and refuses it if any of them disagrees or cannot answer, or
+// if the address is not publicly routable. A transparent proxy or CGNAT in
+// front of one IP source then stops the run instead of publishing a wrong
+// address.
+func crossCheckIP(ctx context.Context, ip, crossSources, network string, local net.IP) error {
+	parsed := net.ParseIP(ip)
+	if parsed.IsPrivate() || parsed.IsLoopback() || parsed.IsLinkLocalUnicast() || cgnatRange.Contains(parsed) {
+		return fmt.Errorf("cross-check: detected %s is not a public address (CGNAT or a proxy in the way?); refusing to publish it", ip)
+	}
+	client := ipClient(network, local)
+	for _, src := range splitList(crossSources) {
+		got, err := fetchIP(ctx, client, src, network, local)
+		if err != nil {
+			return fmt.Errorf("cross-check: %s: %w; refusing to publish %s unverified", src, err, ip)
+		}
+		if got != ip {
+			return fmt.Errorf("cross-check: %s sees us as %s but detection said %s (transparent proxy or CGNAT?); refusing to publish", src, got, ip)
+		}
+	}
+	return nil
+}
+
+// fetchIP asks one IP source for our address. Sources are HTTP(S) URLs
+// answering in plain text, udp://host:port for a UDP echo, or iface:NAME for
+// the address of a local interface (useful for IPv6, which is not NATed).
+func fetchIP(ctx context.Context, client *http.Client, ipSource, network string, local net.IP) (string, error) {
+	if strings.HasPrefix(ipSource, "udp://") {
+		return fetchIPUDP(ctx, ipSource, network, local)
+	}
+	if name, ok := strings.CutPrefix(ipSource, "iface:"); ok {
+		ip, err := interfaceAddr(name, network)
+		if err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", ipSource, nil)
+	if err != nil {
+		return "", err
+	}
+	setRequestHeaders(req)
+	key := network + " " + ipSource
+	if local != nil {
+		key += " " + local.String()
+	}
+	ipValidatorsMu.Lock()
+	v, cached := ipValidators[key]
+	ipValidatorsMu.Unlock()
+	if cached {
+		if v.etag != "" {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if v.lastModified != "" {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached {
+		return v.ip, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("HTTP %d from %s", resp.StatusCode, ipSource)
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	ip, err := parseSourceIP(b, ipSource, network)
+	if err == nil {
+		v = ipValidator{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified"), ip: ip}
+		ipValidatorsMu.Lock()
+		if v.etag != "" || v.lastModified != "" {
+			ipValidators[key] = v
+		} else {
+			delete(ipValidators, key)
+		}
+		ipValidatorsMu.Unlock()
+	}
+	return ip, err
+}
+
+// ipValidators remember the last answer of HTTP IP sources that send an
+// ETag or Last-Modified header, so the next poll (in daemon mode) is a
+// conditional request the source can answer with an empty 304 Not Modified.
+var (
+	ipValidatorsMu sync.Mutex
+	ipValidators   = map[string]ipValidator{}
+)
+
+type ipValidator struct {
+	etag, lastModified string
+	ip                 string
+}
+
+// udpEchoRequest is what a UDP echo query carries. It is padded so the
+// request is never smaller than the answer, leaving nothing to amplify.
+var udpEchoRequest = []byte(fmt.Sprintf("%-64s", "do-ddns ip?"))
+
+// fetchIPUDP asks a UDP echo (`do-ddns ip-server --udp-listen`) for our
+// address. Some NATs send UDP out through a different public address than
+// TCP; cross-checking a TCP source against a UDP one catches them. The query
+// is retried a few times since datagrams can get lost.
+func fetchIPUDP(ctx context.Context, ipSource, network string, local net.IP) (string, error) {
+	u, err := url.Parse(ipSource)
+	if err != nil || u.Port() == "" {
+		return "", fmt.Errorf("invalid UDP source %q: want udp://host:port", ipSource)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if local != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: local}
+	}
+	conn, err := dialer.DialContext(ctx, strings.Replace(network, "tcp", "udp", 1), u.Host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 512)
+	for attempt := 1; ; attempt++ {
+		deadline := time.Now().Add(2 * time.Second)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetDeadline(deadline)
+		if _, err := conn.Write(udpEchoRequest); err != nil {
+			return "", err
+		}
+		n, err := conn.Read(buf)
+		if err == nil {
+			return parseSourceIP(buf[:n], ipSource, network)
+		}
+		if attempt == 3 || ctx.Err() != nil {
+			return "", fmt.Errorf("no answer from %s: %w", ipSource, err)
+		}
+	}
+}
+
+// parseSourceIP validates an IP source's answer for the given family.
+func parseSourceIP(b []byte, ipSource, network string) (string, error) {
+	ip := strings.TrimSpace(string(b))
+	parsed := net.ParseIP(ip)
+	if network == "tcp6" {
+		if parsed == nil || parsed.To4() != nil {
+			return "", fmt.Errorf("invalid IPv6 from %s: %q", ipSource, ip)
+		}
+		return parsed.String(), nil
+	}
+	if parsed == nil || parsed.To4() == nil {
+		return "", fmt.Errorf("invalid IPv4 from %s: %q", ipSource, ip)
+	}
+	return parsed.String(), nil
+}
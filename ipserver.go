@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runIPServer implements `do-ddns ip-server`: a minimal "what is my IP"
+// service answering with the caller's address, to self-host as IP_SOURCE.
+func runIPServer(args []string) error {
+	fs := flag.NewFlagSet("ip-server", flag.ExitOnError)
+	listen := fs.String("listen", envDefault("LISTEN", ":8080"), "Address to listen on; the default serves IPv4 and IPv6 (or env LISTEN)")
+	certFile := fs.String("tls-cert", os.Getenv("TLS_CERT"), "TLS certificate file; serves HTTPS together with --tls-key (or env TLS_CERT)")
+	keyFile := fs.String("tls-key", os.Getenv("TLS_KEY"), "TLS private key file (or env TLS_KEY)")
+	udpListen := fs.String("udp-listen", os.Getenv("UDP_LISTEN"), "Also answer UDP echo queries on this address, for udp://host:port sources (or env UDP_LISTEN)")
+	trust := fs.String("trust-proxy", os.Getenv("TRUST_PROXY"), "Reverse proxy addresses or CIDRs, comma-separated, whose X-Forwarded-For / X-Real-IP is believed (or env TRUST_PROXY)")
+	fs.Parse(args)
+
+	if (*certFile == "") != (*keyFile == "") {
+		return withExitCode(2, errors.New("ip-server: --tls-cert and --tls-key go together"))
+	}
+	proxies, err := parsePrefixes(*trust)
+	if err != nil {
+		return withExitCode(2, fmt.Errorf("ip-server: --trust-proxy: %w", err))
+	}
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           ipServerHandler(proxies),
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fmt.Errorf("ip-server: %w", err)
+	}
+	var pc net.PacketConn
+	if *udpListen != "" {
+		if pc, err = net.ListenPacket("udp", *udpListen); err != nil {
+			ln.Close()
+			return fmt.Errorf("ip-server: %w", err)
+		}
+	}
+
+	sup, ctx := newSupervisor(ctx)
+	sup.Go("http", func(ctx context.Context) error {
+		var err error
+		if *certFile != "" {
+			err = srv.ServeTLS(ln, *certFile, *keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	sup.Go("http shutdown", func(ctx context.Context) error {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	})
+
+	if pc != nil {
+		sup.Go("udp echo", func(ctx context.Context) error {
+			serveUDPEcho(pc)
+			return nil
+		})
+		sup.Go("udp shutdown", func(ctx context.Context) error {
+			<-ctx.Done()
+			return pc.Close()
+		})
+		logm(msgIPServerUDP, *udpListen)
+	}
+
+	scheme := "http"
+	if *certFile != "" {
+		scheme = "https"
+	}
+	logm(msgIPServerListening, scheme, *listen)
+	if err := sup.Wait(); err != nil {
+		return fmt.Errorf("ip-server: %w", err)
+	}
+	return nil
+}
+
+// ipServerHandler answers GET / with the caller's address as plain text (the
+// format IP_SOURCE expects) and GET /json, or / with Accept:
+// application/json, as {"ip": ..., "family": ...}. Addresses are never
+// logged.
+func ipServerHandler(proxies []netip.Prefix) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+	serve := func(w http.ResponseWriter, r *http.Request, asJSON bool) {
+		ip, ok := callerIP(r, proxies)
+		if !ok {
+			http.Error(w, "cannot determine your address", http.StatusBadRequest)
+			return
+		}
+		// The ETag lets pollers send If-None-Match and get an empty 304
+		// while the address stays the same.
+		etag := `"` + ip.String() + `"`
+		if asJSON {
+			etag = `"` + ip.String() + `;json"`
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Vary", "Accept")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if asJSON {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"ip": ip.String(), "family": familyOf(ip)})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, ip)
+	}
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, strings.Contains(r.Header.Get("Accept"), "application/json"))
+	})
+	mux.HandleFunc("GET /json", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, true)
+	})
+	return mux
+}
+
+// serveUDPEcho answers every datagram with its sender's address. Datagrams
+// shorter than the answer are dropped, so a spoofed query can never be
+// turned into a bigger reply.
+func serveUDPEcho(pc net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		ua, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		reply := []byte(ua.AddrPort().Addr().Unmap().String() + "\n")
+		if n < len(reply) {
+			continue
+		}
+		pc.WriteTo(reply, addr)
+	}
+}
+
+// callerIP is the request's peer address or, if the peer is a trusted proxy,
+// the last address in X-Forwarded-For (or X-Real-IP) that is not itself one
+// of the proxies.
+func callerIP(r *http.Request, proxies []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	peer = peer.Unmap()
+	if !trusted(peer, proxies) {
+		return peer, true
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" && strings.TrimSpace(hops[0]) == "" {
+		hops = []string{real}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if a = a.Unmap(); !trusted(a, proxies) {
+			return a, true
+		}
+	}
+	return peer, true
+}
+
+func trusted(a netip.Addr, proxies []netip.Prefix) bool {
+	for _, p := range proxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes reads a comma-separated list of addresses and CIDRs.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, v := range splitList(s) {
+		if !strings.Contains(v, "/") {
+			a, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func familyOf(ip netip.Addr) string {
+	if ip.Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// presetRecord is one record a mail preset maintains. For TXT records Prefix
+// selects which of possibly several TXT records at Name is ours (v=spf1,
+// v=DMARC1, ...), so unrelated verification records are left alone. MX
+// records are matched by host, so each preset host is maintained separately.
+type presetRecord struct {
+	Type     string
+	Name     string
+	Data     string
+	Priority int
+	Prefix   string
+}
+
+type mailPresetOptions struct {
+	Domain      string
+	DKIMKey     string
+	Tenant      string
+	DMARCPolicy string
+	DMARCRUA    string
+}
+
+// mailPresets build the standard MX/SPF/DKIM/DMARC set for each provider.
+var mailPresets = map[string]func(o mailPresetOptions) ([]presetRecord, error){
+	"fastmail": func(o mailPresetOptions) ([]presetRecord, error) {
+		recs := []presetRecord{
+			{Type: "MX", Name: "@", Data: "in1-smtp.messagingengine.com.", Priority: 10},
+			{Type: "MX", Name: "@", Data: "in2-smtp.messagingengine.com.", Priority: 20},
+			spfRecord("v=spf1 include:spf.messagingengine.com ?all"),
+		}
+		for _, sel := range []string{"fm1", "fm2", "fm3"} {
+			recs = append(recs, presetRecord{
+				Type: "CNAME",
+				Name: sel + "._domainkey",
+				Data: fmt.Sprintf("%s.%s.dkim.fmhosted.com.", sel, o.Domain),
+			})
+		}
+		return append(recs, dmarcRecord(o)), nil
+	},
+	"google": func(o mailPresetOptions) ([]presetRecord, error) {
+		recs := []presetRecord{
+			{Type: "MX", Name: "@", Data: "smtp.google.com.", Priority: 1},
+			spfRecord("v=spf1 include:_spf.google.com ~all"),
+		}
+		if o.DKIMKey != "" {
+			recs = append(recs, presetRecord{Type: "TXT", Name: "google._domainkey", Data: dkimValue(o.DKIMKey), Prefix: "v=DKIM1"})
+		} else {
+			warnf("%s", msg(msgMailNoDKIM))
+		}
+		return append(recs, dmarcRecord(o)), nil
+	},
+	"microsoft365": func(o mailPresetOptions) ([]presetRecord, error) {
+		if o.Tenant == "" {
+			return nil, errors.New("microsoft365 preset requires --tenant (the <tenant>.onmicrosoft.com prefix)")
+		}
+		dashed := strings.ReplaceAll(o.Domain, ".", "-")
+		recs := []presetRecord{
+			{Type: "MX", Name: "@", Data: dashed + ".mail.protection.outlook.com.", Priority: 0},
+			spfRecord("v=spf1 include:spf.protection.outlook.com -all"),
+			{Type: "CNAME", Name: "autodiscover", Data: "autodiscover.outlook.com."},
+		}
+		for _, sel := range []string{"selector1", "selector2"} {
+			recs = append(recs, presetRecord{
+				Type: "CNAME",
+				Name: sel + "._domainkey",
+				Data: fmt.Sprintf("%s-%s._domainkey.%s.onmicrosoft.com.", sel, dashed, o.Tenant),
+			})
+		}
+		return append(recs, dmarcRecord(o)), nil
+	},
+}
+
+func spfRecord(value string) presetRecord {
+	return presetRecord{Type: "TXT", Name: "@", Data: value, Prefix: "v=spf1"}
+}
+
+func dmarcRecord(o mailPresetOptions) presetRecord {
+	v := "v=DMARC1; p=" + o.DMARCPolicy + ";"
+	if o.DMARCRUA != "" {
+		v += " rua=mailto:" + strings.TrimPrefix(o.DMARCRUA, "mailto:") + ";"
+	}
+	return presetRecord{Type: "TXT", Name: "_dmarc", Data: v, Prefix: "v=DMARC1"}
+}
+
+// dkimValue accepts either a bare public key or a full "v=DKIM1; ..." value.
+func dkimValue(key string) string {
+	key = parseTXT(strings.TrimSpace(key))
+	if strings.HasPrefix(key, "v=DKIM1") {
+		return key
+	}
+	return "v=DKIM1; k=rsa; p=" + key
+}
+
+// runApplyMailPreset implements `do-ddns apply-mail-preset --provider NAME`.
+func runApplyMailPreset(args []string) error {
+	var cfg Config
+	var o mailPresetOptions
+	fs := flag.NewFlagSet("apply-mail-preset", flag.ExitOnError)
+	provider := fs.String("provider", "", "Mail provider: "+strings.Join(mailPresetNames(), ", "))
+	dryRun := fs.Bool("dry-run", false, "Only print the changes that would be made")
+	fs.StringVar(&cfg.Token, "token", os.Getenv("DO_TOKEN"), "DigitalOcean API token (or env DO_TOKEN)")
+	nameFlag(fs, &cfg.Domain, "domain", "DO_DOMAIN", "Domain (or env DO_DOMAIN)")
+	fs.IntVar(&cfg.TTL, "ttl", 3600, "TTL seconds for the mail records")
+	fs.IntVar(&cfg.PerPage, "per-page", envDefaultInt("PER_PAGE", 200), "Per-page pagination size (or env PER_PAGE)")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", envDefaultInt("MAX_RETRIES", 6), "Max retries for DO API calls (or env MAX_RETRIES)")
+	fs.StringVar(&o.DKIMKey, "dkim-key", "", "DKIM public key (google)")
+	fs.StringVar(&o.Tenant, "tenant", "", "Tenant name, as in <tenant>.onmicrosoft.com (microsoft365)")
+	fs.StringVar(&o.DMARCPolicy, "dmarc-policy", "none", "DMARC policy: none, quarantine or reject")
+	fs.StringVar(&o.DMARCRUA, "dmarc-rua", "", "Address for DMARC aggregate reports")
+	fs.Parse(args)
+
+	build, ok := mailPresets[strings.ToLower(*provider)]
+	if !ok {
+		return withExitCode(2, fmt.Errorf("unknown or missing --provider %q (want one of %s)", *provider, strings.Join(mailPresetNames(), ", ")))
+	}
+	switch o.DMARCPolicy {
+	case "none", "quarantine", "reject":
+	default:
+		return withExitCode(2, fmt.Errorf("invalid --dmarc-policy %q", o.DMARCPolicy))
+	}
+	cfg.Token = mustEnvOrFlag(cfg.Token, "DO_TOKEN / --token")
+	cfg.Domain = mustEnvOrFlag(cfg.Domain, "DO_DOMAIN / --domain")
+	o.Domain = cfg.Domain
+
+	want, err := build(o)
+	if err != nil {
+		return withExitCode(2, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var names []string
+	for _, p := range want {
+		names = append(names, p.Name)
+	}
+	slices.Sort(names)
+	recs, err := listRecords(ctx, cfg, slices.Compact(names)...)
+	if err != nil {
+		return withExitCode(4, fmt.Errorf("listing records: %w", err))
+	}
+	return applyPreset(ctx, cfg, recs, want, *dryRun)
+}
+
+func mailPresetNames() []string {
+	var names []string
+	for n := range mailPresets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset creates missing preset records and updates drifted ones.
+// Records not described by the preset are never touched.
+func applyPreset(ctx context.Context, cfg Config, existing []DomainRecord, want []presetRecord, dryRun bool) error {
+	var errs []string
+	for _, p := range want {
+		fqdn := p.Name + "." + cfg.Domain
+		cur, found := findPresetRecord(existing, p)
+
+		switch {
+		case !found:
+			logm(msgMailCreate, dryRunPrefix(dryRun), p.Type, fqdn, p.Data)
+			if dryRun {
+				continue
+			}
+			if err := createPresetRecord(ctx, cfg, p); err != nil {
+				errs = append(errs, fmt.Sprintf("create %s %s: %v", p.Type, fqdn, err))
+			}
+		case !sameData(p.Type, cur.Data, p.Data) || (p.Type == "MX" && cur.Priority != p.Priority):
+			logm(msgMailUpdate, dryRunPrefix(dryRun), p.Type, fqdn, cur.ID, cur.Data, p.Data)
+			if dryRun {
+				continue
+			}
+			if err := updatePresetRecord(ctx, cfg, cur.ID, p); err != nil {
+				errs = append(errs, fmt.Sprintf("update %s %s id=%d: %v", p.Type, fqdn, cur.ID, err))
+			}
+		default:
+			logm(msgMailOK, p.Type, fqdn, p.Data)
+		}
+	}
+	if len(errs) > 0 {
+		return withExitCode(6, errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+func dryRunPrefix(dryRun bool) string {
+	if dryRun {
+		return "[dry-run] "
+	}
+	return ""
+}
+
+// findPresetRecord returns the lowest-ID existing record that p maintains.
+func findPresetRecord(existing []DomainRecord, p presetRecord) (DomainRecord, bool) {
+	var matches []DomainRecord
+	for _, r := range existing {
+		if r.Type != p.Type || r.Name != p.Name {
+			continue
+		}
+		switch {
+		case p.Type == "MX" && !sameData("MX", r.Data, p.Data):
+			continue
+		case p.Prefix != "" && !strings.HasPrefix(parseTXT(r.Data), p.Prefix):
+			continue
+		}
+		matches = append(matches, r)
+	}
+	if len(matches) == 0 {
+		return DomainRecord{}, false
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches[0], true
+}
+
+func presetPayload(cfg Config, p presetRecord) map[string]any {
+	payload := map[string]any{
+		"data": recordData(p.Type, p.Data),
+		"ttl":  cfg.TTL,
+	}
+	if p.Type == "MX" {
+		payload["priority"] = p.Priority
+	}
+	return payload
+}
+
+func createPresetRecord(ctx context.Context, cfg Config, p presetRecord) error {
+	payload := presetPayload(cfg, p)
+	payload["type"] = p.Type
+	payload["name"] = p.Name
+	b, _ := json.Marshal(payload)
+	_, _, _, err := doRequest(ctx, cfg, "POST", fmt.Sprintf("%s/domains/%s/records", apiBase, cfg.Domain), b)
+	return err
+}
+
+func updatePresetRecord(ctx context.Context, cfg Config, id int64, p presetRecord) error {
+	b, _ := json.Marshal(presetPayload(cfg, p))
+	_, _, _, err := doRequest(ctx, cfg, "PUT", fmt.Sprintf("%s/domains/%s/records/%d", apiBase, cfg.Domain, id), b)
+	return err
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// maintenanceEntry remembers what a record pointed at before maintenance
+// mode redirected it, so `maintenance off` can put it back. While an entry
+// exists the record is not reconciled.
+type maintenanceEntry struct {
+	Domain      string    `json:"domain"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	ID          int64     `json:"id"`
+	Original    string    `json:"original,omitempty"`
+	OriginalTTL int       `json:"original_ttl,omitempty"`
+	Target      string    `json:"target"`
+	Created     bool      `json:"created,omitempty"` // record did not exist before
+	Since       time.Time `json:"since"`
+}
+
+func (m maintenanceEntry) key() string {
+	return recordKey(m.Domain, m.Name, m.Type)
+}
+
+// inMaintenance returns the maintenance entry for key, if any.
+func inMaintenance(cfg Config, key string) (maintenanceEntry, bool) {
+	db, err := viewStateDB(cfg.StateDir)
+	if err != nil {
+		warnf("%s", msg(msgStateReadFailed, err))
+		return maintenanceEntry{}, false
+	}
+	for _, m := range db.Maintenance {
+		if m.key() == key {
+			return m, true
+		}
+	}
+	return maintenanceEntry{}, false
+}
+
+// runMaintenance implements `do-ddns maintenance on|off|status`.
+func runMaintenance(args []string) error {
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off" && args[0] != "status") {
+		return withExitCode(2, errors.New("usage: do-ddns maintenance on|off|status [flags]"))
+	}
+	mode := args[0]
+
+	fs := flag.NewFlagSet("maintenance "+mode, flag.ExitOnError)
+	cfg := recordFlags(fs)
+	target := fs.String("target", os.Getenv("MAINTENANCE_TARGET"), "Maintenance host the record points at while on (or env MAINTENANCE_TARGET)")
+	fs.Parse(args[1:])
+
+	if mode == "status" {
+		db, err := viewStateDB(cfg.StateDir)
+		if err != nil {
+			return err
+		}
+		if len(db.Maintenance) == 0 {
+			fmt.Println("No records in maintenance.")
+		}
+		for _, m := range db.Maintenance {
+			fmt.Printf("%s %s.%s -> %s since %s (original %s)\n",
+				m.Type, m.Name, m.Domain, m.Target, m.Since.Format(time.RFC3339), orNone(m.Original))
+		}
+		return nil
+	}
+
+	requireRecord(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+	defer cancel()
+
+	if mode == "on" {
+		if *target == "" {
+			return withExitCode(2, errors.New("--target is required"))
+		}
+		if err := validateTarget(cfg.Type, *target); err != nil {
+			return withExitCode(2, err)
+		}
+		return maintenanceOn(ctx, *cfg, *target)
+	}
+	return maintenanceOff(ctx, *cfg)
+}
+
+func validateTarget(recordType, target string) error {
+	if !isAddressType(recordType) {
+		return nil
+	}
+	ip := net.ParseIP(target)
+	if ip == nil || (recordType == "A") != (ip.To4() != nil) {
+		return fmt.Errorf("target %q is not a valid %s record address", target, recordType)
+	}
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func maintenanceOn(ctx context.Context, cfg Config, target string) error {
+	key := recordKey(cfg.Domain, cfg.Name, cfg.Type)
+	if m, ok := inMaintenance(cfg, key); ok {
+		if m.Target == target {
+			logm(msgMaintAlready, cfg.Type, cfg.Name, cfg.Domain, target)
+			return nil
+		}
+		// Switching maintenance hosts: keep the original value we saved.
+		c := cfg
+		if m.OriginalTTL > 0 {
+			c.TTL = m.OriginalTTL
+		}
+		if err := updateRecord(ctx, c, m.ID, recordData(cfg.Type, target)); err != nil {
+			return withExitCode(6, fmt.Errorf("update record id=%d: %w", m.ID, err))
+		}
+		m.Target = target
+		return saveMaintenance(cfg, m)
+	}
+
+	recs, err := listRecords(ctx, cfg, cfg.Name)
+	if err != nil {
+		return withExitCode(4, fmt.Errorf("listing records: %w", err))
+	}
+	m := maintenanceEntry{Domain: cfg.Domain, Name: cfg.Name, Type: cfg.Type, Target: target, Since: time.Now()}
+
+	matches := matchingRecords(recs, cfg.Type, cfg.Name)
+	if len(matches) == 0 {
+		created, err := createRecord(ctx, cfg, recordData(cfg.Type, target))
+		if err != nil {
+			return withExitCode(5, fmt.Errorf("create record: %w", err))
+		}
+		m.ID, m.Created = created.ID, true
+		logm(msgMaintCreated, cfg.Type, cfg.Name, cfg.Domain, target)
+		return saveMaintenance(cfg, m)
+	}
+
+	chosen := matches[0]
+	m.ID, m.Original, m.OriginalTTL = chosen.ID, chosen.Data, chosen.TTL
+	// Save the original value first: if the update then fails we only have
+	// to forget the entry, but losing the original would be unrecoverable.
+	if err := saveMaintenance(cfg, m); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	c := cfg
+	c.TTL = chosen.TTL
+	if err := updateRecord(ctx, c, chosen.ID, recordData(cfg.Type, target)); err != nil {
+		if derr := deleteMaintenance(cfg, key); derr != nil {
+			warnf("%s", msg(msgStateWriteFailed, derr))
+		}
+		return withExitCode(6, fmt.Errorf("update record id=%d: %w", chosen.ID, err))
+	}
+	logm(msgMaintOn, cfg.Type, cfg.Name, cfg.Domain, target, chosen.Data)
+	return nil
+}
+
+func maintenanceOff(ctx context.Context, cfg Config) error {
+	key := recordKey(cfg.Domain, cfg.Name, cfg.Type)
+	m, ok := inMaintenance(cfg, key)
+	if !ok {
+		return withExitCode(2, fmt.Errorf("%s %s.%s is not in maintenance", cfg.Type, cfg.Name, cfg.Domain))
+	}
+
+	if m.Created {
+		if err := deleteRecord(ctx, cfg, m.ID); err != nil && !isNotFound(err) {
+			return withExitCode(6, fmt.Errorf("delete record id=%d: %w", m.ID, err))
+		}
+		logm(msgMaintDeleted, cfg.Type, cfg.Name, cfg.Domain)
+	} else {
+		c := cfg
+		c.TTL = m.OriginalTTL
+		if err := updateRecord(ctx, c, m.ID, m.Original); err != nil {
+			return withExitCode(6, fmt.Errorf("restore record id=%d: %w", m.ID, err))
+		}
+		logm(msgMaintRestored, cfg.Type, cfg.Name, cfg.Domain, m.Original)
+	}
+	return deleteMaintenance(cfg, key)
+}
+
+func saveMaintenance(cfg Config, m maintenanceEntry) error {
+	return updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		for i := range db.Maintenance {
+			if db.Maintenance[i].key() == m.key() {
+				db.Maintenance[i] = m
+				return true
+			}
+		}
+		db.Maintenance = append(db.Maintenance, m)
+		return true
+	})
+}
+
+func deleteMaintenance(cfg Config, key string) error {
+	return updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		kept := db.Maintenance[:0]
+		for _, m := range db.Maintenance {
+			if m.key() != key {
+				kept = append(kept, m)
+			}
+		}
+		changed := len(kept) != len(db.Maintenance)
+		db.Maintenance = kept
+		return changed
+	})
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Message IDs for the user-facing log output. The English
+// wording below can be replaced by a catalog file (--messages / DO_MESSAGES)
+// so appliance builds can ship their own language or phrasing. Severity
+// prefixes (WARN:, ERROR:) stay untranslated so logs remain greppable.
+const (
+	msgAPITransient       = "api.transient"
+	msgAPIRateLimited     = "api.rate_limited"
+	msgAPIServerError     = "api.server_error"
+	msgAPIMaintenance     = "api.maintenance"
+	msgAPIMaintenanceOver = "api.maintenance_over"
+	msgNotifyHeld         = "notify.held"
+	msgIPDetected         = "ip.detected"
+	msgIPDetectedVia      = "ip.detected_via"
+	msgIPSettling         = "ip.settling"
+	msgIPSettled          = "ip.settled"
+	msgIPUnsettled        = "ip.unsettled"
+	msgRecordMaint        = "record.maintenance"
+	msgRecordPaused       = "record.paused"
+	msgRecordOffNetwork   = "record.off_network"
+	msgRecordExpired      = "record.expired"
+	msgRecordCached       = "record.unchanged_cached"
+	msgRecordCreating     = "record.creating"
+	msgRecordCreated      = "record.created"
+	msgRecordFound        = "record.found"
+	msgRecordSameIP       = "record.unchanged_ip"
+	msgRecordSameValue    = "record.unchanged_value"
+	msgRecordUpdated      = "record.updated"
+	msgRecordAborted      = "record.aborted"
+	msgRecordBlocked      = "record.blocked"
+	msgRecordCooldown     = "record.cooldown"
+	msgRollbackStart      = "bootstrap.rollback"
+	msgRollbackDeleted    = "bootstrap.deleted"
+	msgRollbackRestored   = "bootstrap.restored"
+	msgRollbackFailed     = "bootstrap.rollback_failed"
+	msgGroupDegraded      = "group.degraded"
+	msgGroupRollback      = "group.rollback"
+	msgGroupComplete      = "group.complete"
+	msgRecordConflict     = "record.conflict"
+	msgRecordIDN          = "record.idn"
+	msgChangeDeferred     = "change.deferred"
+	msgSetRemoved         = "record.removed"
+	msgCleanupStart       = "cleanup.start"
+	msgCleanupDeleted     = "cleanup.deleted"
+	msgCleanupFailed      = "cleanup.failed"
+	msgStateWriteFailed   = "state.write_failed"
+	msgStateReadFailed    = "state.read_failed"
+	msgExpiryDeleted      = "expiry.deleted"
+	msgExpiryScheduled    = "expiry.scheduled"
+	msgNetworkCurrent     = "network.current"
+	msgDaemonStarted      = "daemon.started"
+	msgDaemonListening    = "daemon.listening"
+	msgDaemonStopping     = "daemon.stopping"
+	msgDaemonSeeded       = "daemon.seeded"
+	msgRotationTargetOff  = "rotate.target_down"
+	msgUplinkDown         = "failover.uplink_down"
+	msgUplinkUsed         = "failover.uplink_used"
+	msgExplainMaint       = "explain.maintenance"
+	msgExplainPaused      = "explain.paused"
+	msgExplainOffNetwork  = "explain.off_network"
+	msgExplainExpired     = "explain.expired"
+	msgExplainSource      = "explain.source"
+	msgExplainCached      = "explain.cached"
+	msgExplainMissing     = "explain.missing"
+	msgExplainCanonical   = "explain.canonical"
+	msgExplainSame        = "explain.same"
+	msgExplainDiffers     = "explain.differs"
+	msgExplainTTL         = "explain.ttl"
+	msgExplainDuplicates  = "explain.duplicates"
+	msgExplainSet         = "explain.set"
+	msgExplainBlocked     = "explain.blocked"
+	msgExplainRolledBack  = "explain.rolled_back"
+	msgExplainCooldown    = "explain.cooldown"
+	msgExplainWhy         = "explain.why"
+
+	// Warnings and subcommands outside the update path.
+	msgChaosEnabled         = "chaos.enabled"
+	msgChaosFault           = "chaos.fault"
+	msgStatusShutdown       = "daemon.status_shutdown_failed"
+	msgFlagRequired         = "flag.required"
+	msgFlagReplayRecord     = "flag.replay_record"
+	msgFlagInterval         = "flag.interval"
+	msgSummaryWriteFailed   = "summary.write_failed"
+	msgExpiryDeleteFailed   = "expiry.delete_failed"
+	msgIPSourceFailed       = "ip.source_failed"
+	msgIPSourcesDisagree    = "ip.sources_disagree"
+	msgIPServerUDP          = "ipserver.udp"
+	msgIPServerListening    = "ipserver.listening"
+	msgMailNoDKIM           = "mail.no_dkim"
+	msgMailCreate           = "mail.create"
+	msgMailUpdate           = "mail.update"
+	msgMailOK               = "mail.ok"
+	msgMaintAlready         = "maintenance.already"
+	msgMaintCreated         = "maintenance.created"
+	msgMaintOn              = "maintenance.on"
+	msgMaintDeleted         = "maintenance.deleted"
+	msgMaintRestored        = "maintenance.restored"
+	msgMigrateWrote         = "migrate.wrote"
+	msgInventoryReadFailed  = "inventory.read_failed"
+	msgNoteRemoved          = "note.removed"
+	msgNoteSaved            = "note.saved"
+	msgNotifyFailed         = "notify.failed"
+	msgResumed              = "pause.resumed"
+	msgPaused               = "pause.paused"
+	msgPlanWrote            = "plan.wrote"
+	msgPlanApplying         = "plan.applying"
+	msgRecordingFailed      = "replay.record_failed"
+	msgReplayStart          = "replay.start"
+	msgReplayNoFixture      = "replay.no_fixture"
+	msgReplaySimulated      = "replay.simulated"
+	msgPanicked             = "supervise.panicked"
+	msgSupportWrote         = "support.wrote"
+	msgSwitchRollback       = "switch.rollback"
+	msgSwitchVerified       = "switch.verified"
+	msgSwitchDone           = "switch.done"
+	msgSwitchAlready        = "switch.already"
+	msgSwitchDryRun         = "switch.dry_run"
+	msgSwitched             = "switch.switched"
+	msgSwitchRollbackFailed = "switch.rollback_failed"
+	msgSwitchRolledBack     = "switch.rolled_back"
+)
+
+// defaultMessages is the built-in English catalog. Values are fmt formats;
+// a replacement may reorder arguments with explicit indexes (%[2]s).
+var defaultMessages = map[string]string{
+	msgAPITransient:       "Transient error: %v (attempt %d/%d), backoff %s",
+	msgAPIRateLimited:     "Rate limited (429). Waiting %s then retrying (attempt %d/%d)...",
+	msgAPIServerError:     "Server error (HTTP %d). Waiting %s then retrying (attempt %d/%d)...",
+	msgAPIMaintenance:     "DigitalOcean API maintenance (HTTP 503: %s). Waiting %s then retrying (attempt %d/%d)...",
+	msgAPIMaintenanceOver: "DigitalOcean API is back after maintenance (first seen %s ago).",
+	msgNotifyHeld:         "Not notifying about %d failure(s) during DigitalOcean API maintenance.",
+	msgIPDetected:         "Public IP detected (%s): %s",
+	msgIPDetectedVia:      "Public IP detected (%s via %s): %s",
+	msgIPSettling:         "New public IP (%s) %s; waiting %s for it to settle before publishing...",
+	msgIPSettled:          "Public IP (%s) %s is stable.",
+	msgIPUnsettled:        "Public IP (%s) changed from %s to %s while settling.",
+	msgRecordMaint:        "%s %s.%s is in maintenance (-> %s since %s). Skipping.",
+	msgRecordPaused:       "%s %s.%s is paused (since %s%s). Skipping until do-ddns resume.",
+	msgRecordOffNetwork:   "%s %s.%s is only updated on network %s (current: %s). Skipping.",
+	msgRecordExpired:      "%s %s.%s expired at %s and was deleted; not recreating it (run without --expires-in to manage it again).",
+	msgRecordCached:       "IP unchanged since last run (%s). Skipping DigitalOcean API calls for %s %s.%s.",
+	msgRecordCreating:     "No existing %s record found for %s.%s. Creating it.",
+	msgRecordCreated:      "Created %s.%s -> %s (ttl=%d)",
+	msgRecordFound:        "Found %d existing %s record(s) for %s.%s. Using id=%d (current=%s).",
+	msgRecordSameIP:       "No update needed (IP unchanged in DigitalOcean).",
+	msgRecordSameValue:    "No update needed (value unchanged in DigitalOcean).",
+	msgRecordUpdated:      "Updated %s.%s -> %s (ttl=%d)",
+	msgRecordAborted:      "Not reconciling %s %s.%s: an earlier record failed (--fail-fast).",
+	msgRecordBlocked:      "Not reconciling %s %s: it depends on %s, which did not succeed.",
+	msgRecordCooldown:     "Not reconciling %s %s.%s: DigitalOcean API maintenance, not calling it again before %s.",
+	msgRollbackStart:      "Bootstrap of %s failed: deleting the records created for it in this run...",
+	msgRollbackDeleted:    "Deleted %s %s id=%d (created in this run)",
+	msgRollbackRestored:   "Restored %s %s id=%d to %s",
+	msgRollbackFailed:     "rollback could not undo the change to %s %s id=%d: %v",
+	msgGroupDegraded:      "Group %s is degraded: %s did not succeed; keeping the other records and retrying next run.",
+	msgGroupRollback:      "Group %s failed (%s): undoing this run's changes to its records...",
+	msgGroupComplete:      "Group %s is complete again.",
+	msgRecordIDN:          "%s is managed as %s (its ASCII form) in DigitalOcean and the state files.",
+	msgRecordConflict:     "%s %s.%s id=%d was changed by something else since it was listed (%s -> %s).",
+	msgChangeDeferred:     "Deferring %s for %s %s.%s until the change window (%s).",
+	msgSetRemoved:         "Removed %s %s.%s id=%d (%s is no longer selected)",
+	msgCleanupStart:       "Cleanup enabled: deleting %d duplicate record(s)...",
+	msgCleanupDeleted:     "Deleted duplicate record id=%d (data=%s)",
+	msgCleanupFailed:      "cleanup duplicates failed: %v",
+	msgStateWriteFailed:   "failed writing state: %v",
+	msgStateReadFailed:    "failed reading state: %v",
+	msgExpiryDeleted:      "Deleted expired record %s %s.%s id=%d (data=%s, expired %s)",
+	msgExpiryScheduled:    "%s %s.%s will be deleted after %s",
+	msgNetworkCurrent:     "Current network profile: %s (%s)",
+	msgDaemonStarted:      "Daemon started: checking %d record(s) every %s",
+	msgDaemonListening:    "Serving /healthz and /status on %s",
+	msgDaemonStopping:     "Shutting down...",
+	msgDaemonSeeded:       "Last published values of %d record(s) read from the state; unchanged records are skipped",
+	msgRotationTargetOff:  "rotation target %s is down, skipping it: %v",
+	msgUplinkDown:         "uplink %s is down (%s): %v",
+	msgUplinkUsed:         "Using uplink %s for %s %s.%s: %s",
+	msgExplainMaint:       "it is in maintenance mode (do-ddns maintenance on), pointing at %s; only maintenance off changes it",
+	msgExplainPaused:      "it is paused (do-ddns pause); paused records are never sent to DigitalOcean",
+	msgExplainOffNetwork:  "its networks (%s) do not include the current one (%s)",
+	msgExplainExpired:     "it was a temporary record that already expired; expired records are not recreated",
+	msgExplainSource:      "the desired value %s comes from %s",
+	msgExplainCached:      "the daemon already published %s, so DigitalOcean is not queried",
+	msgExplainMissing:     "DigitalOcean has no %s record named %q, so one is created",
+	msgExplainCanonical:   "%d records match; id=%d is canonical because it has the lowest ID",
+	msgExplainSame:        "id=%d holds %q, equal to the desired %q (compared as %s), so no update is needed",
+	msgExplainDiffers:     "id=%d holds %q, not the desired %q (compared as %s), so it is updated",
+	msgExplainTTL:         "the TTL is %d instead of %d, so the TTL is corrected",
+	msgExplainDuplicates:  "%d duplicate(s) are left in place because cleanup_duplicates is off",
+	msgExplainSet:         "%d of the %d wanted addresses are published; %d record(s) are stale or duplicates",
+	msgExplainBlocked:     "it depends on %s, so it waits for that record and is skipped when it fails",
+	msgExplainRolledBack:  "this run's change to it was undone because another record of %s failed",
+	msgExplainCooldown:    "a request gave up during DigitalOcean API maintenance, so the API is not called again before %s",
+	msgExplainWhy:         "why: %s/%s: %s",
+
+	msgChaosEnabled:         "chaos mode: injecting faults (%s)",
+	msgChaosFault:           "[chaos] %s on %s %s",
+	msgStatusShutdown:       "status endpoint shutdown: %v",
+	msgFlagRequired:         "%s is required",
+	msgFlagReplayRecord:     "--replay and --record cannot be combined",
+	msgFlagInterval:         "--interval must be positive",
+	msgSummaryWriteFailed:   "writing summary: %v",
+	msgExpiryDeleteFailed:   "deleting expired record %s %s.%s id=%d: %v",
+	msgIPSourceFailed:       "IP source %s failed: %v",
+	msgIPSourcesDisagree:    "IP sources disagree (%v); using %s",
+	msgIPServerUDP:          "Answering UDP echo queries on %s",
+	msgIPServerListening:    "Serving the caller's IP on %s://%s (plain text at /, JSON at /json)",
+	msgMailNoDKIM:           "no --dkim-key given; skipping the google._domainkey record (generate it in the Admin console)",
+	msgMailCreate:           "%sCreate %s %s -> %s",
+	msgMailUpdate:           "%sUpdate %s %s id=%d: %s -> %s",
+	msgMailOK:               "OK %s %s -> %s",
+	msgMaintAlready:         "%s %s.%s is already in maintenance (-> %s).",
+	msgMaintCreated:         "Maintenance on: created %s %s.%s -> %s",
+	msgMaintOn:              "Maintenance on: %s %s.%s -> %s (was %s). Automatic updates paused.",
+	msgMaintDeleted:         "Maintenance off: deleted %s %s.%s (it did not exist before).",
+	msgMaintRestored:        "Maintenance off: restored %s %s.%s -> %s. Automatic updates resumed.",
+	msgMigrateWrote:         "Wrote %s (%s)",
+	msgInventoryReadFailed:  "reading inventory: %v",
+	msgNoteRemoved:          "Removed the note for %s.",
+	msgNoteSaved:            "Saved the note for %s in %s.",
+	msgNotifyFailed:         "notification to %s failed: %v",
+	msgResumed:              "Resumed %s.%s; it is managed again from the next run.",
+	msgPaused:               "Paused %s. Runs skip it until `do-ddns resume --domain %s --name %s`.",
+	msgPlanWrote:            "Wrote plan for %d record(s) to %s (valid until %s)",
+	msgPlanApplying:         "Applying plan %s from %s (%d record(s))",
+	msgRecordingFailed:      "recording %s: %v",
+	msgReplayStart:          "Replaying %d fixture(s) from %s; no network requests will be made.",
+	msgReplayNoFixture:      "replay: no fixture for GET %s",
+	msgReplaySimulated:      "[replay] %s %s: no fixture, simulating success",
+	msgPanicked:             "%s panicked: %v\n%s",
+	msgSupportWrote:         "Wrote %s (%d files). Review it before attaching it to an issue.",
+	msgSwitchRollback:       "Rolling back %d switched record(s)...",
+	msgSwitchVerified:       "Verified %s %s -> %s",
+	msgSwitchDone:           "Switched %d record(s) to %q.",
+	msgSwitchAlready:        "%s %s already points at %q (%s).",
+	msgSwitchDryRun:         "[dry-run] Switch %s %s: %s -> %s (%s)",
+	msgSwitched:             "Switched %s %s -> %s (%s, was %s)",
+	msgSwitchRollbackFailed: "rollback of %s %s id=%d failed: %v",
+	msgSwitchRolledBack:     "Rolled back %s %s -> %s",
+}
+
+// messages is the active catalog: defaultMessages plus any overrides.
+var messages = maps.Clone(defaultMessages)
+
+// msg formats the catalog message id.
+func msg(id string, args ...any) string {
+	format, ok := messages[id]
+	if !ok {
+		return id
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// logm logs the catalog message id.
+func logm(id string, args ...any) {
+	logf("%s", msg(id, args...))
+}
+
+// loadMessages applies the overrides in a YAML or JSON file mapping message
+// IDs to formats. Unknown IDs, and formats referring to more arguments than
+// the message has, are rejected so a bad catalog cannot garble the logs.
+func loadMessages(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	v, err := parseYAML(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: expected a map of message IDs to texts", path)
+	}
+	var errs []error
+	for id, raw := range m {
+		def, known := defaultMessages[id]
+		text, isString := raw.(string)
+		switch {
+		case !known:
+			errs = append(errs, fmt.Errorf("unknown message ID %q", id))
+		case !isString:
+			errs = append(errs, fmt.Errorf("%s: expected a string", id))
+		case formatArgs(text) > formatArgs(def):
+			errs = append(errs, fmt.Errorf("%s: %q uses more than the %d argument(s) available", id, text, formatArgs(def)))
+		default:
+			messages[id] = text
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+var formatVerb = regexp.MustCompile(`%(\[(\d+)\])?[-+# 0]*[0-9]*(\.[0-9]+)?([a-zA-Z%])`)
+
+// formatArgs returns the highest argument position a format refers to.
+func formatArgs(format string) int {
+	n, high := 0, 0
+	for _, m := range formatVerb.FindAllStringSubmatch(format, -1) {
+		if m[4] == "%" {
+			continue
+		}
+		if m[2] != "" {
+			n, _ = strconv.Atoi(m[2])
+		} else {
+			n++
+		}
+		high = max(high, n)
+	}
+	return high
+}
+
+// writeMessages prints the active catalog as YAML, a starting point for
+// a translation.
+func writeMessages() {
+	for _, id := range slices.Sorted(maps.Keys(messages)) {
+		fmt.Printf("%s: %s\n", id, strconv.Quote(messages[id]))
+	}
+}
+
+// messagesFromEnv loads DO_MESSAGES, for subcommands and before flags are
+// parsed.
+func messagesFromEnv() error {
+	if p := strings.TrimSpace(os.Getenv("DO_MESSAGES")); p != "" {
+		return loadMessages(p)
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migratedRecord is one host found in a foreign DDNS client config.
+type migratedRecord struct {
+	Host     string
+	Domain   string
+	Name     string
+	Type     string
+	Token    string
+	TTL      int
+	IPSource string
+	Interval time.Duration
+	Origin   string // e.g. "ddclient protocol=dyndns2"
+	Guessed  bool   // domain/name split was guessed
+}
+
+// runMigrate implements `do-ddns migrate --from FILE`.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "ddclient.conf or inadyn.conf to migrate from")
+	format := fs.String("format", "auto", "Input format: auto, ddclient or inadyn")
+	domain := fs.String("domain", "", "DigitalOcean domain the hosts belong to (guessed from the hostname if unset)")
+	outDir := fs.String("out-dir", "", "Write one <host>.env file per record into this directory instead of stdout")
+	fs.Parse(args)
+
+	if *from == "" {
+		return errors.New("migrate: --from is required")
+	}
+	b, err := os.ReadFile(*from)
+	if err != nil {
+		return err
+	}
+
+	f := *format
+	if f == "auto" {
+		f = detectMigrateFormat(*from, string(b))
+	}
+
+	var recs []migratedRecord
+	switch f {
+	case "ddclient":
+		recs, err = parseDDClient(string(b))
+	case "inadyn":
+		recs, err = parseInadyn(string(b))
+	default:
+		return fmt.Errorf("migrate: unknown format %q", f)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: parsing %s: %w", *from, err)
+	}
+	if len(recs) == 0 {
+		return fmt.Errorf("migrate: no hosts found in %s", *from)
+	}
+
+	for i := range recs {
+		splitHost(&recs[i], *domain)
+	}
+
+	if *outDir == "" {
+		for i, r := range recs {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# --- %s ---\n", envFileName(r))
+			writeEnvFile(os.Stdout, r)
+		}
+		return nil
+	}
+
+	// Check every file name first, so a clash does not leave the migration
+	// half written.
+	paths := make([]string, len(recs))
+	hosts := map[string]string{}
+	for i, r := range recs {
+		name := envFileName(r)
+		if host, dup := hosts[name]; dup {
+			return fmt.Errorf("migrate: %s and %s would both be written to %s", host, r.Host, name)
+		}
+		hosts[name] = r.Host
+		paths[i] = filepath.Join(*outDir, name)
+		if _, err := os.Lstat(paths[i]); err == nil {
+			return fmt.Errorf("migrate: %s already exists", paths[i])
+		}
+	}
+
+	for i, r := range recs {
+		path := paths[i]
+		// O_EXCL: never clobber an existing env file.
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		writeEnvFile(f, r)
+		if err := f.Close(); err != nil {
+			return err
+		}
+		logm(msgMigrateWrote, path, r.Host)
+	}
+	return nil
+}
+
+func detectMigrateFormat(path, content string) string {
+	base := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.Contains(base, "inadyn"):
+		return "inadyn"
+	case strings.Contains(base, "ddclient"):
+		return "ddclient"
+	case strings.Contains(content, "{"):
+		return "inadyn"
+	default:
+		return "ddclient"
+	}
+}
+
+// splitHost fills Domain/Name from Host. An explicit domain wins, then the
+// zone from the source config, then the last two labels of the host.
+func splitHost(r *migratedRecord, domain string) {
+	host := strings.TrimSuffix(strings.ToLower(r.Host), ".")
+	if domain != "" {
+		r.Domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	}
+	if r.Domain != "" && host != r.Domain && !strings.HasSuffix(host, "."+r.Domain) {
+		// Zone inherited from an unrelated section; fall back to guessing.
+		r.Domain = ""
+	}
+	if r.Domain == "" {
+		labels := strings.Split(host, ".")
+		if len(labels) <= 2 {
+			r.Domain = host
+		} else {
+			r.Domain = strings.Join(labels[len(labels)-2:], ".")
+		}
+		r.Guessed = true
+	}
+	if host == r.Domain {
+		r.Name = "@"
+	} else {
+		r.Name = strings.TrimSuffix(host, "."+r.Domain)
+	}
+}
+
+// envFileName names a record's env file after its full hostname, so hosts of
+// different domains (www.a.com, www.b.com) and the IPv6 record of a
+// dual-stack host get files of their own.
+func envFileName(r migratedRecord) string {
+	host := r.Domain
+	if r.Name != "@" {
+		host = strings.ReplaceAll(r.Name, "*", "wildcard") + "." + r.Domain
+	}
+	if r.Type == "AAAA" {
+		host += "-v6"
+	}
+	return host + ".env"
+}
+
+func writeEnvFile(w io.Writer, r migratedRecord) {
+	fmt.Fprintf(w, "# Migrated from %s (host %s)\n", r.Origin, r.Host)
+	if r.Guessed {
+		fmt.Fprintf(w, "# NOTE: domain/name split was guessed, check DO_DOMAIN and DO_NAME\n")
+	}
+	if r.Token != "" {
+		fmt.Fprintf(w, "DO_TOKEN=%s\n", r.Token)
+	} else {
+		fmt.Fprintf(w, "DO_TOKEN=YOUR_DIGITALOCEAN_API_TOKEN\n")
+	}
+	fmt.Fprintf(w, "DO_DOMAIN=%s\n", r.Domain)
+	fmt.Fprintf(w, "DO_NAME=%s\n", r.Name)
+	if r.Type != "" && r.Type != "A" {
+		fmt.Fprintf(w, "DO_TYPE=%s\n", r.Type)
+	}
+	if r.TTL > 0 {
+		fmt.Fprintf(w, "DO_TTL=%d\n", r.TTL)
+	}
+	if r.IPSource != "" {
+		fmt.Fprintf(w, "IP_SOURCE=%s\n", r.IPSource)
+	}
+	if r.Interval > 0 {
+		fmt.Fprintf(w, "# Polling interval of the old client; use with DAEMON=true or as the timer period\n")
+		fmt.Fprintf(w, "#INTERVAL=%s\n", r.Interval)
+	}
+}
+
+// ddclient builtin web IP sources we know the URL of.
+var ddclientWebSources = map[string]string{
+	"ipify-ipv4":    "https://api.ipify.org",
+	"ipify-ipv6":    "https://api6.ipify.org",
+	"googledomains": "https://domains.google.com/checkip",
+}
+
+var ddclientAssign = regexp.MustCompile(`\s*=\s*`)
+
+// parseDDClient parses ddclient.conf. Option-only lines set globals; a line
+// ending in one or more hostnames defines those hosts with the globals plus
+// the options on that line.
+func parseDDClient(content string) ([]migratedRecord, error) {
+	globals := map[string]string{}
+	var recs []migratedRecord
+
+	sc := bufio.NewScanner(strings.NewReader(content))
+	var pending string
+	for sc.Scan() {
+		line := stripComment(sc.Text())
+		if strings.HasSuffix(strings.TrimSpace(line), `\`) {
+			pending += strings.TrimSuffix(strings.TrimSpace(line), `\`) + " "
+			continue
+		}
+		line = strings.TrimSpace(pending + line)
+		pending = ""
+		if line == "" {
+			continue
+		}
+
+		line = ddclientAssign.ReplaceAllString(line, "=")
+		opts := map[string]string{}
+		var hosts []string
+		for _, tok := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			if k, v, ok := strings.Cut(tok, "="); ok {
+				opts[strings.ToLower(k)] = unquote(v)
+				continue
+			}
+			hosts = append(hosts, unquote(tok))
+		}
+
+		if len(hosts) == 0 {
+			for k, v := range opts {
+				globals[k] = v
+			}
+			continue
+		}
+		for _, h := range hosts {
+			eff := map[string]string{}
+			for k, v := range globals {
+				eff[k] = v
+			}
+			for k, v := range opts {
+				eff[k] = v
+			}
+			recs = append(recs, ddclientRecord(h, eff))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+func ddclientRecord(host string, o map[string]string) migratedRecord {
+	r := migratedRecord{
+		Host:   host,
+		Type:   "A",
+		Domain: o["zone"],
+		Origin: "ddclient protocol=" + o["protocol"],
+	}
+	if o["protocol"] == "digitalocean" {
+		r.Token = o["password"]
+	}
+	if _, v6 := o["usev6"]; v6 {
+		if _, v4 := o["usev4"]; !v4 {
+			r.Type = "AAAA"
+		}
+	}
+	if n, err := strconv.Atoi(o["ttl"]); err == nil && n > 0 {
+		r.TTL = n
+	}
+	if n, err := strconv.Atoi(o["daemon"]); err == nil && n > 0 {
+		r.Interval = time.Duration(n) * time.Second
+	}
+
+	web := o["web"]
+	if r.Type == "AAAA" && o["webv6"] != "" {
+		web = o["webv6"]
+	} else if o["webv4"] != "" {
+		web = o["webv4"]
+	}
+	if strings.HasPrefix(web, "http://") || strings.HasPrefix(web, "https://") {
+		r.IPSource = web
+	} else if u, ok := ddclientWebSources[web]; ok {
+		r.IPSource = u
+	}
+	return r
+}
+
+// parseInadyn parses the inadyn v2 (libConfuse) format:
+//
+//	period = 300
+//	provider default@dyndns.org { username = x  password = y  hostname = { "a", "b" } }
+func parseInadyn(content string) ([]migratedRecord, error) {
+	toks, err := inadynTokens(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []migratedRecord
+	var interval time.Duration
+	for i := 0; i < len(toks); {
+		switch {
+		case i+2 < len(toks) && toks[i+1] == "=":
+			if toks[i] == "period" {
+				if n, err := strconv.Atoi(toks[i+2]); err == nil && n > 0 {
+					interval = time.Duration(n) * time.Second
+				}
+			}
+			i += 3
+		case (toks[i] == "provider" || toks[i] == "custom") && i+2 < len(toks) && toks[i+2] == "{":
+			kind, name := toks[i], toks[i+1]
+			opts, next, err := inadynBlock(toks, i+3)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			recs = append(recs, inadynRecords(kind, name, opts)...)
+		default:
+			return nil, fmt.Errorf("unexpected %q", toks[i])
+		}
+	}
+	for i := range recs {
+		recs[i].Interval = interval
+	}
+	return recs, nil
+}
+
+// inadynBlock reads "key = value" pairs up to the closing brace. List values
+// ({ "a", "b" }) are returned as multiple entries for the same key.
+func inadynBlock(toks []string, i int) (map[string][]string, int, error) {
+	opts := map[string][]string{}
+	for i < len(toks) {
+		if toks[i] == "}" {
+			return opts, i + 1, nil
+		}
+		if i+2 >= len(toks) || toks[i+1] != "=" {
+			return nil, 0, fmt.Errorf("expected key = value near %q", toks[i])
+		}
+		key := strings.ToLower(toks[i])
+		i += 2
+		if toks[i] != "{" {
+			opts[key] = append(opts[key], toks[i])
+			i++
+			continue
+		}
+		for i++; i < len(toks) && toks[i] != "}"; i++ {
+			if toks[i] != "," {
+				opts[key] = append(opts[key], toks[i])
+			}
+		}
+		i++
+	}
+	return nil, 0, errors.New("unterminated block")
+}
+
+func inadynRecords(kind, name string, o map[string][]string) []migratedRecord {
+	first := func(k string) string {
+		if v := o[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	base := migratedRecord{
+		Type:   "A",
+		Origin: fmt.Sprintf("inadyn %s %s", kind, name),
+	}
+	if strings.HasPrefix(name, "ipv6@") {
+		base.Type = "AAAA"
+	}
+	if strings.Contains(name, "digitalocean") {
+		base.Token = first("password")
+	}
+	if n, err := strconv.Atoi(first("ttl")); err == nil && n > 0 {
+		base.TTL = n
+	}
+	if srv := first("checkip-server"); srv != "" {
+		scheme := "https"
+		if first("checkip-ssl") == "false" {
+			scheme = "http"
+		}
+		base.IPSource = scheme + "://" + srv + first("checkip-path")
+	}
+
+	var recs []migratedRecord
+	for _, h := range o["hostname"] {
+		r := base
+		r.Host = h
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func inadynTokens(content string) ([]string, error) {
+	var toks []string
+	rs := []rune(content)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case c == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '{' || c == '}' || c == '=' || c == ',':
+			toks = append(toks, string(c))
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != c {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, errors.New("unterminated string")
+			}
+			toks = append(toks, string(rs[i+1:j]))
+			i = j + 1
+		default:
+			j := i
+			for j < len(rs) && !strings.ContainsRune(" \t\r\n{}=,#\"'", rs[j]) {
+				j++
+			}
+			toks = append(toks, string(rs[i:j]))
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDDClient(t *testing.T) {
+	const conf = `
+# globals
+daemon=300
+protocol=digitalocean, \
+  password='secret-token' # continued line
+use=web, web=ipify-ipv4
+ttl = 600
+zone=example.com
+
+home.example.com
+ttl=120 vpn.example.com,nas.example.com
+
+# later globals only apply to the hosts after them
+usev6=ifv6, webv6=https://v6.example.net/ip
+v6.example.com
+protocol=dyndns2 other.example.org
+`
+	recs, err := parseDDClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []migratedRecord{
+		{Host: "home.example.com", Domain: "example.com", Type: "A", Token: "secret-token", TTL: 600, IPSource: "https://api.ipify.org", Interval: 300 * time.Second, Origin: "ddclient protocol=digitalocean"},
+		{Host: "vpn.example.com", Domain: "example.com", Type: "A", Token: "secret-token", TTL: 120, IPSource: "https://api.ipify.org", Interval: 300 * time.Second, Origin: "ddclient protocol=digitalocean"},
+		{Host: "nas.example.com", Domain: "example.com", Type: "A", Token: "secret-token", TTL: 120, IPSource: "https://api.ipify.org", Interval: 300 * time.Second, Origin: "ddclient protocol=digitalocean"},
+		{Host: "v6.example.com", Domain: "example.com", Type: "AAAA", Token: "secret-token", TTL: 600, IPSource: "https://v6.example.net/ip", Interval: 300 * time.Second, Origin: "ddclient protocol=digitalocean"},
+		{Host: "other.example.org", Domain: "example.com", Type: "AAAA", TTL: 600, IPSource: "https://v6.example.net/ip", Interval: 300 * time.Second, Origin: "ddclient protocol=dyndns2"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("%d records, want %d: %+v", len(recs), len(want), recs)
+	}
+	for i := range want {
+		if recs[i] != want[i] {
+			t.Errorf("record %d:\n got %+v\nwant %+v", i, recs[i], want[i])
+		}
+	}
+}
+
+func TestParseInadyn(t *testing.T) {
+	const conf = `
+period = 600
+# a comment
+provider default@digitalocean.com {
+	username = example.com
+	password = "do-token"
+	hostname = { "home.example.com", "vpn.example.com" }
+	ttl = 300
+	checkip-server = ip.example.net
+	checkip-path = /plain
+}
+provider ipv6@dyndns.org {
+	hostname = 'v6.example.org'
+	checkip-server = ip6.example.net
+	checkip-ssl = false
+}
+`
+	recs, err := parseInadyn(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []migratedRecord{
+		{Host: "home.example.com", Type: "A", Token: "do-token", TTL: 300, IPSource: "https://ip.example.net/plain", Interval: 600 * time.Second, Origin: "inadyn provider default@digitalocean.com"},
+		{Host: "vpn.example.com", Type: "A", Token: "do-token", TTL: 300, IPSource: "https://ip.example.net/plain", Interval: 600 * time.Second, Origin: "inadyn provider default@digitalocean.com"},
+		{Host: "v6.example.org", Type: "AAAA", IPSource: "http://ip6.example.net", Interval: 600 * time.Second, Origin: "inadyn provider ipv6@dyndns.org"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("%d records, want %d: %+v", len(recs), len(want), recs)
+	}
+	for i := range want {
+		if recs[i] != want[i] {
+			t.Errorf("record %d:\n got %+v\nwant %+v", i, recs[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		`provider x { hostname = "a" `,
+		`provider x { hostname "a" }`,
+		`provider x { password = "open }`,
+		`stray`,
+	} {
+		if _, err := parseInadyn(bad); err == nil {
+			t.Errorf("parseInadyn(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSplitHost(t *testing.T) {
+	tests := []struct {
+		name, host, zone, domain string
+		wantDomain, wantName     string
+		wantGuessed              bool
+	}{
+		{"zone from the config", "a.b.example.co.uk", "example.co.uk", "", "example.co.uk", "a.b", false},
+		{"apex", "Example.COM.", "example.com", "", "example.com", "@", false},
+		{"guessed", "home.example.com", "", "", "example.com", "home", true},
+		{"explicit domain wins", "a.b.example.co.uk", "b.example.co.uk", "example.co.uk", "example.co.uk", "a.b", false},
+		// A zone inherited from an earlier ddclient section that does not
+		// contain the host is ignored.
+		{"zone mismatch", "vpn.other.net", "example.com", "", "other.net", "vpn", true},
+		{"explicit domain mismatch", "vpn.other.net", "", "example.com", "other.net", "vpn", true},
+		{"not a suffix on a label boundary", "myexample.com", "example.com", "", "myexample.com", "@", true},
+		{"wildcard", "*.example.com", "example.com", "", "example.com", "*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := migratedRecord{Host: tt.host, Domain: tt.zone}
+			splitHost(&r, tt.domain)
+			if r.Domain != tt.wantDomain || r.Name != tt.wantName || r.Guessed != tt.wantGuessed {
+				t.Errorf("splitHost = %s / %s (guessed %t), want %s / %s (guessed %t)",
+					r.Domain, r.Name, r.Guessed, tt.wantDomain, tt.wantName, tt.wantGuessed)
+			}
+		})
+	}
+}
+
+func TestMigrateOutDir(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "ddclient.conf")
+	write := func(s string) {
+		if err := os.WriteFile(conf, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := func() []string {
+		ents, _ := os.ReadDir(out)
+		var names []string
+		for _, e := range ents {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	// The same name in two domains, and a dual-stack host.
+	write("www.a.com www.b.com a.com\nusev6=ifv6 www.a.com\n")
+	if err := runMigrate([]string{"--from", conf, "--out-dir", out}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.com.env", "www.a.com-v6.env", "www.a.com.env", "www.b.com.env"}
+	if got := files(); !slices.Equal(got, want) {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+
+	// A clash with an existing file writes nothing.
+	write("new.a.com www.b.com\n")
+	if err := runMigrate([]string{"--from", conf, "--out-dir", out}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("migrating over an existing file: %v", err)
+	}
+	if got := files(); !slices.Equal(got, want) {
+		t.Errorf("files %v after a failed migration, want %v", got, want)
+	}
+
+	// So does a host listed twice.
+	write("dup.a.com\nttl=60 dup.a.com\n")
+	if err := runMigrate([]string{"--from", conf, "--out-dir", out}); err == nil || !strings.Contains(err.Error(), "would both be written") {
+		t.Errorf("migrating a duplicate host: %v", err)
+	}
+	if got := files(); !slices.Equal(got, want) {
+		t.Errorf("files %v after a failed migration, want %v", got, want)
+	}
+}
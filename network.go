@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// networkProfile recognizes a network the host can be on, for laptops that
+// should only update some records where they are, e.g. home.example.com
+// only while at home. It matches if the default gateway has one of the
+// listed MAC addresses or the Wi-Fi is connected to one of the SSIDs.
+type networkProfile struct {
+	GatewayMAC stringList `json:"gateway_mac,omitempty"`
+	SSID       stringList `json:"ssid,omitempty"`
+}
+
+func (p networkProfile) validate() error {
+	if len(p.GatewayMAC) == 0 && len(p.SSID) == 0 {
+		return errors.New("needs gateway_mac or ssid")
+	}
+	for _, m := range p.GatewayMAC {
+		if _, err := net.ParseMAC(m); err != nil {
+			return fmt.Errorf("gateway_mac: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p networkProfile) matches(n networkInfo) bool {
+	for _, m := range p.GatewayMAC {
+		if hw, err := net.ParseMAC(m); err == nil && n.GatewayMAC != nil && hw.String() == n.GatewayMAC.String() {
+			return true
+		}
+	}
+	return n.SSID != "" && slices.Contains(p.SSID, n.SSID)
+}
+
+// networkInfo is what was observed about the network the host is on.
+type networkInfo struct {
+	GatewayMAC net.HardwareAddr
+	SSID       string
+}
+
+func (n networkInfo) String() string {
+	mac, ssid := "unknown", "none"
+	if n.GatewayMAC != nil {
+		mac = n.GatewayMAC.String()
+	}
+	if n.SSID != "" {
+		ssid = n.SSID
+	}
+	return "gateway " + mac + ", SSID " + ssid
+}
+
+// currentNetworks returns the names of the profiles matching the network
+// the host is on now, or nil and false if no record is limited to a network.
+// cfg.Network, if set, names the current profiles instead of detecting them.
+func currentNetworks(records []Config) ([]string, bool) {
+	if !slices.ContainsFunc(records, func(c Config) bool { return len(c.Networks) > 0 }) {
+		return nil, false
+	}
+	cfg := records[0]
+	if cfg.Network != "" {
+		here := splitList(cfg.Network)
+		logm(msgNetworkCurrent, strings.Join(here, ","), "set by --network")
+		return here, true
+	}
+
+	n := detectNetwork()
+	var here []string
+	for name, p := range cfg.NetworkProfiles {
+		if p.matches(n) {
+			here = append(here, name)
+		}
+	}
+	slices.Sort(here)
+	if len(here) == 0 {
+		logm(msgNetworkCurrent, "none", n)
+	} else {
+		logm(msgNetworkCurrent, strings.Join(here, ","), n)
+	}
+	return here, true
+}
+
+// onNetwork reports whether cfg may be reconciled on the networks here.
+func onNetwork(cfg Config, here []string) bool {
+	if len(cfg.Networks) == 0 {
+		return true
+	}
+	for _, n := range cfg.Networks {
+		if slices.Contains(here, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// offNetworkResults reports the types of cfg as skipped because the host is
+// not on one of the record's networks.
+func offNetworkResults(cfg Config, here []string) []runResult {
+	current := "none"
+	if len(here) > 0 {
+		current = strings.Join(here, ",")
+	}
+	var out []runResult
+	for _, t := range cfg.Types {
+		logm(msgRecordOffNetwork, t, cfg.Name, cfg.Domain, strings.Join(cfg.Networks, ","), current)
+		res := runResult{Domain: cfg.Domain, Name: cfg.Name, Type: t, Action: "off-network"}
+		res.explain(msgExplainOffNetwork, strings.Join(cfg.Networks, ","), current)
+		out = append(out, res)
+	}
+	return out
+}
+
+// detectNetwork looks up the default gateway's MAC address (from the Linux
+// routing and ARP tables) and the connected Wi-Fi SSID. Either is left
+// empty where it cannot be determined.
+func detectNetwork() networkInfo {
+	var n networkInfo
+	if gw, err := defaultGateway(); err == nil {
+		n.GatewayMAC, _ = neighborMAC(gw)
+	}
+	n.SSID = currentSSID()
+	return n
+}
+
+// defaultGateway returns the IPv4 gateway of the default route with the
+// lowest metric, from /proc/net/route.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var gw net.IP
+	best := -1
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 7 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		metric, _ := strconv.Atoi(fields[6])
+		if best < 0 || metric < best {
+			// the kernel prints the address in host (little-endian) order
+			gw, best = net.IPv4(b[3], b[2], b[1], b[0]), metric
+		}
+	}
+	if gw == nil {
+		return nil, errors.New("no default IPv4 route")
+	}
+	return gw, nil
+}
+
+// neighborMAC looks ip up in the ARP cache, /proc/net/arp.
+func neighborMAC(ip net.IP) (net.HardwareAddr, error) {
+	b, err := os.ReadFile("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[0] == ip.String() {
+			return net.ParseMAC(fields[3])
+		}
+	}
+	return nil, fmt.Errorf("%s is not in the ARP cache", ip)
+}
+
+// currentSSID asks iwgetid or, failing that, NetworkManager for the SSID of
+// the connected Wi-Fi network.
+func currentSSID() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := exec.LookPath("iwgetid"); err == nil {
+		if out, err := exec.CommandContext(ctx, "iwgetid", "-r").Output(); err == nil {
+			if s := strings.TrimSpace(string(out)); s != "" {
+				return s
+			}
+		}
+	}
+	if _, err := exec.LookPath("nmcli"); err == nil {
+		out, err := exec.CommandContext(ctx, "nmcli", "-t", "-f", "active,ssid", "dev", "wifi").Output()
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if ssid, ok := strings.CutPrefix(line, "yes:"); ok {
+				return strings.ReplaceAll(ssid, `\:`, ":")
+			}
+		}
+	}
+	return ""
+}
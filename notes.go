@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inventory holds free-form notes about managed records, since DigitalOcean
+// records cannot carry comments. It lives next to the state DB but in its
+// own file, so a team can keep it under version control or edit it by hand.
+type inventory struct {
+	Records []inventoryEntry `json:"records"`
+}
+
+// inventoryEntry annotates a record; an empty Type covers every type of the
+// name.
+type inventoryEntry struct {
+	Domain  string    `json:"domain"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type,omitempty"`
+	Note    string    `json:"note,omitempty"`
+	Owner   string    `json:"owner,omitempty"`
+	Updated time.Time `json:"updated,omitzero"`
+}
+
+func inventoryPath(stateDir string) string {
+	return filepath.Join(stateDir, "do-ddns.inventory.json")
+}
+
+func loadInventory(stateDir string) (*inventory, error) {
+	var inv inventory
+	b, err := os.ReadFile(inventoryPath(stateDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &inv, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &inv); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", inventoryPath(stateDir), err)
+	}
+	return &inv, nil
+}
+
+func (inv *inventory) save(stateDir string) error {
+	sort.Slice(inv.Records, func(i, j int) bool {
+		a, b := inv.Records[i], inv.Records[j]
+		return recordKey(a.Domain, a.Name, a.Type) < recordKey(b.Domain, b.Name, b.Type)
+	})
+	b, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := inventoryPath(stateDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lookup returns the entry for the record, preferring one for its exact
+// type over a name-wide one.
+func (inv *inventory) lookup(domain, name, recordType string) (inventoryEntry, bool) {
+	var found inventoryEntry
+	ok := false
+	for _, e := range inv.Records {
+		if e.Domain != domain || e.Name != name {
+			continue
+		}
+		if e.Type == recordType {
+			return e, true
+		}
+		if e.Type == "" {
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// annotate copies inventory notes onto results, for notifications, the run
+// summary and the daemon's /status.
+func annotate(stateDir string, results []runResult) {
+	inv, err := loadInventory(stateDir)
+	if err != nil {
+		warnf("%s", msg(msgInventoryReadFailed, err))
+		return
+	}
+	for i, r := range results {
+		if e, ok := inv.lookup(r.Domain, r.Name, r.Type); ok {
+			results[i].Note, results[i].Owner = e.Note, e.Owner
+		}
+	}
+}
+
+// runNote implements `do-ddns note`: set or clear a record's note and owner.
+func runNote(args []string) error {
+	fs := flag.NewFlagSet("note", flag.ExitOnError)
+	cfg := recordFlags(fs)
+	note := fs.String("note", "", "What the record is for")
+	owner := fs.String("owner", "", "Who to ask about it")
+	remove := fs.Bool("clear", false, "Remove the record's note")
+	fs.Parse(args)
+
+	if strings.TrimSpace(cfg.Domain) == "" || strings.TrimSpace(cfg.Name) == "" {
+		return withExitCode(2, errors.New("note: --domain and --name are required"))
+	}
+	if !*remove && *note == "" && *owner == "" {
+		return withExitCode(2, errors.New("note: give --note and/or --owner, or --clear"))
+	}
+	// Without an explicit --type the note covers every type of the name.
+	recordType := ""
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "type" {
+			recordType = strings.ToUpper(strings.TrimSpace(cfg.Type))
+		}
+	})
+
+	inv, err := loadInventory(cfg.StateDir)
+	if err != nil {
+		return err
+	}
+	kept := inv.Records[:0]
+	var old inventoryEntry
+	for _, e := range inv.Records {
+		if e.Domain == cfg.Domain && e.Name == cfg.Name && e.Type == recordType {
+			old = e
+			continue
+		}
+		kept = append(kept, e)
+	}
+	inv.Records = kept
+	if !*remove {
+		e := inventoryEntry{Domain: cfg.Domain, Name: cfg.Name, Type: recordType, Note: old.Note, Owner: old.Owner, Updated: time.Now().UTC()}
+		if *note != "" {
+			e.Note = *note
+		}
+		if *owner != "" {
+			e.Owner = *owner
+		}
+		inv.Records = append(inv.Records, e)
+	}
+	if err := inv.save(cfg.StateDir); err != nil {
+		return fmt.Errorf("note: %w", err)
+	}
+	if *remove {
+		logm(msgNoteRemoved, displayName(cfg.Name, cfg.Domain))
+	} else {
+		logm(msgNoteSaved, displayName(cfg.Name, cfg.Domain), inventoryPath(cfg.StateDir))
+	}
+	return nil
+}
+
+// runList implements `do-ddns list`: the managed records with their last
+// published value and notes. Records come from --config, or --domain/--name;
+// inventory entries for other records are listed too.
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	base := recordFlags(fs)
+	configPath := new(string)
+	configFlag(fs, configPath, "YAML config file defining the records (or env DO_CONFIG)")
+	asJSON := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+
+	type row struct {
+		Domain string `json:"domain"`
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		IP     string `json:"last_ip,omitempty"`
+		Note   string `json:"note,omitempty"`
+		Owner  string `json:"owner,omitempty"`
+	}
+	var rows []row
+	if *configPath != "" {
+		fc, err := loadConfigFile(*configPath)
+		if err != nil {
+			return withExitCode(2, err)
+		}
+		if fc.StateDir != "" {
+			base.StateDir = fc.StateDir
+		}
+		for _, r := range fc.Records {
+			types := []string(r.Type)
+			if len(types) == 0 {
+				types = []string{"A"}
+			}
+			domain, _ := toASCII(r.Domain)
+			name, _ := toASCII(r.Name)
+			for _, t := range types {
+				rows = append(rows, row{Domain: domain, Name: name, Type: strings.ToUpper(strings.TrimSpace(t))})
+			}
+		}
+	} else if base.Domain != "" && base.Name != "" {
+		for _, t := range splitList(strings.ToUpper(base.Type)) {
+			rows = append(rows, row{Domain: base.Domain, Name: base.Name, Type: t})
+		}
+	}
+
+	inv, err := loadInventory(base.StateDir)
+	if err != nil {
+		return err
+	}
+	listed := map[string]bool{}
+	for i, r := range rows {
+		c := Config{Domain: r.Domain, Name: r.Name, Type: r.Type, StateDir: base.StateDir}
+		rows[i].IP, _ = readLastIP(stateFile(c))
+		if e, ok := inv.lookup(r.Domain, r.Name, r.Type); ok {
+			rows[i].Note, rows[i].Owner = e.Note, e.Owner
+		}
+		listed[r.Domain+"/"+r.Name] = true
+	}
+	for _, e := range inv.Records {
+		if !listed[e.Domain+"/"+e.Name] {
+			rows = append(rows, row{Domain: e.Domain, Name: e.Name, Type: e.Type, Note: e.Note, Owner: e.Owner})
+		}
+	}
+
+	if *asJSON {
+		if rows == nil {
+			rows = []row{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	if len(rows) == 0 {
+		fmt.Println("No records (pass --config or --domain/--name, or add notes with do-ddns note).")
+		return nil
+	}
+	for _, r := range rows {
+		t := r.Type
+		if t == "" {
+			t = "*"
+		}
+		line := fmt.Sprintf("%-5s %s  %s", t, displayName(r.Name, r.Domain), orNone(r.IP))
+		if r.Note != "" {
+			line += "  " + r.Note
+		}
+		if r.Owner != "" {
+			line += " (" + r.Owner + ")"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
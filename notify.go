@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifyConfig configures the change/failure hook.
+type notifyConfig struct {
+	URL  string `json:"url,omitempty"`
+	Kind string `json:"kind,omitempty"` // webhook, ntfy or slack; guessed from URL if empty
+}
+
+func (n notifyConfig) kind() string {
+	if n.Kind != "" {
+		return strings.ToLower(n.Kind)
+	}
+	switch {
+	case strings.Contains(n.URL, "hooks.slack.com"):
+		return "slack"
+	case strings.Contains(n.URL, "ntfy"):
+		return "ntfy"
+	default:
+		return "webhook"
+	}
+}
+
+func (n notifyConfig) validate() error {
+	switch n.kind() {
+	case "webhook", "ntfy", "slack":
+		return nil
+	default:
+		return fmt.Errorf("notify: unknown kind %q (want webhook, ntfy or slack)", n.Kind)
+	}
+}
+
+// notifyEvent is the generic webhook payload.
+type notifyEvent struct {
+	Event   string         `json:"event"` // change, failure
+	Time    time.Time      `json:"time"`
+	RunID   string         `json:"run_id,omitempty"`
+	Records []notifyRecord `json:"records"`
+}
+
+type notifyRecord struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value,omitempty"`
+	Action string `json:"action,omitempty"`
+	ID     int64  `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Deleted lists the duplicates removed by cleanup_duplicates, saved to
+	// the audit Snapshot file before they were deleted.
+	Deleted  []DomainRecord `json:"deleted,omitempty"`
+	Snapshot string         `json:"snapshot,omitempty"`
+	// Deferred lists changes waiting for the change window.
+	Deferred []string `json:"deferred,omitempty"`
+	// Note and Owner come from the local inventory.
+	Note  string `json:"note,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	// Group is the config group the record belongs to.
+	Group string `json:"group,omitempty"`
+	// SkipReason and Why (the --explain reasoning) are only in the
+	// --summary output.
+	SkipReason string   `json:"skip_reason,omitempty"`
+	Why        []string `json:"why,omitempty"`
+}
+
+// notify reports the records from results that were created, updated,
+// failed, or had duplicates deleted. Nothing is sent if every record was left
+// alone. Delivery failures are logged, never fatal.
+func (n notifyConfig) notify(ctx context.Context, results []runResult) {
+	if n.URL == "" {
+		return
+	}
+
+	ev := notifyEvent{Event: "change", Time: time.Now(), RunID: runID()}
+	held := 0
+	for _, r := range results {
+		nr := notifyRecord{Domain: r.Domain, Name: r.Name, Type: r.Type, Value: r.IP, Action: r.Action, ID: r.ID, Deleted: r.Deleted, Snapshot: r.Snapshot, Deferred: r.Deferred, Note: r.Note, Owner: r.Owner, Group: r.Group}
+		switch {
+		case r.Err != nil && inAPIMaintenance(r.Err):
+			held++
+			continue
+		case r.Err != nil:
+			nr.Error = r.Err.Error()
+			ev.Event = "failure"
+		case r.Action == "created" || r.Action == "updated" || r.Action == "rolled-back" || len(r.Deleted) > 0:
+		default:
+			continue
+		}
+		ev.Records = append(ev.Records, nr)
+	}
+	if held > 0 {
+		logm(msgNotifyHeld, held)
+	}
+	if len(ev.Records) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if err := n.send(ctx, ev); err != nil {
+		warnf("%s", msg(msgNotifyFailed, n.kind(), err))
+	}
+}
+
+func (n notifyConfig) send(ctx context.Context, ev notifyEvent) error {
+	var body []byte
+	contentType := "application/json"
+	title := "do-ddns: DNS record changed"
+	if ev.Event == "failure" {
+		title = "do-ddns: DNS update failed"
+	}
+
+	switch n.kind() {
+	case "slack":
+		body, _ = json.Marshal(map[string]string{"text": title + "\n" + ev.summary()})
+	case "ntfy":
+		body = []byte(ev.summary())
+		contentType = "text/plain; charset=utf-8"
+	default:
+		body, _ = json.Marshal(ev)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	setRequestHeaders(req)
+	if n.kind() == "ntfy" {
+		req.Header.Set("Title", title)
+		if ev.Event == "failure" {
+			req.Header.Set("Priority", "high")
+			req.Header.Set("Tags", "warning")
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (ev notifyEvent) summary() string {
+	var lines []string
+	for _, r := range ev.Records {
+		fqdn := r.Name + "." + r.Domain
+		note := ""
+		if r.Note != "" {
+			note = "\n  note: " + r.Note
+		}
+		if r.Owner != "" {
+			note += "\n  owner: " + r.Owner
+		}
+		if r.Error != "" {
+			lines = append(lines, fmt.Sprintf("%s %s: FAILED: %s%s", fqdn, r.Type, r.Error, note))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s -> %s (%s)%s", fqdn, r.Type, r.Value, r.Action, note))
+		for _, d := range r.Deleted {
+			lines = append(lines, fmt.Sprintf("  deleted duplicate id=%d: %s (ttl=%d)", d.ID, d.Data, d.TTL))
+		}
+		if r.Snapshot != "" {
+			lines = append(lines, "  snapshot: "+r.Snapshot)
+		}
+	}
+	if ev.RunID != "" {
+		lines = append(lines, "run "+ev.RunID)
+	}
+	return strings.Join(lines, "\n")
+}
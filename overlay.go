@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// A config can be layered from several files, e.g. a base shared by a fleet
+// and a small per-site overlay: `--config base.yaml --config site.yaml`, or
+// DO_CONFIG=base.yaml,site.yaml. Each file is merged onto the ones before
+// it:
+//
+//   - maps (the top level, notify, networks, targets, ...) merge key by key,
+//     recursively
+//   - records merge by position: the overlay's first entry onto the base's
+//     first, and so on; extra entries are appended
+//   - any other value, lists included, replaces the earlier one
+//   - null removes the key, falling back to the flag / env default
+
+// configPaths is the repeatable --config flag, stored comma-separated.
+type configPaths struct {
+	p   *string
+	set bool
+}
+
+func (c *configPaths) String() string {
+	if c.p == nil {
+		return ""
+	}
+	return *c.p
+}
+
+func (c *configPaths) Set(s string) error {
+	if !c.set || *c.p == "" {
+		*c.p, c.set = s, true
+		return nil
+	}
+	*c.p += "," + s
+	return nil
+}
+
+// configFlag registers --config, defaulting to DO_CONFIG.
+func configFlag(fs *flag.FlagSet, p *string, usage string) {
+	*p = strings.TrimSpace(envDefault("DO_CONFIG", ""))
+	fs.Var(&configPaths{p: p}, "config", usage)
+}
+
+// mergeConfig merges the decoded overlay onto base; see above.
+func mergeConfig(base, overlay any) any {
+	switch o := overlay.(type) {
+	case map[string]any:
+		b, ok := base.(map[string]any)
+		if !ok {
+			return stripNulls(o)
+		}
+		for k, v := range o {
+			switch {
+			case v == nil:
+				delete(b, k)
+			case k == "records":
+				b[k] = mergeRecords(b[k], v)
+			default:
+				b[k] = mergeConfig(b[k], v)
+			}
+		}
+		return b
+	}
+	return overlay
+}
+
+func mergeRecords(base, overlay any) any {
+	b, ok1 := base.([]any)
+	o, ok2 := overlay.([]any)
+	if !ok1 || !ok2 {
+		return overlay
+	}
+	for i, v := range o {
+		if i < len(b) {
+			b[i] = mergeConfig(b[i], v)
+		} else {
+			b = append(b, mergeConfig(nil, v))
+		}
+	}
+	return b
+}
+
+// stripNulls drops null map entries from a value that has nothing to merge
+// onto.
+func stripNulls(m map[string]any) map[string]any {
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			delete(m, k)
+		case map[string]any:
+			stripNulls(v)
+		}
+	}
+	return m
+}
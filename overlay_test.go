@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+)
+
+func TestMergeConfig(t *testing.T) {
+	tests := []struct {
+		name, base, overlay string
+		want                string // JSON
+	}{
+		{"scalar override",
+			"token: base\nttl: 300\n", "ttl: 60\n",
+			`{"token":"base","ttl":60}`},
+		{"maps merge recursively",
+			"notify:\n  webhook: https://a.example\n  on: failure\n", "notify:\n  on: change\n",
+			`{"notify":{"on":"change","webhook":"https://a.example"}}`},
+		{"lists replace",
+			"networks:\n  home:\n    cidrs: [192.0.2.0/24, 198.51.100.0/24]\n", "networks:\n  home:\n    cidrs: [203.0.113.0/24]\n",
+			`{"networks":{"home":{"cidrs":["203.0.113.0/24"]}}}`},
+		{"null removes a key",
+			"token: base\nttl: 300\n", "ttl: null\n",
+			`{"token":"base"}`},
+		{"null inside a new map is dropped",
+			"token: base\n", "notify:\n  webhook: https://a.example\n  on: null\n",
+			`{"notify":{"webhook":"https://a.example"},"token":"base"}`},
+		{"records match by position",
+			"records:\n  - domain: example.com\n    name: hq\n    ttl: 300\n  - domain: example.com\n    name: vpn\n",
+			"records:\n  - ttl: 60\n  - name: nas\n    group: office\n",
+			`{"records":[{"domain":"example.com","name":"hq","ttl":60},{"domain":"example.com","group":"office","name":"nas"}]}`},
+		{"extra records are appended",
+			"records:\n  - domain: example.com\n    name: hq\n",
+			"records:\n  - name: hq\n  - domain: example.org\n    name: www\n    data: null\n",
+			`{"records":[{"domain":"example.com","name":"hq"},{"domain":"example.org","name":"www"}]}`},
+		{"a record field is replaced, not merged",
+			"records:\n  - domain: example.com\n    name: hq\n    type: [A, AAAA]\n",
+			"records:\n  - type: A\n",
+			`{"records":[{"domain":"example.com","name":"hq","type":"A"}]}`},
+		{"an empty records list changes nothing",
+			"records:\n  - domain: example.com\n    name: hq\n", "records: []\n",
+			`{"records":[{"domain":"example.com","name":"hq"}]}`},
+		{"null removes the records",
+			"token: base\nrecords:\n  - domain: example.com\n    name: hq\n", "records: null\n",
+			`{"token":"base"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := parseYAML(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			overlay, err := parseYAML(tt.overlay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(mergeConfig(base, overlay))
+			if string(got) != tt.want {
+				t.Errorf("merged:\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	t.Setenv("DO_CONFIG", "env.yaml")
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "env.yaml"},
+		{[]string{"--config", "a.yaml"}, "a.yaml"},
+		{[]string{"--config", "a.yaml", "--config", "b.yaml"}, "a.yaml,b.yaml"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var p string
+		configFlag(fs, &p, "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if p != tt.want {
+			t.Errorf("%q: config = %q, want %q", tt.args, p, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pausedRecord marks a record the updater must leave alone until `do-ddns
+// resume`, e.g. while debugging or renumbering by hand. An empty Type pauses
+// every type of the name; a zero Until pauses until resumed.
+type pausedRecord struct {
+	Domain string    `json:"domain"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitzero"`
+}
+
+func (p pausedRecord) covers(domain, name, recordType string) bool {
+	return p.Domain == domain && p.Name == name && (p.Type == "" || p.Type == recordType) &&
+		(p.Until.IsZero() || time.Now().Before(p.Until))
+}
+
+func (p pausedRecord) String() string {
+	s := p.Type + " " + p.Name + "." + p.Domain
+	if p.Type == "" {
+		s = p.Name + "." + p.Domain + " (all types)"
+	}
+	s += " since " + p.Since.Format(time.RFC3339)
+	if !p.Until.IsZero() {
+		s += ", until " + p.Until.Format(time.RFC3339)
+	}
+	if p.Reason != "" {
+		s += ": " + p.Reason
+	}
+	return s
+}
+
+// isPaused returns the pause covering the record, if any.
+func isPaused(cfg Config) (pausedRecord, bool) {
+	db, err := viewStateDB(cfg.StateDir)
+	if err != nil {
+		warnf("%s", msg(msgStateReadFailed, err))
+		return pausedRecord{}, false
+	}
+	for _, p := range db.Paused {
+		if p.covers(cfg.Domain, cfg.Name, cfg.Type) {
+			return p, true
+		}
+	}
+	return pausedRecord{}, false
+}
+
+// runPause implements `do-ddns pause` and `do-ddns resume`.
+func runPause(args []string) error  { return pauseCommand("pause", args) }
+func runResume(args []string) error { return pauseCommand("resume", args) }
+
+func pauseCommand(mode string, args []string) error {
+	fs := flag.NewFlagSet(mode, flag.ExitOnError)
+	cfg := recordFlags(fs)
+	reason := fs.String("reason", "", "Why the record is paused, shown in logs and --list")
+	pauseFor := fs.Duration("for", 0, "Resume automatically after this long, e.g. 2h (default: until do-ddns resume)")
+	list := fs.Bool("list", false, "List paused records")
+	fs.Parse(args)
+
+	db, err := viewStateDB(cfg.StateDir)
+	if err != nil {
+		return err
+	}
+	if *list {
+		n := 0
+		for _, p := range db.Paused {
+			if p.Until.IsZero() || time.Now().Before(p.Until) {
+				fmt.Println(p)
+				n++
+			}
+		}
+		if n == 0 {
+			fmt.Println("No paused records.")
+		}
+		return nil
+	}
+
+	if strings.TrimSpace(cfg.Domain) == "" || strings.TrimSpace(cfg.Name) == "" {
+		return withExitCode(2, fmt.Errorf("%s: --domain and --name are required", mode))
+	}
+	// Without an explicit --type every type of the name is affected.
+	recordType := ""
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "type" {
+			recordType = strings.ToUpper(strings.TrimSpace(cfg.Type))
+		}
+	})
+	p := pausedRecord{Domain: cfg.Domain, Name: cfg.Name, Type: recordType, Reason: *reason, Since: time.Now()}
+	if *pauseFor > 0 {
+		p.Until = p.Since.Add(*pauseFor)
+	}
+
+	removed := 0
+	err = updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		kept := db.Paused[:0]
+		for _, old := range db.Paused {
+			expired := !old.Until.IsZero() && time.Now().After(old.Until)
+			// resume lifts every matching pause (a name-wide resume lifts
+			// per-type pauses too); a new pause replaces the same one.
+			same := old.Domain == p.Domain && old.Name == p.Name && (old.Type == p.Type || (mode == "resume" && p.Type == ""))
+			if expired || same {
+				if same && !expired {
+					removed++
+				}
+				continue
+			}
+			kept = append(kept, old)
+		}
+		db.Paused = kept
+		if mode == "pause" {
+			db.Paused = append(db.Paused, p)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", mode, err)
+	}
+
+	if mode == "resume" {
+		if removed == 0 {
+			return withExitCode(2, errors.New("resume: no matching paused record"))
+		}
+		logm(msgResumed, p.Name, p.Domain)
+		return nil
+	}
+	logm(msgPaused, p, p.Domain, p.Name)
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// A plan is what `do-ddns plan` detected and `do-ddns apply` publishes. The
+// two halves let the internet-facing detection run as a user (or host)
+// without the API token, and the token holder run nothing but the
+// reconcile against the DigitalOcean API.
+type plan struct {
+	RunID   string          `json:"run_id"`
+	Created time.Time       `json:"created"`
+	Expires time.Time       `json:"expires"`
+	Records []plannedRecord `json:"records"`
+}
+
+type plannedRecord struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value"` // comma-separated for an addresses set
+}
+
+// signedPlan is the plan file: the plan, and an HMAC-SHA256 of its compact
+// JSON with the key shared by planner and executor.
+type signedPlan struct {
+	Plan      json.RawMessage `json:"plan"`
+	Signature string          `json:"signature"`
+}
+
+const planSigPrefix = "hmac-sha256:"
+
+func readPlanKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("--plan-key is required")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := []byte(strings.TrimSpace(string(b)))
+	if len(key) < 16 {
+		return nil, fmt.Errorf("%s: the plan key must be at least 16 bytes", path)
+	}
+	return key, nil
+}
+
+func planMAC(key, body []byte) string {
+	m := hmac.New(sha256.New, key)
+	m.Write(body)
+	return planSigPrefix + hex.EncodeToString(m.Sum(nil))
+}
+
+// runPlan implements `do-ddns plan`: detect the values the records should
+// hold and write them as a signed plan. It never reads the API token.
+func runPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	base := recordFlags(fs)
+	configPath := new(string)
+	configFlag(fs, configPath, "YAML config file defining the records (or env DO_CONFIG)")
+	keyFile := fs.String("plan-key", os.Getenv("DO_PLAN_KEY_FILE"), "File holding the secret plans are signed with, shared with the executor (or env DO_PLAN_KEY_FILE)")
+	out := fs.String("out", "-", "Write the plan to this file, or - for stdout")
+	valid := fs.Duration("valid", 10*time.Minute, "How long the plan may be applied")
+	crossCheck := fs.String("cross-check", os.Getenv("CROSS_CHECK"), "Independent IP echo URL(s) that must confirm the detected IP (or env CROSS_CHECK)")
+	fs.Parse(args)
+	base.Token = ""
+
+	key, err := readPlanKey(*keyFile)
+	if err != nil {
+		return withExitCode(2, fmt.Errorf("plan: %w", err))
+	}
+	var records []Config
+	if *configPath != "" {
+		fc, err := loadConfigFile(*configPath)
+		if err != nil {
+			return withExitCode(2, err)
+		}
+		if records, err = fc.expand(*base, false); err != nil {
+			return withExitCode(2, fmt.Errorf("%s: %w", *configPath, err))
+		}
+	} else {
+		if base.Domain == "" || base.Name == "" {
+			return withExitCode(2, errors.New("plan: --config or --domain and --name are required"))
+		}
+		base.Types = splitList(strings.ToUpper(base.Type))
+		records = []Config{*base}
+	}
+	if *crossCheck == "" {
+		*crossCheck = records[0].CrossCheck
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+	defer cancel()
+	det := newIPDetector(*crossCheck)
+	p := plan{RunID: runID(), Created: time.Now().UTC(), Records: []plannedRecord{}}
+	p.Expires = p.Created.Add(*valid)
+	var errs []error
+	for _, cfg := range records {
+		for _, t := range cfg.Types {
+			c := cfg
+			c.Type = t
+			v, err := desiredData(ctx, c, det)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", fqdn(c.Name, c.Domain), err))
+				continue
+			}
+			p.Records = append(p.Records, plannedRecord{Domain: c.Domain, Name: c.Name, Type: t, Value: v})
+		}
+	}
+	if len(p.Records) == 0 {
+		return fmt.Errorf("plan: nothing to plan: %w", errors.Join(errs...))
+	}
+
+	body, _ := json.Marshal(p)
+	b, _ := json.MarshalIndent(signedPlan{Plan: body, Signature: planMAC(key, body)}, "", "  ")
+	b = append(b, '\n')
+	if *out == "-" {
+		os.Stdout.Write(b)
+	} else if err := os.WriteFile(*out, b, 0600); err != nil {
+		return fmt.Errorf("plan: %w", err)
+	} else {
+		logm(msgPlanWrote, len(p.Records), *out, p.Expires.Format(time.RFC3339))
+	}
+	if len(errs) > 0 {
+		return withExitCode(partialExitCode, errors.Join(errs...))
+	}
+	return nil
+}
+
+// runApply implements `do-ddns apply PLAN`: verify a plan from `do-ddns
+// plan` and reconcile its records. With --config only the records defined
+// there are accepted, with their settings; otherwise --allow-any is
+// required and the flags apply to every record in the plan.
+func runApply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	base := recordFlags(fs)
+	configPath := new(string)
+	configFlag(fs, configPath, "YAML config file; only its records may be changed (or env DO_CONFIG)")
+	keyFile := fs.String("plan-key", os.Getenv("DO_PLAN_KEY_FILE"), "File holding the secret plans are signed with (or env DO_PLAN_KEY_FILE)")
+	fs.BoolVar(&base.CleanupDuplicates, "cleanup-duplicates", false, "Delete duplicate matching records (keeps lowest ID)")
+	allowAny := fs.Bool("allow-any", false, "Without --config, apply the plan to whatever records it names")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return withExitCode(2, errors.New("usage: do-ddns apply [flags] PLAN-FILE|-"))
+	}
+	// The config is the allow-list: a plan signed with a leaked key must
+	// not be able to rewrite every record in the account.
+	if *configPath == "" && !*allowAny {
+		return withExitCode(2, errors.New("apply: --config is required to limit the records a plan may change (or pass --allow-any)"))
+	}
+
+	key, err := readPlanKey(*keyFile)
+	if err != nil {
+		return withExitCode(2, fmt.Errorf("apply: %w", err))
+	}
+	if base.OnConflict, err = parseConflictPolicy(base.OnConflict); err != nil {
+		return withExitCode(2, fmt.Errorf("apply: --on-conflict: %w", err))
+	}
+	allowed := map[string]Config{}
+	if *configPath != "" {
+		fc, err := loadConfigFile(*configPath)
+		if err != nil {
+			return withExitCode(2, err)
+		}
+		records, err := fc.recordConfigs(*base)
+		if err != nil {
+			return withExitCode(2, fmt.Errorf("%s: %w", *configPath, err))
+		}
+		for _, c := range records {
+			for _, t := range c.Types {
+				allowed[recordKey(c.Domain, c.Name, t)] = c
+			}
+		}
+		base.Token, base.StateDir = records[0].Token, records[0].StateDir
+	}
+	if strings.TrimSpace(base.Token) == "" {
+		return withExitCode(2, errors.New("apply: DO_TOKEN / --token is required"))
+	}
+
+	p, err := readPlan(fs.Arg(0), key, base.StateDir)
+	if err != nil {
+		return withExitCode(2, fmt.Errorf("apply: %w", err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var results []runResult
+	var errs []error
+	applied := 0
+	listed := map[string][]DomainRecord{}
+	for _, r := range p.Records {
+		res := runResult{Domain: r.Domain, Name: r.Name, Type: r.Type, IP: r.Value}
+		c := *base
+		if *configPath != "" {
+			var ok bool
+			if c, ok = allowed[res.key()]; !ok {
+				res.Err = fmt.Errorf("%s %s is not in %s; refusing to change it", r.Type, fqdn(r.Name, r.Domain), *configPath)
+			}
+		}
+		c.Domain, c.Name, c.Type, c.Types = r.Domain, r.Name, r.Type, []string{r.Type}
+		if res.Err == nil {
+			res.Err = checkPlannedValue(r)
+		}
+		if res.Err == nil {
+			res.Err = applyPlanned(ctx, c, r.Value, listed, &res)
+		}
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		} else {
+			applied++
+		}
+		results = append(results, res)
+	}
+	// A plan that changed nothing (say, the API was down) can be retried;
+	// once any record was applied it cannot be replayed.
+	if applied > 0 || len(errs) == 0 {
+		if err := updateStateDB(base.StateDir, func(db *stateDB) bool {
+			db.LastPlan = p.Created
+			return true
+		}); err != nil {
+			warnf("%s", msg(msgStateWriteFailed, err))
+		}
+	}
+	return runOutcome(results, errors.Join(errs...))
+}
+
+// readPlan reads and verifies a plan file ("-" for stdin): the signature
+// must match, the plan must not have expired, and it must be newer than
+// the last plan applied from stateDir, so an old plan cannot be replayed.
+func readPlan(path string, key []byte, stateDir string) (*plan, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sp signedPlan
+	if err := json.Unmarshal(b, &sp); err != nil {
+		return nil, fmt.Errorf("parsing plan: %w", err)
+	}
+	// The signature covers the compact JSON, so re-indenting the file
+	// (MarshalIndent above, or a pretty-printer) does not invalidate it.
+	var body bytes.Buffer
+	if err := json.Compact(&body, sp.Plan); err != nil {
+		return nil, fmt.Errorf("parsing plan: %w", err)
+	}
+	if !hmac.Equal([]byte(sp.Signature), []byte(planMAC(key, body.Bytes()))) {
+		return nil, errors.New("plan signature does not match (wrong --plan-key, or the plan was modified)")
+	}
+	var p plan
+	if err := json.Unmarshal(sp.Plan, &p); err != nil {
+		return nil, fmt.Errorf("parsing plan: %w", err)
+	}
+	now := time.Now()
+	if now.After(p.Expires) {
+		return nil, fmt.Errorf("plan %s expired at %s", p.RunID, p.Expires.Format(time.RFC3339))
+	}
+	db, err := viewStateDB(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Created.After(db.LastPlan) {
+		return nil, fmt.Errorf("plan %s (%s) is not newer than the last applied plan (%s)", p.RunID, p.Created.Format(time.RFC3339), db.LastPlan.Format(time.RFC3339))
+	}
+	logm(msgPlanApplying, p.RunID, p.Created.Format(time.RFC3339), len(p.Records))
+	return &p, nil
+}
+
+// checkPlannedValue rejects values that cannot be right for the record type.
+func checkPlannedValue(r plannedRecord) error {
+	if !isAddressType(r.Type) {
+		if r.Value == "" {
+			return fmt.Errorf("%s %s: empty value in plan", r.Type, fqdn(r.Name, r.Domain))
+		}
+		return nil
+	}
+	for _, v := range strings.Split(r.Value, ",") {
+		ip := net.ParseIP(v)
+		if ip == nil || (ip.To4() != nil) != (r.Type == "A") {
+			return fmt.Errorf("%s %s: %q in plan is not an %s address", r.Type, fqdn(r.Name, r.Domain), v, familyName(ipNetwork(r.Type)))
+		}
+	}
+	return nil
+}
+
+// applyPlanned reconciles one planned record, listing each name once.
+func applyPlanned(ctx context.Context, cfg Config, value string, listed map[string][]DomainRecord, res *runResult) error {
+	if m, ok := inMaintenance(cfg, res.key()); ok {
+		logm(msgRecordMaint, cfg.Type, cfg.Name, cfg.Domain, m.Target, m.Since.Format(time.RFC3339))
+		res.Action = "maintenance"
+		return nil
+	}
+	if p, ok := isPaused(cfg); ok {
+		reason := ""
+		if p.Reason != "" {
+			reason = ": " + p.Reason
+		}
+		logm(msgRecordPaused, cfg.Type, cfg.Name, cfg.Domain, p.Since.Format(time.RFC3339), reason)
+		res.Action = "paused"
+		return nil
+	}
+	lk := cfg.Domain + "/" + cfg.Name
+	recs, ok := listed[lk]
+	if !ok {
+		var err error
+		if recs, err = listRecords(ctx, cfg, cfg.Name); err != nil {
+			return withExitCode(4, fmt.Errorf("listing records: %w", err))
+		}
+		listed[lk] = recs
+	}
+	if cfg.Type == "AAAA" && strings.Contains(value, ",") {
+		return reconcileSet(ctx, cfg, recs, strings.Split(value, ","), res)
+	}
+	return reconcile(ctx, cfg, recs, value, res)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writePlan signs p with key and writes it to a file in dir.
+func writePlan(t *testing.T, dir string, key []byte, p plan) string {
+	t.Helper()
+	body, _ := json.Marshal(p)
+	b, _ := json.Marshal(signedPlan{Plan: body, Signature: planMAC(key, body)})
+	path := filepath.Join(dir, "plan.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyLastPlan(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte("0123456789abcdef0123")
+	keyFile := filepath.Join(dir, "plan.key")
+	if err := os.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	p := plan{RunID: "r-1", Created: now, Expires: now.Add(time.Minute), Records: []plannedRecord{
+		{Domain: "example.com", Name: "hq", Type: "A", Value: "203.0.113.7"},
+	}}
+	path := writePlan(t, dir, key, p)
+
+	var down atomic.Bool
+	z := &zone{}
+	fakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, `{"id":"forbidden","message":"nope"}`, http.StatusForbidden)
+			return
+		}
+		z.ServeHTTP(w, r)
+	}))
+	apply := func(extra ...string) error {
+		args := append([]string{"--token", "test", "--state-dir", dir, "--max-retries", "1", "--plan-key", keyFile}, extra...)
+		return runApply(append(args, path))
+	}
+	lastPlan := func() time.Time {
+		db, err := viewStateDB(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db.LastPlan
+	}
+
+	// No allow-list.
+	if err := apply(); exitCode(err) != 2 || !strings.Contains(err.Error(), "--allow-any") {
+		t.Fatalf("apply without --config = %v, want exit code 2", err)
+	}
+
+	// Every record fails: the plan is not used up.
+	down.Store(true)
+	if err := apply("--allow-any"); err == nil {
+		t.Fatal("apply with the API down succeeded")
+	}
+	if got := lastPlan(); !got.IsZero() {
+		t.Fatalf("last plan = %s after nothing was applied", got)
+	}
+
+	// The retry applies it, and then it cannot be replayed.
+	down.Store(false)
+	if err := apply("--allow-any"); err != nil {
+		t.Fatalf("retrying the plan: %v", err)
+	}
+	if got := lastPlan(); !got.Equal(now) {
+		t.Errorf("last plan = %s, want %s", got, now)
+	}
+	z.mu.Lock()
+	n := len(z.records)
+	z.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d record(s) in the zone, want 1", n)
+	}
+	if err := apply("--allow-any"); err == nil || !strings.Contains(err.Error(), "not newer than the last applied plan") {
+		t.Errorf("replaying the plan = %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// exchange is one recorded HTTP response, stored as a JSON fixture file.
+// URL is scheme://host/path, or just /path to match any host; every Query
+// entry must be present in the request. A JSON body is kept in JSON for
+// readability, anything else (e.g. an IP source's answer) in Body.
+type exchange struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Query  map[string]string `json:"query,omitempty"`
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	JSON   json.RawMessage   `json:"json,omitempty"`
+	Body   string            `json:"body,omitempty"`
+}
+
+// transportHook, if set, wraps the transport of every HTTP client the tool
+// uses (API, IP sources, health checks, notifications). It is set by
+// --record and --replay before anything is sent.
+var transportHook func(http.RoundTripper) http.RoundTripper
+
+func wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if transportHook == nil {
+		return rt
+	}
+	return transportHook(rt)
+}
+
+// recordedHeaders are the response headers worth keeping in a fixture.
+var recordedHeaders = []string{"Content-Type", "Retry-After", "Ratelimit-Limit", "Ratelimit-Remaining", "Ratelimit-Reset"}
+
+// recorder saves every response to dir as numbered fixture files.
+type recorder struct {
+	next http.RoundTripper
+	dir  string
+	mu   *sync.Mutex
+	n    *int
+}
+
+func enableRecording(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	mu, n := &sync.Mutex{}, new(int)
+	transportHook = func(rt http.RoundTripper) http.RoundTripper {
+		return recorder{next: rt, dir: dir, mu: mu, n: n}
+	}
+	http.DefaultClient.Transport = wrapTransport(http.DefaultTransport)
+	return nil
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+func (r recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	ex := exchange{Method: req.Method, URL: req.URL.Scheme + "://" + req.URL.Host + req.URL.Path, Status: resp.StatusCode}
+	for k, v := range req.URL.Query() {
+		if ex.Query == nil {
+			ex.Query = map[string]string{}
+		}
+		ex.Query[k] = v[0]
+	}
+	for _, h := range recordedHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			if ex.Header == nil {
+				ex.Header = map[string]string{}
+			}
+			ex.Header[h] = v
+		}
+	}
+	if json.Valid(body) && len(bytes.TrimSpace(body)) > 0 {
+		ex.JSON = body
+	} else {
+		ex.Body = string(body)
+	}
+	b, _ := json.MarshalIndent(ex, "", "  ")
+	if ex.JSON != nil {
+		// Re-indent the embedded body too.
+		var out bytes.Buffer
+		if json.Indent(&out, b, "", "  ") == nil {
+			b = out.Bytes()
+		}
+	}
+
+	r.mu.Lock()
+	*r.n++
+	name := fmt.Sprintf("%03d-%s%s.json", *r.n, req.Method, unsafePathChars.ReplaceAllString(req.URL.Path, "_"))
+	r.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(r.dir, name), append(b, '\n'), 0600); err != nil {
+		warnf("%s", msg(msgRecordingFailed, name, err))
+	}
+	return resp, nil
+}
+
+// replayer answers requests from fixtures instead of the network. Fixtures
+// matching the same request are served in file order, the last one
+// repeating. Writes without a fixture are simulated as successful so a
+// run can complete; other unmatched requests get a 404.
+type replayer struct {
+	mu        sync.Mutex
+	exchanges []*exchange
+	used      map[*exchange]bool
+	nextID    int64
+}
+
+func enableReplay(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("replay: no *.json fixtures in %s", dir)
+	}
+	sort.Strings(paths)
+	rp := &replayer{used: map[*exchange]bool{}, nextID: 900000}
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var ex exchange
+		if err := json.Unmarshal(b, &ex); err != nil {
+			return fmt.Errorf("replay: %s: %w", p, err)
+		}
+		if ex.Method == "" {
+			ex.Method = "GET"
+		}
+		if ex.Status == 0 {
+			ex.Status = 200
+		}
+		rp.exchanges = append(rp.exchanges, &ex)
+	}
+	transportHook = func(http.RoundTripper) http.RoundTripper { return rp }
+	http.DefaultClient.Transport = rp
+	logm(msgReplayStart, len(rp.exchanges), dir)
+	return nil
+}
+
+func (ex *exchange) matches(req *http.Request) bool {
+	if !strings.EqualFold(ex.Method, req.Method) {
+		return false
+	}
+	u, err := url.Parse(ex.URL)
+	if err != nil || cmp.Or(u.Path, "/") != cmp.Or(req.URL.Path, "/") {
+		return false
+	}
+	if u.Host != "" && u.Host != req.URL.Host {
+		return false
+	}
+	q := req.URL.Query()
+	for k, v := range ex.Query {
+		if q.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (rp *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	var last *exchange
+	for _, ex := range rp.exchanges {
+		if !ex.matches(req) {
+			continue
+		}
+		last = ex
+		if !rp.used[ex] {
+			rp.used[ex] = true
+			return ex.response(req), nil
+		}
+	}
+	if last != nil {
+		return last.response(req), nil
+	}
+
+	if req.Method == "GET" {
+		warnf("%s", msg(msgReplayNoFixture, req.URL))
+		return jsonResponse(req, 404, map[string]string{"id": "not_found", "message": "no replay fixture for GET " + req.URL.Path}), nil
+	}
+	return rp.simulate(req), nil
+}
+
+// simulate fakes a successful write, shaped like DigitalOcean's answer for
+// record endpoints.
+func (rp *replayer) simulate(req *http.Request) *http.Response {
+	logm(msgReplaySimulated, req.Method, req.URL.Path)
+	if !strings.Contains(req.URL.Path, "/records") {
+		return jsonResponse(req, 200, map[string]any{})
+	}
+	var rec map[string]any
+	if req.Body != nil {
+		json.NewDecoder(req.Body).Decode(&rec)
+	}
+	if rec == nil {
+		rec = map[string]any{}
+	}
+	switch req.Method {
+	case "POST":
+		rp.nextID++
+		rec["id"] = rp.nextID
+		return jsonResponse(req, 201, map[string]any{"domain_record": rec})
+	case "DELETE":
+		return jsonResponse(req, 204, nil)
+	}
+	if id := filepath.Base(req.URL.Path); id != "" {
+		var n int64
+		fmt.Sscan(id, &n)
+		rec["id"] = n
+	}
+	return jsonResponse(req, 200, map[string]any{"domain_record": rec})
+}
+
+func (ex *exchange) response(req *http.Request) *http.Response {
+	body := []byte(ex.Body)
+	if ex.JSON != nil {
+		body = ex.JSON
+	}
+	resp := &http.Response{
+		StatusCode: ex.Status,
+		Status:     fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+	for k, v := range ex.Header {
+		resp.Header.Set(k, v)
+	}
+	if ex.JSON != nil && resp.Header.Get("Content-Type") == "" {
+		resp.Header.Set("Content-Type", "application/json")
+	}
+	return resp
+}
+
+func jsonResponse(req *http.Request, status int, v any) *http.Response {
+	var body []byte
+	if v != nil {
+		body, _ = json.Marshal(v)
+	}
+	return (&exchange{Status: status, JSON: body}).response(req)
+}
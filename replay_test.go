@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// requestLog records the requests sent through it.
+type requestLog struct {
+	rt http.RoundTripper
+
+	mu   sync.Mutex
+	reqs []string
+}
+
+func (l *requestLog) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.mu.Lock()
+	l.reqs = append(l.reqs, req.Method+" "+req.URL.RequestURI())
+	l.mu.Unlock()
+	return l.rt.RoundTrip(req)
+}
+
+// replay serves every HTTP request of the test from the fixtures in dir,
+// and returns the log of the API requests made.
+func replay(t *testing.T, dir string) *requestLog {
+	t.Helper()
+	prev := http.DefaultClient.Transport
+	resetIPClients := func() {
+		ipClientsMu.Lock()
+		clear(ipClients)
+		ipClientsMu.Unlock()
+	}
+	t.Cleanup(func() {
+		transportHook = nil
+		http.DefaultClient.Transport = prev
+		resetIPClients()
+	})
+	resetIPClients()
+	if err := enableReplay(dir); err != nil {
+		t.Fatal(err)
+	}
+	log := &requestLog{rt: http.DefaultClient.Transport}
+	http.DefaultClient.Transport = log
+	return log
+}
+
+// TestReplayDuplicatesPaginated runs the updater against the
+// duplicates-paginated fixtures: three A records for hq spread over two
+// pages, none holding the current IP. The lowest ID is kept and updated,
+// the other two are deleted.
+func TestReplayDuplicatesPaginated(t *testing.T) {
+	log := replay(t, "testdata/replay/duplicates-paginated")
+	cfg := testConfig()
+	cfg.Name, cfg.Type, cfg.Types = "hq", "A", []string{"A"}
+	cfg.IPSource, cfg.TTL, cfg.PerPage = "https://api64.ipify.org/", 300, 2
+	cfg.CleanupDuplicates, cfg.StateDir = true, t.TempDir()
+
+	results, err := runAll(context.Background(), []Config{cfg}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("%d results, want 1", len(results))
+	}
+	res := results[0]
+	if res.Action != "updated" || res.ID != 2 || res.IP != "203.0.113.7" {
+		t.Errorf("result: action %q id %d value %q, want updated 2 203.0.113.7", res.Action, res.ID, res.IP)
+	}
+	var deleted []int64
+	for _, d := range res.Deleted {
+		deleted = append(deleted, d.ID)
+	}
+	slices.Sort(deleted)
+	if !slices.Equal(deleted, []int64{3, 4}) {
+		t.Errorf("deleted %v, want [3 4]", deleted)
+	}
+
+	var writes []string
+	pages := 0
+	for _, r := range log.reqs {
+		method, uri, _ := strings.Cut(r, " ")
+		path, _, _ := strings.Cut(uri, "?")
+		switch {
+		case method != "GET":
+			writes = append(writes, r)
+		case path == "/v2/domains/example.com/records":
+			pages++
+		}
+	}
+	slices.Sort(writes)
+	want := []string{
+		"DELETE /v2/domains/example.com/records/3",
+		"DELETE /v2/domains/example.com/records/4",
+		"PUT /v2/domains/example.com/records/2",
+	}
+	if !slices.Equal(writes, want) {
+		t.Errorf("writes %q, want %q", writes, want)
+	}
+	// The duplicate on page 2 was found by following links.pages.next.
+	if pages != 2 {
+		t.Errorf("listed %d page(s), want 2: %q", pages, log.reqs)
+	}
+}
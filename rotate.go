@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+)
+
+// rotationConfig spreads a single record over several addresses: each run
+// publishes one healthy target, chosen by Strategy.
+type rotationConfig struct {
+	Strategy string           `json:"strategy,omitempty"` // round-robin (default), random, weighted
+	Targets  []rotationTarget `json:"targets"`
+}
+
+type rotationTarget struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"` // weighted strategy only; default 1
+	Check   string `json:"check,omitempty"`  // see probe
+}
+
+// rotationState remembers the last address round-robin published.
+type rotationState struct {
+	Key  string `json:"key"`
+	Last string `json:"last"`
+}
+
+func (rc *rotationConfig) validate() error {
+	switch rc.Strategy {
+	case "", "round-robin", "random", "weighted":
+	default:
+		return fmt.Errorf("rotate: unknown strategy %q (want round-robin, random or weighted)", rc.Strategy)
+	}
+	if len(rc.Targets) == 0 {
+		return errors.New("rotate: no targets")
+	}
+	for _, t := range rc.Targets {
+		if net.ParseIP(t.Address) == nil {
+			return fmt.Errorf("rotate: %q is not an IP address", t.Address)
+		}
+		if t.Weight < 0 {
+			return fmt.Errorf("rotate: negative weight for %s", t.Address)
+		}
+		if err := validateCheck(t.Check); err != nil {
+			return fmt.Errorf("rotate: %s: %w", t.Address, err)
+		}
+	}
+	return nil
+}
+
+// pickRotation health-checks the targets of cfg's family and picks the
+// address to publish this run. Failed targets are only warnings when none
+// is left.
+func pickRotation(ctx context.Context, cfg Config) (string, error) {
+	var cands []rotationTarget
+	for _, t := range cfg.Rotate.Targets {
+		ip := net.ParseIP(t.Address)
+		if (cfg.Type == "A") == (ip.To4() != nil) {
+			t.Address = ip.String()
+			cands = append(cands, t)
+		}
+	}
+	if len(cands) == 0 {
+		return "", fmt.Errorf("rotate: no %s targets", cfg.Type)
+	}
+
+	checks := make([]string, len(cands))
+	for i, t := range cands {
+		checks[i] = t.Check
+	}
+	var healthy []rotationTarget
+	var down []string
+	for i, err := range probeAll(ctx, checks) {
+		if err != nil {
+			down = append(down, msg(msgRotationTargetOff, cands[i].Address, err))
+			continue
+		}
+		healthy = append(healthy, cands[i])
+	}
+	report := logf
+	if len(healthy) == 0 {
+		report = warnf
+	}
+	for _, m := range down {
+		report("%s", m)
+	}
+	if len(healthy) == 0 {
+		return "", fmt.Errorf("rotate: all %d %s targets failed their health check", len(cands), cfg.Type)
+	}
+
+	switch cfg.Rotate.Strategy {
+	case "random":
+		return healthy[rand.N(len(healthy))].Address, nil
+	case "weighted":
+		return pickWeighted(healthy), nil
+	default:
+		return pickRoundRobin(cfg, healthy)
+	}
+}
+
+func pickWeighted(ts []rotationTarget) string {
+	total := 0
+	for _, t := range ts {
+		total += max(t.Weight, 1)
+	}
+	n := rand.N(total)
+	for _, t := range ts {
+		if n -= max(t.Weight, 1); n < 0 {
+			return t.Address
+		}
+	}
+	return ts[len(ts)-1].Address
+}
+
+// pickRoundRobin publishes the healthy target after the one published last
+// time, so removing or reviving a target does not reset the rotation.
+func pickRoundRobin(cfg Config, healthy []rotationTarget) (string, error) {
+	key := recordKey(cfg.Domain, cfg.Name, cfg.Type)
+	var next string
+	err := updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		idx := -1
+		for i, r := range db.Rotation {
+			if r.Key == key {
+				idx = i
+			}
+		}
+		next = healthy[0].Address
+		if idx >= 0 {
+			last := db.Rotation[idx].Last
+			for i, t := range healthy {
+				if t.Address == last {
+					next = healthy[(i+1)%len(healthy)].Address
+				}
+			}
+			changed := db.Rotation[idx].Last != next
+			db.Rotation[idx].Last = next
+			return changed
+		}
+		db.Rotation = append(db.Rotation, rotationState{Key: key, Last: next})
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("rotate: saving state: %w", err)
+	}
+	return next, nil
+}
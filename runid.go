@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// currentRunID identifies the run in progress: the process for one-shot
+// runs and subcommands, each check cycle in daemon mode. It is stamped on
+// every log line, outbound request and notification so the output of one
+// run can be correlated across systems.
+var currentRunID atomic.Pointer[string]
+
+func runID() string {
+	if id := currentRunID.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// startRun assigns a fresh run ID and returns it.
+func startRun() string {
+	id := newUUID()
+	currentRunID.Store(&id)
+	return id
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	// Since Go 1.24 (go.mod requires 1.25) rand.Read never returns an error:
+	// it crashes the program if the OS cannot supply random bytes.
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// setRequestHeaders identifies do-ddns and the current run on an outbound
+// request.
+func setRequestHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", "do-ddns/"+version)
+	if id := runID(); id != "" {
+		req.Header.Set("X-Request-Id", id)
+	}
+}
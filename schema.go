@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// configSchema returns a JSON Schema (draft 2020-12) for the --config file.
+// It is derived from fileConfig so it cannot drift from what the loader
+// accepts: fields without omitempty are required, and like the loader the
+// schema rejects unknown keys.
+func configSchema() map[string]any {
+	s := schemaFor(reflect.TypeFor[fileConfig](), "")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "do-ddns config file"
+	return s
+}
+
+// schemaDocs describes config keys, by dotted path ("records" items are
+// addressed as "records.name").
+var schemaDocs = map[string]string{
+	"token":                          "DigitalOcean API token; defaults to DO_TOKEN",
+	"ip_source":                      "Comma-separated IP detection URLs",
+	"cross_check":                    "IP echo URL(s) that must confirm every detected address before it is published",
+	"state_dir":                      "Directory for the last-IP files and the state DB",
+	"ttl":                            "Default record TTL in seconds",
+	"create_ttl":                     "TTL for records that have to be created; defaults to ttl",
+	"create_priority":                "Priority for MX/SRV records that have to be created",
+	"records.create_ttl":             "TTL for this record if it has to be created",
+	"preserve_ttl":                   "Never change the TTL of existing records",
+	"on_conflict":                    "What to do when a record was changed by something else during the run",
+	"cleanup_duplicates":             "Delete extra records of the same name and type",
+	"group_policy":                   "What a failure in a record group does: degrade (keep the rest, retry next run) or rollback (undo the group's changes)",
+	"records.group":                  "Group of records applied as a unit, e.g. a host's A, AAAA and TXT records",
+	"settle":                         "How long a new IP must be stable before it is published, e.g. 90s",
+	"change_window":                  "Daily local time span (HH:MM-HH:MM) for TTL fixes and duplicate cleanup",
+	"notify.url":                     "Webhook, ntfy or Slack URL called on changes and failures",
+	"notify.kind":                    "Notification format; guessed from the URL if omitted",
+	"records":                        "Managed records",
+	"networks":                       "Named network profiles, matched by default gateway MAC or Wi-Fi SSID",
+	"networks.gateway_mac":           "MAC address(es) of the network's default gateway",
+	"networks.ssid":                  "Wi-Fi network name(s)",
+	"records.networks":               "Only update the record while on one of these network profiles",
+	"records.domain":                 "Zone in DigitalOcean, e.g. example.com",
+	"records.name":                   "Record name relative to the domain; @ for the apex",
+	"records.type":                   "Record type(s), a list or a comma-separated string; default A",
+	"records.data":                   "Static value for non-address records",
+	"records.data_from":              "URL or file to read the record value from on every run, e.g. one written by another agent",
+	"records.expires_in":             "Delete the record this long after it was created, e.g. 2h",
+	"records.targets":                "Named targets for `do-ddns switch`: IPs and/or IP source URLs",
+	"records.default_target":         "Target published until the first switch",
+	"records.rotate":                 "Publish one of several addresses per run",
+	"records.rotate.strategy":        "How the next address is chosen",
+	"records.rotate.targets":         "Addresses in rotation",
+	"records.rotate.targets.check":   "tcp://host:port or an http(s) URL; failing targets leave rotation",
+	"records.addresses":              "Publish several of the host's IPv6 addresses, one AAAA record each",
+	"records.addresses.interface":    "Interface the addresses are read from",
+	"records.addresses.prefix":       "Only publish addresses inside these prefixes",
+	"records.addresses.count":        "Publish at most this many addresses; 0 for all",
+	"records.failover":               "Publish the first healthy uplink of a multi-WAN site",
+	"records.failover.check":         "Default health probe for every uplink",
+	"records.failover.uplinks":       "Uplinks in order of preference",
+	"records.failover.uplinks.bind":  "Local address or interface name the uplink's traffic is sent from",
+	"records.failover.uplinks.check": "Health probe sent through the uplink",
+}
+
+// schemaEnums lists the allowed values of enumerated keys.
+var schemaEnums = map[string][]string{
+	"notify.kind":             {"webhook", "ntfy", "slack"},
+	"on_conflict":             {"retry", "abort", "overwrite"},
+	"group_policy":            {"degrade", "rollback"},
+	"records.on_conflict":     {"retry", "abort", "overwrite"},
+	"records.rotate.strategy": {"round-robin", "random", "weighted"},
+}
+
+var (
+	stringListType = reflect.TypeFor[stringList]()
+	durationType   = reflect.TypeFor[duration]()
+)
+
+func schemaFor(t reflect.Type, path string) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var s map[string]any
+	switch {
+	case t == stringListType:
+		s = map[string]any{"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		}}
+	case t == durationType:
+		s = map[string]any{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+	default:
+		switch t.Kind() {
+		case reflect.String:
+			s = map[string]any{"type": "string"}
+		case reflect.Bool:
+			s = map[string]any{"type": "boolean"}
+		case reflect.Int, reflect.Int64:
+			s = map[string]any{"type": "integer", "minimum": 0}
+		case reflect.Slice:
+			s = map[string]any{"type": "array", "items": elemSchema(t.Elem(), path)}
+		case reflect.Map:
+			s = map[string]any{"type": "object", "additionalProperties": elemSchema(t.Elem(), path)}
+		case reflect.Struct:
+			s = structSchema(t, path)
+		default:
+			s = map[string]any{}
+		}
+	}
+	if d, ok := schemaDocs[path]; ok {
+		s["description"] = d
+	}
+	if e, ok := schemaEnums[path]; ok {
+		s["enum"] = e
+	}
+	return s
+}
+
+// elemSchema is the schema of a list item or map value. Its fields are
+// addressed through the parent's path, but the parent's description is not
+// repeated on it.
+func elemSchema(t reflect.Type, path string) map[string]any {
+	s := schemaFor(t, path)
+	delete(s, "description")
+	return s
+}
+
+func structSchema(t reflect.Type, path string) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		sub := name
+		if path != "" {
+			sub = path + "." + name
+		}
+		props[name] = schemaFor(f.Type, sub)
+		if opts == "" {
+			required = append(required, name)
+		}
+	}
+	s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
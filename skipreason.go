@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Skip reasons tell why a record check made no change, so a healthy idle
+// updater can be told apart from one that never does anything because of
+// its configuration. They appear as skip_reason in the --summary JSON and on
+// /status, and as counters on the daemon's /metrics.
+const (
+	skipUnchangedState    = "unchanged-state"    // the daemon already published the value; no API call
+	skipUnchangedUpstream = "unchanged-upstream" // DigitalOcean already holds the value
+	skipPaused            = "paused"
+	skipCooldown          = "cooldown" // a request gave up during API maintenance; waiting before calling again
+	skipMaintenance       = "maintenance"
+	skipChangeWindow      = "change-window" // a change is waiting for the change window
+	skipOffNetwork        = "off-network"
+	skipExpired           = "expired"
+	skipAborted           = "aborted" // --fail-fast, or the record it depends on failed
+)
+
+// skipReason returns why r made no change, or "" if it changed something or
+// failed.
+func (r runResult) skipReason() string {
+	if r.Err != nil {
+		return ""
+	}
+	switch r.Action {
+	case "skipped":
+		return skipUnchangedState
+	case "unchanged":
+		if len(r.Deferred) > 0 {
+			return skipChangeWindow
+		}
+		if len(r.Deleted) > 0 {
+			return ""
+		}
+		return skipUnchangedUpstream
+	case "paused":
+		return skipPaused
+	case "cooldown":
+		return skipCooldown
+	case "maintenance":
+		return skipMaintenance
+	case "off-network":
+		return skipOffNetwork
+	case "expired":
+		return skipExpired
+	case "aborted":
+		return skipAborted
+	}
+	return ""
+}
+
+// resultName is r's outcome in the result counter: its action, or "error".
+func (r runResult) resultName() string {
+	if r.Err != nil {
+		return "error"
+	}
+	return r.Action
+}
+
+// recordCounter counts outcomes per record and label value.
+type recordCounter map[string]map[string]int64 // recordKey -> label -> count
+
+func (c recordCounter) add(key, label string) {
+	if c[key] == nil {
+		c[key] = map[string]int64{}
+	}
+	c[key][label]++
+}
+
+// writeMetrics writes the daemon's counters in the Prometheus text format.
+func (s *daemonStatus) writeMetrics(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP do_ddns_checks_total Check cycles run by the daemon.\n# TYPE do_ddns_checks_total counter\ndo_ddns_checks_total %d\n", s.checks)
+	fmt.Fprintf(w, "# HELP do_ddns_check_failures_total Check cycles that failed.\n# TYPE do_ddns_check_failures_total counter\ndo_ddns_check_failures_total %d\n", s.checkFailures)
+	s.writeCounter(w, "do_ddns_record_results_total", "Record checks by result (action or error).", "result", s.results)
+	s.writeCounter(w, "do_ddns_record_skips_total", "Record checks that made no change, by reason.", "reason", s.skips)
+}
+
+func (s *daemonStatus) writeCounter(w http.ResponseWriter, name, help, label string, c recordCounter) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, key := range slices.Sorted(maps.Keys(c)) {
+		rs := s.records[key]
+		if rs == nil {
+			continue
+		}
+		for _, v := range slices.Sorted(maps.Keys(c[key])) {
+			fmt.Fprintf(w, "%s{domain=%s,name=%s,type=%s,%s=%s} %d\n", name,
+				promLabel(rs.Domain), promLabel(rs.Name), promLabel(rs.Type), label, promLabel(v), c[key][v])
+		}
+	}
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(v string) string {
+	return `"` + promEscaper.Replace(v) + `"`
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateDB is the structured state kept next to the .last_ip files, for
+// things that outlive a single run.
+type stateDB struct {
+	Expiring      []expiringRecord   `json:"expiring,omitempty"`
+	Maintenance   []maintenanceEntry `json:"maintenance,omitempty"`
+	Paused        []pausedRecord     `json:"paused,omitempty"`
+	ActiveTargets []activeTarget     `json:"active_targets,omitempty"`
+	Rotation      []rotationState    `json:"rotation,omitempty"`
+	LastAPIError  *apiErrorRecord    `json:"last_api_error,omitempty"`
+	// Groups are the config groups that are not complete, by name.
+	Groups map[string]*groupState `json:"groups,omitempty"`
+	// APIMaintenance is the DigitalOcean API maintenance in progress.
+	APIMaintenance *apiMaintenance `json:"api_maintenance,omitempty"`
+	LastPlan       time.Time       `json:"last_plan,omitzero"` // Created of the last plan applied
+	// LastIP holds the last-IP files' values, by file name, for state
+	// formats that do not use the text files.
+	LastIP map[string]string `json:"last_ip,omitempty"`
+}
+
+// stateCodec is an encoding for the state DB and audit snapshots.
+type stateCodec struct {
+	name      string
+	ext       string
+	marshal   func(v any) ([]byte, error)
+	unmarshal func(b []byte, v any) error
+	// plainLastIP keeps last IPs in the per-record .last_ip text files;
+	// otherwise they are stored in the state DB, so a run rewrites at most
+	// one file.
+	plainLastIP bool
+}
+
+var stateCodecs = []stateCodec{
+	{name: "json", ext: ".json", marshal: marshalIndentJSON, unmarshal: json.Unmarshal, plainLastIP: true},
+	{name: "cbor", ext: ".cbor", marshal: cborMarshal, unmarshal: cborUnmarshal},
+}
+
+func marshalIndentJSON(v any) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	return append(b, '\n'), err
+}
+
+// stateFormat is the codec state is written in (--state-format,
+// DO_STATE_FORMAT). State in any format is read, so changing it migrates
+// the existing state on the next write.
+var stateFormat = stateCodecs[0]
+
+func setStateFormat(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil
+	}
+	var names []string
+	for _, c := range stateCodecs {
+		if c.name == name {
+			stateFormat = c
+			return nil
+		}
+		names = append(names, c.name)
+	}
+	return fmt.Errorf("unknown state format %q (use %s)", name, strings.Join(names, " or "))
+}
+
+func stateDBPathFor(stateDir string, c stateCodec) string {
+	return filepath.Join(stateDir, "do-ddns.state"+c.ext)
+}
+
+func stateDBPath(stateDir string) string {
+	return stateDBPathFor(stateDir, stateFormat)
+}
+
+// stateMu serialises read-modify-write cycles of the state DB between the
+// records of one process.
+var stateMu sync.Mutex
+
+// loadStateDB reads the state DB in the current format, or else in any
+// other one.
+func loadStateDB(stateDir string) (*stateDB, error) {
+	var db stateDB
+	for _, c := range append([]stateCodec{stateFormat}, stateCodecs...) {
+		path := stateDBPathFor(stateDir, c)
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.unmarshal(b, &db); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return &db, nil
+	}
+	return &db, nil
+}
+
+// save writes the state DB in the current format and removes copies in
+// other formats. An unchanged DB is not rewritten.
+func (db *stateDB) save(stateDir string) error {
+	b, err := stateFormat.marshal(db)
+	if err != nil {
+		return err
+	}
+	path := stateDBPath(stateDir)
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, b) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	for _, c := range stateCodecs {
+		if c.name != stateFormat.name {
+			if err := os.Remove(stateDBPathFor(stateDir, c)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// updateStateDB loads the state DB, applies fn and writes it back if fn
+// reports a change.
+func updateStateDB(stateDir string, fn func(db *stateDB) bool) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	db, err := loadStateDB(stateDir)
+	if err != nil {
+		return err
+	}
+	if !fn(db) {
+		return nil
+	}
+	return db.save(stateDir)
+}
+
+// viewStateDB loads the state DB for reading only.
+func viewStateDB(stateDir string) (*stateDB, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return loadStateDB(stateDir)
+}
+
+// runState implements `do-ddns state [FILE]`: print the state DB, or a state
+// or audit snapshot file, as JSON whatever format it was written in.
+func runState(args []string) error {
+	fs := flag.NewFlagSet("state", flag.ExitOnError)
+	stateDir := fs.String("state-dir", envDefault("STATE_DIR", "/tmp"), "State directory (or env STATE_DIR)")
+	fs.Parse(args)
+
+	var v any
+	switch fs.NArg() {
+	case 0:
+		db, err := viewStateDB(*stateDir)
+		if err != nil {
+			return err
+		}
+		v = db
+	case 1:
+		path := fs.Arg(0)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		c := stateCodecs[0]
+		for _, sc := range stateCodecs {
+			if strings.HasSuffix(path, sc.ext) {
+				c = sc
+			}
+		}
+		if err := c.unmarshal(b, &v); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	default:
+		return withExitCode(2, errors.New("usage: do-ddns state [--state-dir DIR] [FILE]"))
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
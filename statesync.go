@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// stateSync holds back last-IP writes in daemon mode when
+// --state-sync-interval is set: the daemon already keeps what it published
+// in memory and only reads the files when it starts (lastPublished), so
+// they can be written every few minutes instead of on every change.
+// Pending values are flushed on the interval and when the daemon stops.
+var stateSync lastIPBuffer
+
+type lastIPBuffer struct {
+	mu      sync.Mutex
+	on      bool
+	pending map[string]string // path -> value; "" clears the record's last IP
+}
+
+func (b *lastIPBuffer) enable() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.on = true
+	if b.pending == nil {
+		b.pending = map[string]string{}
+	}
+}
+
+// hold buffers a write and reports whether it did.
+func (b *lastIPBuffer) hold(path, ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.on {
+		return false
+	}
+	b.pending[path] = ip
+	return true
+}
+
+func (b *lastIPBuffer) lookup(path string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ip, ok := b.pending[path]
+	return ip, ok
+}
+
+// flush writes the pending values out.
+func (b *lastIPBuffer) flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = map[string]string{}
+	b.mu.Unlock()
+	for path, ip := range pending {
+		var err error
+		if ip == "" {
+			err = removeLastIP(path)
+		} else {
+			err = storeLastIP(path, ip)
+		}
+		if err != nil {
+			warnf("%s", msg(msgStateWriteFailed, err))
+		}
+	}
+}
+
+// syncState flushes stateSync and apiUsage every interval until ctx is
+// cancelled and done is closed (the scheduler has written its last result),
+// then once more.
+func syncState(ctx context.Context, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			stateSync.flush()
+			apiUsage.flush()
+		case <-ctx.Done():
+			<-done
+			stateSync.flush()
+			apiUsage.flush()
+			return
+		}
+	}
+}
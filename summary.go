@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// runSummary is the machine-readable outcome of a one-shot run, written by
+// --summary for orchestration tools.
+type runSummary struct {
+	RunID    string         `json:"run_id"`
+	Outcome  string         `json:"outcome"` // success, partial or failure
+	ExitCode int            `json:"exit_code"`
+	Warnings int64          `json:"warnings"`
+	Error    string         `json:"error,omitempty"`
+	Records  []notifyRecord `json:"records"`
+}
+
+// partialExitCode is returned when some records failed and others did not.
+const partialExitCode = 9
+
+// runOutcome classifies a finished run: err is nil for total success, carries
+// partialExitCode if at least one record succeeded, and is returned
+// unchanged (with its specific exit code) if every record failed.
+func runOutcome(results []runResult, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, r := range results {
+		if r.Err == nil && r.Action != "aborted" && r.Action != "rolled-back" {
+			return withExitCode(partialExitCode, err)
+		}
+	}
+	return err
+}
+
+func outcomeName(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case exitCode(err) == partialExitCode:
+		return "partial"
+	}
+	return "failure"
+}
+
+// writeSummary writes the run's summary as JSON to path, or stdout for "-".
+func writeSummary(path string, results []runResult, err error) error {
+	s := runSummary{RunID: runID(), Outcome: outcomeName(err), Warnings: warnings.Load(), Records: []notifyRecord{}}
+	if err != nil {
+		s.ExitCode = exitCode(err)
+		s.Error = err.Error()
+	}
+	for _, r := range results {
+		nr := notifyRecord{Domain: r.Domain, Name: r.Name, Type: r.Type, Value: r.IP, Action: r.Action, ID: r.ID, Deleted: r.Deleted, Snapshot: r.Snapshot, Deferred: r.Deferred, Note: r.Note, Owner: r.Owner, Group: r.Group, SkipReason: r.skipReason(), Why: r.Why}
+		if r.Err != nil {
+			nr.Error = r.Err.Error()
+		}
+		s.Records = append(s.Records, nr)
+	}
+	b, _ := json.MarshalIndent(s, "", "  ")
+	b = append(b, '\n')
+	if path == "-" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// supervisor runs the long-lived goroutines of a service (scheduler, HTTP
+// listeners, ...) as one unit: the first one to fail or panic cancels the
+// others' context, and Wait returns only once all of them have exited, so
+// none is left behind holding a lock or a socket.
+type supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func newSupervisor(parent context.Context) (*supervisor, context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	return &supervisor{ctx: ctx, cancel: cancel}, ctx
+}
+
+// Go starts fn under supervision. A non-nil error or a panic stops the
+// group; returning nil just ends this goroutine.
+func (s *supervisor) Go(name string, fn func(ctx context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				logf("ERROR: %s", msg(msgPanicked, name, r, debug.Stack()))
+				s.fail(fmt.Errorf("%s: panic: %v", name, r))
+			}
+		}()
+		if err := fn(s.ctx); err != nil {
+			s.fail(fmt.Errorf("%s: %w", name, err))
+		}
+	}()
+}
+
+func (s *supervisor) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// Wait blocks until every goroutine has returned and reports the first
+// failure, if any.
+func (s *supervisor) Wait() error {
+	s.wg.Wait()
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
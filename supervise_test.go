@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sibling waits for its context to be cancelled and reports it on done.
+func sibling(done chan<- string, name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		<-ctx.Done()
+		done <- name
+		return nil
+	}
+}
+
+func TestSupervisorStopsSiblings(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name    string
+		fn      func(ctx context.Context) error
+		wantErr string
+	}{
+		{name: "error", fn: func(context.Context) error { return boom }, wantErr: "failing: boom"},
+		{name: "panic", fn: func(context.Context) error { panic("kaboom") }, wantErr: "failing: panic: kaboom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sup, _ := newSupervisor(context.Background())
+			done := make(chan string, 2)
+			sup.Go("a", sibling(done, "a"))
+			sup.Go("b", sibling(done, "b"))
+			sup.Go("failing", tt.fn)
+
+			waited := make(chan error, 1)
+			go func() { waited <- sup.Wait() }()
+			select {
+			case err := <-waited:
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Wait() = %v, want %q", err, tt.wantErr)
+				}
+				if tt.name == "error" && !errors.Is(err, boom) {
+					t.Errorf("Wait() = %v, does not wrap the child's error", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Wait did not return after a child failed")
+			}
+			// Wait only returns once the siblings have exited.
+			if len(done) != 2 {
+				t.Errorf("%d of 2 siblings exited before Wait returned", len(done))
+			}
+		})
+	}
+}
+
+func TestSupervisorFirstErrorWins(t *testing.T) {
+	sup, _ := newSupervisor(context.Background())
+	first := make(chan struct{})
+	sup.Go("first", func(context.Context) error {
+		defer close(first)
+		return errors.New("first")
+	})
+	sup.Go("second", func(ctx context.Context) error {
+		<-first
+		<-ctx.Done()
+		return errors.New("second")
+	})
+	if err := sup.Wait(); err == nil || !strings.HasPrefix(err.Error(), "first:") {
+		t.Errorf("Wait() = %v, want the first failure", err)
+	}
+}
+
+func TestSupervisorNilReturnKeepsOthers(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	sup, _ := newSupervisor(parent)
+	finished := make(chan struct{})
+	sup.Go("short-lived", func(context.Context) error {
+		defer close(finished)
+		return nil
+	})
+	stillRunning := make(chan error, 1)
+	sup.Go("long-lived", func(ctx context.Context) error {
+		<-finished
+		select {
+		case <-ctx.Done():
+			stillRunning <- errors.New("cancelled by a sibling that returned nil")
+		case <-time.After(50 * time.Millisecond):
+			stillRunning <- nil
+		}
+		<-ctx.Done()
+		return nil
+	})
+	if err := <-stillRunning; err != nil {
+		t.Error(err)
+	}
+	cancel()
+	if err := sup.Wait(); err != nil {
+		t.Errorf("Wait() = %v after the parent was cancelled, want nil", err)
+	}
+}
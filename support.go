@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiErrorRecord is the last failed DigitalOcean API call, kept in the state
+// DB for support bundles.
+type apiErrorRecord struct {
+	Time    time.Time `json:"time"`
+	RunID   string    `json:"run_id,omitempty"` // sent as X-Request-Id
+	Request string    `json:"request"`
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error"`
+	// ResponseID is DigitalOcean's own request ID, if the response had one.
+	ResponseID string `json:"response_id,omitempty"`
+}
+
+// recordAPIError saves a failed API call as the state DB's last_api_error.
+// Calls made without a state directory (e.g. `do-ddns api`) are not kept.
+func recordAPIError(cfg Config, method, rawURL string, status int, hdr http.Header, err error) {
+	if cfg.StateDir == "" {
+		return
+	}
+	rec := &apiErrorRecord{
+		Time:    time.Now(),
+		RunID:   runID(),
+		Request: method + " " + urlPath(rawURL),
+		Status:  status,
+		Error:   err.Error(),
+	}
+	if hdr != nil {
+		rec.ResponseID = hdr.Get("X-Request-Id")
+	}
+	if werr := updateStateDB(cfg.StateDir, func(db *stateDB) bool {
+		db.LastAPIError = rec
+		return true
+	}); werr != nil {
+		warnf("%s", msg(msgStateWriteFailed, werr))
+	}
+}
+
+// runSupportBundle implements `do-ddns support-bundle`: a redacted archive of
+// everything useful in a bug report.
+func runSupportBundle(args []string) error {
+	fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	base := recordFlags(fs)
+	configPath := new(string)
+	configFlag(fs, configPath, "Config file to include, redacted (or env DO_CONFIG)")
+	out := fs.String("out", "", "Archive to write (default do-ddns-support-<time>.tar.gz)")
+	unit := fs.String("unit", "do-ddns*", "systemd unit(s) whose journal is included")
+	logFile := fs.String("log-file", "", "Log file to include instead of the systemd journal")
+	logLines := fs.Int("log-lines", 500, "Number of recent log lines to include")
+	doh := fs.String("doh", os.Getenv("DO_DOH"), "Also look records up over DNS-over-HTTPS: cloudflare, google or an https:// URL (or env DO_DOH)")
+	fs.Parse(args)
+
+	if *out == "" {
+		*out = "do-ddns-support-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	}
+
+	red := newRedactor(base.Token)
+	var records []Config
+	var files bundle
+	if *configPath != "" {
+		fc, err := loadConfigFile(*configPath)
+		if err != nil {
+			files.add("config.error.txt", red.bytes([]byte(err.Error()+"\n")))
+		} else {
+			red.add(fc.Token)
+			files.addJSON("config.redacted.json", redactConfig(*fc))
+			rs, err := fc.recordConfigs(*base)
+			if err != nil {
+				files.add("config.error.txt", red.bytes([]byte(err.Error()+"\n")))
+			} else {
+				records = rs
+				base.StateDir = rs[0].StateDir
+			}
+		}
+	} else if base.Domain != "" && base.Name != "" {
+		base.Types = splitList(strings.ToUpper(base.Type))
+		records = []Config{*base}
+	}
+
+	files.add("version.txt", []byte(fmt.Sprintf("do-ddns %s\n%s %s/%s\ngenerated %s\n",
+		version, runtime.Version(), runtime.GOOS, runtime.GOARCH, time.Now().Format(time.RFC3339))))
+	files.add("env.txt", red.bytes(envSnapshot()))
+	files.add("logs.txt", red.bytes(recentLogs(*unit, *logFile, *logLines)))
+
+	// State: the state DB (which includes last_api_error) and last-IP files.
+	// The state DB is included as JSON whatever format it is stored in.
+	if db, err := viewStateDB(base.StateDir); err == nil {
+		b, _ := json.MarshalIndent(db, "", "  ")
+		files.add("state/do-ddns.state.json", red.bytes(b))
+	}
+	if b, err := os.ReadFile(inventoryPath(base.StateDir)); err == nil {
+		files.add("state/do-ddns.inventory.json", red.bytes(b))
+	}
+	lastIPs, _ := filepath.Glob(filepath.Join(base.StateDir, "do-ddns-*.last_ip"))
+	for _, p := range lastIPs {
+		if b, err := os.ReadFile(p); err == nil {
+			files.add("state/"+filepath.Base(p), b)
+		}
+	}
+
+	files.add("dns.txt", dnsReport(records, *doh))
+
+	if err := files.write(*out); err != nil {
+		return fmt.Errorf("support-bundle: %w", err)
+	}
+	logm(msgSupportWrote, *out, len(files))
+	return nil
+}
+
+// redactConfig strips secrets from a config file: the token, and the path
+// and query of notification URLs (webhook URLs embed credentials).
+func redactConfig(fc fileConfig) fileConfig {
+	if fc.Token != "" {
+		fc.Token = "REDACTED"
+	}
+	fc.Notify.URL = redactURL(fc.Notify.URL)
+	return fc
+}
+
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "REDACTED"
+	}
+	return u.Scheme + "://" + u.Host + "/REDACTED"
+}
+
+// redactor blanks out known secrets and anything shaped like a DigitalOcean
+// token.
+type redactor struct{ secrets []string }
+
+var doTokenPattern = regexp.MustCompile(`\bdo[opr]_v1_[0-9a-f]{16,}\b|(?i)bearer\s+\S+`)
+
+func newRedactor(secrets ...string) *redactor {
+	r := &redactor{}
+	for _, s := range secrets {
+		r.add(s)
+	}
+	return r
+}
+
+func (r *redactor) add(secret string) {
+	if s := strings.TrimSpace(secret); len(s) >= 8 && !slices.Contains(r.secrets, s) {
+		r.secrets = append(r.secrets, s)
+	}
+}
+
+func (r *redactor) bytes(b []byte) []byte {
+	for _, s := range r.secrets {
+		b = bytes.ReplaceAll(b, []byte(s), []byte("REDACTED"))
+	}
+	return doTokenPattern.ReplaceAll(b, []byte("REDACTED"))
+}
+
+// envSnapshot lists the do-ddns settings in the environment, secrets
+// redacted.
+func envSnapshot() []byte {
+	prefixes := []string{"DO_", "IP_SOURCE", "STATE_DIR", "PER_PAGE", "MAX_RETRIES", "DAEMON", "INTERVAL", "LISTEN", "EXPIRES_IN", "NOTIFY_", "CROSS_CHECK", "MAINTENANCE_"}
+	var lines []string
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(k, p) }) {
+			continue
+		}
+		switch k {
+		case "DO_TOKEN":
+			v = "REDACTED"
+		case "NOTIFY_URL":
+			v = redactURL(v)
+		}
+		lines = append(lines, k+"="+v)
+	}
+	slices.Sort(lines)
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func recentLogs(unit, logFile string, n int) []byte {
+	if logFile != "" {
+		b, err := os.ReadFile(logFile)
+		if err != nil {
+			return []byte(err.Error() + "\n")
+		}
+		lines := strings.SplitAfter(string(b), "\n")
+		return []byte(strings.Join(lines[max(0, len(lines)-n):], ""))
+	}
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return []byte("journalctl not available; use --log-file\n")
+	}
+	b, err := exec.Command("journalctl", "--no-pager", "-o", "short-iso", "-n", fmt.Sprint(n), "-u", unit).CombinedOutput()
+	if err != nil {
+		b = append(b, []byte("journalctl: "+err.Error()+"\n")...)
+	}
+	return b
+}
+
+// dnsReport looks every record up on DigitalOcean's nameserver, on the
+// system resolver and, if doh is set, over DNS-over-HTTPS.
+func dnsReport(records []Config, doh string) []byte {
+	var buf bytes.Buffer
+	if len(records) == 0 {
+		buf.WriteString("no records configured (pass --config or --domain/--name)\n")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	resolvers := []namedResolver{{doNameserver, authoritativeResolver()}, {"system", net.DefaultResolver}}
+	if doh != "" {
+		r, name, err := verifyResolver(doh)
+		if err != nil {
+			fmt.Fprintf(&buf, "%v\n", err)
+		} else {
+			resolvers = append(resolvers, namedResolver{name, r})
+		}
+	}
+	for _, c := range records {
+		host := fqdn(c.Name, c.Domain)
+		for _, t := range c.Types {
+			for _, res := range resolvers {
+				vals, err := lookupRecord(ctx, res.r, host, t)
+				if err != nil {
+					fmt.Fprintf(&buf, "%s %s @%s: error: %v\n", t, host, res.name, err)
+					continue
+				}
+				fmt.Fprintf(&buf, "%s %s @%s: %s\n", t, host, res.name, strings.Join(vals, ", "))
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+type namedResolver struct {
+	name string
+	r    *net.Resolver
+}
+
+// bundle is the list of files going into the archive.
+type bundle []bundleFile
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+func (b *bundle) add(name string, data []byte) {
+	*b = append(*b, bundleFile{name, data})
+}
+
+func (b *bundle) addJSON(name string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	b.add(name, append(data, '\n'))
+}
+
+func (b bundle) write(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	var errs []error
+	for _, file := range b {
+		hdr := &tar.Header{Name: "do-ddns-support/" + file.name, Mode: 0600, Size: int64(len(file.data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	errs = append(errs, tw.Close(), gz.Close(), f.Close())
+	return errors.Join(errs...)
+}
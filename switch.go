@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// activeTarget records which named target a record was switched to.
+type activeTarget struct {
+	Domain string    `json:"domain"`
+	Name   string    `json:"name"`
+	Target string    `json:"target"`
+	Since  time.Time `json:"since"`
+}
+
+// activeTargetName returns the target cfg should currently publish: the one
+// last switched to, else the config's default_target. Empty means plain
+// detection.
+func activeTargetName(cfg Config) string {
+	if len(cfg.Targets) == 0 {
+		return ""
+	}
+	db, err := viewStateDB(cfg.StateDir)
+	if err != nil {
+		warnf("%s", msg(msgStateReadFailed, err))
+	} else {
+		for _, a := range db.ActiveTargets {
+			if a.Domain == cfg.Domain && a.Name == cfg.Name {
+				if _, ok := cfg.Targets[a.Target]; ok {
+					return a.Target
+				}
+			}
+		}
+	}
+	return cfg.DefaultTarget
+}
+
+// resolveTarget turns a target definition into the value for cfg.Type. A
+// definition is a comma-separated list of literal values and/or IP source
+// URLs: for address records the first literal of the right family wins,
+// otherwise the URLs are used for detection.
+func resolveTarget(ctx context.Context, cfg Config, def string, det *ipDetector) (string, error) {
+	if !isAddressType(cfg.Type) {
+		return def, nil
+	}
+	var sources []string
+	for _, v := range splitList(def) {
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			sources = append(sources, v)
+			continue
+		}
+		if ip := net.ParseIP(v); ip != nil && (cfg.Type == "A") == (ip.To4() != nil) {
+			return ip.String(), nil
+		}
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("target has no %s address or IP source", cfg.Type)
+	}
+	return det.get(ctx, strings.Join(sources, ","), ipNetwork(cfg.Type))
+}
+
+type switchedRecord struct {
+	cfg      Config
+	id       int64
+	previous string
+	value    string
+	created  bool
+}
+
+// runSwitch implements `do-ddns switch --to NAME`.
+func runSwitch(args []string) error {
+	fs := flag.NewFlagSet("switch", flag.ExitOnError)
+	base := recordFlags(fs)
+	configPath := new(string)
+	configFlag(fs, configPath, "Config file defining the targets (or env DO_CONFIG)")
+	to := fs.String("to", "", "Name of the target to switch to")
+	dryRun := fs.Bool("dry-run", false, "Only print the changes that would be made")
+	verify := fs.Bool("verify-dns", false, "After switching, wait until DigitalOcean's nameserver (or --doh) serves the new value; roll back if it does not")
+	verifyTimeout := fs.Duration("verify-timeout", 60*time.Second, "How long --verify-dns waits")
+	doh := fs.String("doh", os.Getenv("DO_DOH"), "Verify over DNS-over-HTTPS instead of port 53: cloudflare, google or an https:// URL (or env DO_DOH)")
+	fs.Parse(args)
+
+	if *configPath == "" || *to == "" {
+		return withExitCode(2, errors.New("switch: --config and --to are required"))
+	}
+	resolver, _, err := verifyResolver(*doh)
+	if err != nil {
+		return withExitCode(2, fmt.Errorf("switch: %w", err))
+	}
+	fc, err := loadConfigFile(*configPath)
+	if err != nil {
+		return withExitCode(2, err)
+	}
+	// --domain/--name narrow the switch down to matching records.
+	domainFilter, nameFilter := base.Domain, base.Name
+	records, err := fc.recordConfigs(*base)
+	if err != nil {
+		return withExitCode(2, err)
+	}
+
+	var selected []Config
+	for _, c := range records {
+		if _, ok := c.Targets[*to]; !ok {
+			continue
+		}
+		if (domainFilter != "" && c.Domain != domainFilter) || (nameFilter != "" && c.Name != nameFilter) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	if len(selected) == 0 {
+		return withExitCode(2, fmt.Errorf("switch: no record defines a target named %q", *to))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	det := newIPDetector(selected[0].CrossCheck)
+	var done []switchedRecord
+	rollback := func(cause error) error {
+		if len(done) > 0 {
+			logm(msgSwitchRollback, len(done))
+			rollbackSwitch(ctx, done)
+		}
+		return cause
+	}
+
+	for _, c := range selected {
+		for _, t := range c.Types {
+			rc := c
+			rc.Type = t
+			sw, err := switchRecord(ctx, rc, *to, det, *dryRun)
+			if err != nil {
+				return rollback(err)
+			}
+			if sw != nil {
+				done = append(done, *sw)
+			}
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+
+	if *verify {
+		for _, sw := range done {
+			host := fqdn(sw.cfg.Name, sw.cfg.Domain)
+			if err := verifyDNS(ctx, resolver, host, sw.cfg.Type, sw.value, *verifyTimeout); err != nil {
+				return rollback(withExitCode(7, fmt.Errorf("switch: %w", err)))
+			}
+			logm(msgSwitchVerified, sw.cfg.Type, host, sw.value)
+		}
+	}
+
+	now := time.Now()
+	err = updateStateDB(selected[0].StateDir, func(db *stateDB) bool {
+		for _, c := range selected {
+			a := activeTarget{Domain: c.Domain, Name: c.Name, Target: *to, Since: now}
+			replaced := false
+			for i := range db.ActiveTargets {
+				if db.ActiveTargets[i].Domain == c.Domain && db.ActiveTargets[i].Name == c.Name {
+					db.ActiveTargets[i], replaced = a, true
+				}
+			}
+			if !replaced {
+				db.ActiveTargets = append(db.ActiveTargets, a)
+			}
+		}
+		sort.Slice(db.ActiveTargets, func(i, j int) bool {
+			return fqdn(db.ActiveTargets[i].Name, db.ActiveTargets[i].Domain) < fqdn(db.ActiveTargets[j].Name, db.ActiveTargets[j].Domain)
+		})
+		return true
+	})
+	if err != nil {
+		// Without the state entry the next run would switch back to the
+		// default target, so treat this as a failed switch.
+		return rollback(fmt.Errorf("switch: saving state: %w", err))
+	}
+	logm(msgSwitchDone, len(selected), *to)
+	return nil
+}
+
+// switchRecord points one record at the named target and confirms the API
+// now reports the new value. It returns nil if there was nothing to change.
+func switchRecord(ctx context.Context, cfg Config, to string, det *ipDetector, dryRun bool) (*switchedRecord, error) {
+	fq := fqdn(cfg.Name, cfg.Domain)
+	if m, ok := inMaintenance(cfg, recordKey(cfg.Domain, cfg.Name, cfg.Type)); ok {
+		return nil, fmt.Errorf("switch: %s %s is in maintenance (-> %s); turn maintenance off first", cfg.Type, fq, m.Target)
+	}
+	value, err := resolveTarget(ctx, cfg, cfg.Targets[to], det)
+	if err != nil {
+		return nil, withExitCode(3, fmt.Errorf("switch: %s %s: %w", cfg.Type, fq, err))
+	}
+
+	recs, err := listRecords(ctx, cfg, cfg.Name)
+	if err != nil {
+		return nil, withExitCode(4, fmt.Errorf("listing records: %w", err))
+	}
+	matches := matchingRecords(recs, cfg.Type, cfg.Name)
+
+	if len(matches) > 0 && sameData(cfg.Type, matches[0].Data, recordData(cfg.Type, value)) {
+		logm(msgSwitchAlready, cfg.Type, fq, to, value)
+		return nil, nil
+	}
+	if dryRun {
+		cur := "(missing)"
+		if len(matches) > 0 {
+			cur = matches[0].Data
+		}
+		logm(msgSwitchDryRun, cfg.Type, fq, cur, value, to)
+		return nil, nil
+	}
+
+	sw := &switchedRecord{cfg: cfg, value: value}
+	if len(matches) == 0 {
+		created, err := createRecord(ctx, cfg, recordData(cfg.Type, value))
+		if err != nil {
+			return nil, withExitCode(5, fmt.Errorf("create record: %w", err))
+		}
+		sw.id, sw.created = created.ID, true
+	} else {
+		sw.id, sw.previous = matches[0].ID, matches[0].Data
+		if err := updateRecord(ctx, cfg, sw.id, recordData(cfg.Type, value)); err != nil {
+			return nil, withExitCode(6, fmt.Errorf("update record id=%d: %w", sw.id, err))
+		}
+	}
+
+	got, err := getRecord(ctx, cfg, sw.id)
+	if err != nil || !sameData(cfg.Type, got.Data, recordData(cfg.Type, value)) {
+		if err == nil {
+			err = fmt.Errorf("API reports %q", got.Data)
+		}
+		rollbackSwitch(ctx, []switchedRecord{*sw})
+		return nil, withExitCode(7, fmt.Errorf("switch: verifying %s %s: %w", cfg.Type, fq, err))
+	}
+	logm(msgSwitched, cfg.Type, fq, value, to, orNone(sw.previous))
+	return sw, nil
+}
+
+// rollbackSwitch restores switched records to their previous values, in
+// reverse order. Failures are logged; there is nothing better to do.
+func rollbackSwitch(ctx context.Context, done []switchedRecord) {
+	for i := len(done) - 1; i >= 0; i-- {
+		sw := done[i]
+		fq := fqdn(sw.cfg.Name, sw.cfg.Domain)
+		var err error
+		if sw.created {
+			err = deleteRecord(ctx, sw.cfg, sw.id)
+		} else {
+			err = updateRecord(ctx, sw.cfg, sw.id, sw.previous)
+		}
+		if err != nil {
+			logf("ERROR: %s", msg(msgSwitchRollbackFailed, sw.cfg.Type, fq, sw.id, err))
+			continue
+		}
+		logm(msgSwitchRolledBack, sw.cfg.Type, fq, orNone(sw.previous))
+	}
+}
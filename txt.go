@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTXTString is the longest character-string a TXT record can carry
+// (RFC 1035 §3.3). Longer values must be split into several strings, which
+// resolvers concatenate back together.
+const maxTXTString = 255
+
+// formatTXT returns the record data to send for a TXT value. Values that fit
+// in a single character-string are sent as-is; longer ones (DKIM keys, ...)
+// are split into quoted 255-byte chunks: "chunk1" "chunk2".
+func formatTXT(value string) string {
+	if len(value) <= maxTXTString {
+		return value
+	}
+	var parts []string
+	for _, c := range txtChunks(value) {
+		parts = append(parts, `"`+txtEscaper.Replace(c)+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+var txtEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// txtChunks splits value into pieces of at most maxTXTString bytes without
+// cutting a UTF-8 sequence in half.
+func txtChunks(value string) []string {
+	var out []string
+	for len(value) > maxTXTString {
+		cut := maxTXTString
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+		out = append(out, value[:cut])
+		value = value[cut:]
+	}
+	return append(out, value)
+}
+
+// parseTXT rejoins record data made of quoted character-strings into the
+// single logical value. Unquoted data is returned unchanged.
+func parseTXT(data string) string {
+	s := strings.TrimSpace(data)
+	if !strings.HasPrefix(s, `"`) {
+		return data
+	}
+
+	var b strings.Builder
+	for len(s) > 0 {
+		if s[0] != '"' {
+			// Not a well-formed sequence of quoted strings; compare verbatim.
+			return data
+		}
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return data
+		}
+		s = strings.TrimLeft(s[i+1:], " \t")
+	}
+	return b.String()
+}
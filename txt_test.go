@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTXTChunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []int // chunk lengths in bytes
+	}{
+		{"short", "v=spf1 -all", []int{11}},
+		{"exactly one string", strings.Repeat("a", 255), []int{255}},
+		{"one byte over", strings.Repeat("a", 256), []int{255, 1}},
+		// "é" is two bytes at offsets 254 and 255: it moves to the next chunk.
+		{"rune on the boundary", strings.Repeat("a", 254) + "é" + "b", []int{254, 3}},
+		// "€" is three bytes at offsets 253 to 255.
+		{"three-byte rune on the boundary", strings.Repeat("a", 253) + "€", []int{253, 3}},
+		{"rune ending on the boundary", strings.Repeat("a", 253) + "é" + "b", []int{255, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := txtChunks(tt.value)
+			var lens []int
+			for _, c := range chunks {
+				lens = append(lens, len(c))
+				if !utf8.ValidString(c) {
+					t.Errorf("chunk %q is not valid UTF-8", c)
+				}
+			}
+			if strings.Join(chunks, "") != tt.value || !slices.Equal(lens, tt.want) {
+				t.Errorf("chunk lengths %v, want %v", lens, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatParseTXT(t *testing.T) {
+	long := strings.Repeat("k", 300)
+	tests := []struct {
+		name  string
+		value string
+		want  string // formatTXT(value)
+	}{
+		{"short is sent as-is", `say "hi" \o/`, `say "hi" \o/`},
+		{"long is split", long, `"` + long[:255] + `" "` + long[255:] + `"`},
+		{"quotes and backslashes are escaped", strings.Repeat("q", 254) + `"\`,
+			`"` + strings.Repeat("q", 254) + `\"" "\\"`},
+		{"multibyte rune on the boundary", strings.Repeat("a", 254) + "ü tail",
+			`"` + strings.Repeat("a", 254) + `" "ü tail"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatTXT(tt.value)
+			if got != tt.want {
+				t.Errorf("formatTXT = %q, want %q", got, tt.want)
+			}
+			if len(tt.value) > maxTXTString && parseTXT(got) != tt.value {
+				t.Errorf("parseTXT(formatTXT(v)) = %q, want %q", parseTXT(got), tt.value)
+			}
+			// What DigitalOcean returns compares equal to the desired value,
+			// whether it kept the chunks or joined them.
+			if !sameData("TXT", got, tt.value) || !sameData("TXT", got, formatTXT(tt.value)) {
+				t.Errorf("sameData(%q, %q) = false", got, tt.value)
+			}
+			if sameData("TXT", got, tt.value+"x") {
+				t.Errorf("sameData(%q, %q) = true", got, tt.value+"x")
+			}
+		})
+	}
+}
+
+func TestParseTXT(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`"abc"`, "abc"},
+		{`"ab" "cd"`, "abcd"},
+		{"\"ab\"\t\"cd\"", "abcd"},
+		{`"a\"b" "c\\d"`, `a"bc\d`},
+		{`"a\;b"`, "a;b"},
+		{"plain text", "plain text"},
+		{`"unterminated`, `"unterminated`},
+		{`"ab" cd`, `"ab" cd`},
+	}
+	for _, tt := range tests {
+		if got := parseTXT(tt.in); got != tt.want {
+			t.Errorf("parseTXT(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
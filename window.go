@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// changeWindow is a daily local-time span, e.g. 02:00-05:00, outside which
+// only urgent changes are made. Changes that keep the name reachable are
+// urgent: a missing record is created, a wrong value is fixed, and
+// duplicates holding another value are deleted (with cleanup enabled)
+// right away. TTL corrections and the cleanup of duplicates that merely
+// repeat the right value wait for the window. A window may wrap past
+// midnight (22:00-02:00).
+type changeWindow struct {
+	start, end int // minutes after midnight
+}
+
+func parseChangeWindow(s string) (*changeWindow, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("change window %q: want HH:MM-HH:MM", s)
+	}
+	var w changeWindow
+	for _, p := range []struct {
+		s   string
+		dst *int
+	}{{from, &w.start}, {to, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(p.s))
+		if err != nil {
+			return nil, fmt.Errorf("change window %q: want HH:MM-HH:MM", s)
+		}
+		*p.dst = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("change window %q is empty", s)
+	}
+	return &w, nil
+}
+
+// contains reports whether t (in local time) falls inside the window.
+func (w *changeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+func (w *changeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// staleDuplicates reports whether any of dups holds a value other than want:
+// the name then also answers with an address that may not reach the host,
+// so deleting them cannot wait for the change window.
+func staleDuplicates(recordType string, dups []DomainRecord, want string) bool {
+	for _, d := range dups {
+		if !sameData(recordType, d.Data, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferChange postpones a non-urgent change until the record's change window
+// opens, and reports whether it did. The record's last-IP file is removed
+// so the next run reconciles it again instead of trusting the cache.
+func deferChange(cfg Config, res *runResult, change string) bool {
+	if cfg.ChangeWindow == nil || cfg.ChangeWindow.contains(time.Now()) {
+		return false
+	}
+	logm(msgChangeDeferred, change, cfg.Type, cfg.Name, cfg.Domain, cfg.ChangeWindow)
+	res.Deferred = append(res.Deferred, change)
+	if err := clearLastIP(stateFile(cfg)); err != nil {
+		warnf("%s", msg(msgStateWriteFailed, err))
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openWindow and closedWindow return change windows that do and do not
+// contain the current time.
+func openWindow() *changeWindow {
+	m := time.Now().Hour()*60 + time.Now().Minute()
+	return &changeWindow{start: (m + 1380) % 1440, end: (m + 60) % 1440}
+}
+
+func closedWindow() *changeWindow {
+	m := time.Now().Hour()*60 + time.Now().Minute()
+	return &changeWindow{start: (m + 120) % 1440, end: (m + 180) % 1440}
+}
+
+func TestChangeWindowReconcile(t *testing.T) {
+	const ip = "203.0.113.7"
+	tests := []struct {
+		name        string
+		window      *changeWindow
+		create      int
+		records     []DomainRecord
+		wantAction  string
+		wantDefer   []string
+		wantDeleted []int64
+		wantTTL     int
+	}{
+		{name: "ttl drift, no window", records: []DomainRecord{{ID: 1, TTL: 3600, Data: ip}},
+			wantAction: "updated", wantTTL: 300},
+		{name: "create ttl is not drift", create: 3600, records: []DomainRecord{{ID: 1, TTL: 3600, Data: ip}},
+			wantAction: "unchanged", wantTTL: 3600},
+		{name: "ttl drift, window open", window: openWindow(), records: []DomainRecord{{ID: 1, TTL: 3600, Data: ip}},
+			wantAction: "updated", wantTTL: 300},
+		{name: "ttl drift, window closed", window: closedWindow(), records: []DomainRecord{{ID: 1, TTL: 3600, Data: ip}},
+			wantAction: "unchanged", wantDefer: []string{"ttl"}, wantTTL: 3600},
+		{name: "wrong value, window closed", window: closedWindow(), records: []DomainRecord{{ID: 1, TTL: 3600, Data: "192.0.2.1"}},
+			wantAction: "updated", wantTTL: 300},
+		{name: "duplicate of the right value, window closed", window: closedWindow(), records: []DomainRecord{{ID: 1, TTL: 300, Data: ip}, {ID: 2, TTL: 300, Data: ip}},
+			wantAction: "unchanged", wantDefer: []string{"cleanup"}, wantTTL: 300},
+		{name: "stale duplicate, window closed", window: closedWindow(), records: []DomainRecord{{ID: 1, TTL: 300, Data: ip}, {ID: 2, TTL: 300, Data: "192.0.2.1"}},
+			wantAction: "unchanged", wantDeleted: []int64{2}, wantTTL: 300},
+		{name: "stale duplicate after an update, window closed", window: closedWindow(), records: []DomainRecord{{ID: 1, TTL: 300, Data: "192.0.2.1"}, {ID: 2, TTL: 300, Data: "192.0.2.1"}},
+			wantAction: "updated", wantDeleted: []int64{2}, wantTTL: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &zone{}
+			for _, r := range tt.records {
+				r.Type, r.Name = "A", "hq"
+				z.records = append(z.records, r)
+			}
+			fakeAPI(t, z)
+			cfg := testConfig()
+			cfg.Name, cfg.Type, cfg.Types, cfg.TTL = "hq", "A", []string{"A"}, 300
+			cfg.StateDir, cfg.CleanupDuplicates, cfg.ChangeWindow, cfg.CreateTTL = t.TempDir(), true, tt.window, tt.create
+
+			res := runResult{Domain: cfg.Domain, Name: cfg.Name, Type: cfg.Type}
+			if err := reconcile(context.Background(), cfg, slices.Clone(z.records), ip, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Action != tt.wantAction || !slices.Equal(res.Deferred, tt.wantDefer) {
+				t.Errorf("action %q, deferred %q; want %q, %q", res.Action, res.Deferred, tt.wantAction, tt.wantDefer)
+			}
+			var deleted []int64
+			for _, d := range res.Deleted {
+				deleted = append(deleted, d.ID)
+			}
+			if !slices.Equal(deleted, tt.wantDeleted) {
+				t.Errorf("deleted %v, want %v", deleted, tt.wantDeleted)
+			}
+			z.mu.Lock()
+			defer z.mu.Unlock()
+			if z.records[0].Data != ip || z.records[0].TTL != tt.wantTTL {
+				t.Errorf("record = %+v, want %s with TTL %d", z.records[0], ip, tt.wantTTL)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseYAML parses the subset of YAML used by config files: block mappings,
+// block sequences (including "- key: value" and "- - x" items), flow
+// sequences of scalars ([a, b]), quoted and plain scalars, and # comments.
+// Double-quoted scalars use YAML's escapes (see unquoteYAML). Anchors, tags,
+// flow mappings and multi-line scalars (so also line folding) are not
+// supported.
+//
+// The result is built from map[string]any, []any, string, int64, float64,
+// bool and nil, so it can be round-tripped through encoding/json into a
+// tagged struct.
+func parseYAML(src string) (any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(src, "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		if lead := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]; strings.Contains(lead, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		text := strings.TrimSpace(stripYAMLComment(raw))
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{
+			num:    i + 1,
+			indent: len(raw) - len(strings.TrimLeft(raw, " ")),
+			text:   text,
+		})
+	}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) block(indent int) (any, error) {
+	if isSeqItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	out := []any{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent || (l.indent == indent && !isSeqItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+
+		rest := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		switch {
+		case rest == "":
+			p.i++
+			if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+				out = append(out, nil)
+				continue
+			}
+			v, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		case isSeqItem(rest):
+			// "- - x": the item is a sequence starting on the same line.
+			p.lines[p.i] = yamlLine{num: l.num, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err := p.sequence(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		case isMappingLine(rest):
+			// "- key: value": the item is a mapping whose keys line up with
+			// the first key.
+			p.lines[p.i] = yamlLine{num: l.num, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err := p.mapping(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		default:
+			v, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", l.num, err)
+			}
+			out = append(out, v)
+			p.i++
+		}
+	}
+	return out, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	out := map[string]any{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if isSeqItem(l.text) {
+			break
+		}
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.i++
+
+		if rest != "" {
+			v, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", l.num, err)
+			}
+			out[key] = v
+			continue
+		}
+
+		// Nested block: deeper indentation, or a sequence at the same level
+		// ("key:\n- a\n- b").
+		switch {
+		case p.i < len(p.lines) && p.lines[p.i].indent > indent:
+			v, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = v
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text):
+			v, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = v
+		default:
+			out[key] = nil
+		}
+	}
+	return out, nil
+}
+
+func isMappingLine(text string) bool {
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// splitYAMLKey splits "key: value" (key optionally quoted) outside quotes.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		after := text[end+2:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false
+		}
+		return text[1 : end+1], strings.TrimSpace(after[1:]), true
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	if strings.HasSuffix(text, ":") {
+		return text[:len(text)-1], "", true
+	}
+	i := strings.Index(text, ": ")
+	if i <= 0 {
+		return "", "", false
+	}
+	return text[:i], strings.TrimSpace(text[i+2:]), true
+}
+
+func parseYAMLScalar(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence %q", s)
+		}
+		out := []any{}
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			v, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case strings.HasPrefix(s, "{"):
+		return nil, fmt.Errorf("flow mappings are not supported: %q", s)
+	case strings.HasPrefix(s, `"`):
+		v, err := unquoteYAML(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad double-quoted string %s: %w", s, err)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("bad single-quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strings.ContainsAny(s, ".eE") {
+		return f, nil
+	}
+	return s, nil
+}
+
+// yamlEscapes are the single-character escapes of double-quoted scalars.
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+	'r': "\r", 'e': "\x1b", ' ': " ", '"': `"`, '/': "/", '\\': `\`,
+	'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// unquoteYAML decodes a double-quoted scalar. YAML's escapes (YAML 1.2
+// §5.7) are not Go's: \x, \u and \U all give a Unicode code point (\xe9
+// is é, not a byte), and there are \/, \e, \N, \_, \L, \P and "\ ", but no
+// \'.
+func unquoteYAML(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != '"' {
+		return "", errors.New("unterminated")
+	}
+	body := s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; c {
+		case '"':
+			return "", errors.New("unescaped \" inside")
+		case '\\':
+		default:
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(body) {
+			return "", errors.New("unterminated")
+		}
+		e := body[i]
+		if r, ok := yamlEscapes[e]; ok {
+			b.WriteString(r)
+			continue
+		}
+		n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+		if n == 0 {
+			return "", fmt.Errorf("unknown escape \\%c", e)
+		}
+		if i+1+n > len(body) {
+			return "", fmt.Errorf("short \\%c escape", e)
+		}
+		code, err := strconv.ParseUint(body[i+1:i+1+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return "", fmt.Errorf("bad \\%c escape %q", e, body[i+1:i+1+n])
+		}
+		b.WriteRune(rune(code))
+		i += n
+	}
+	return b.String(), nil
+}
+
+// quoteEnd reports whether s[i] closes the quote opened with quote, and how
+// many bytes to skip for an escape inside it: a backslash escape in double
+// quotes, a doubled single quote in single ones.
+func quoteEnd(s string, i int, quote byte) (end bool, skip int) {
+	switch {
+	case quote == '"' && s[i] == '\\':
+		return false, 1
+	case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+		return false, 1
+	}
+	return s[i] == quote, 0
+}
+
+// splitFlow splits the inside of a flow sequence on commas outside quotes.
+func splitFlow(s string) []string {
+	var out []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			end, skip := quoteEnd(s, i, quote)
+			if end {
+				quote = 0
+			}
+			i += skip
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			out = append(out, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(out) > 0 {
+		out = append(out, last)
+	}
+	return out
+}
+
+// stripYAMLComment removes a trailing "# comment" that is outside quotes and
+// either starts the line or follows whitespace.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			end, skip := quoteEnd(line, i, quote)
+			if end {
+				quote = 0
+			}
+			i += skip
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" :[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
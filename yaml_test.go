@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	type m = map[string]any
+	type l = []any
+	tests := []struct {
+		name    string
+		src     string
+		want    any
+		wantErr string
+	}{
+		{name: "empty", src: "# nothing\n---\n", want: m{}},
+		{name: "scalars", src: "s: plain text\ni: 42\nneg: -7\nf: 1.5\nb: true\nB: FALSE\nn: ~\nnull: null\nver: 1.2.3\nempty:",
+			want: m{"s": "plain text", "i": int64(42), "neg": int64(-7), "f": 1.5, "b": true, "B": false, "n": nil, "null": nil, "ver": "1.2.3", "empty": nil}},
+		{name: "nested mappings", src: "notify:\n  url: https://x\n  retry:\n    max: 3\ntop: 1",
+			want: m{"notify": m{"url": "https://x", "retry": m{"max": int64(3)}}, "top": int64(1)}},
+		{name: "nested block sequences", src: "a:\n  -\n    - x\n    - y\n  - z\n  - - p\n    - - q\n  -",
+			want: m{"a": l{l{"x", "y"}, "z", l{"p", l{"q"}}, nil}}},
+		{name: "key: value items", src: "records:\n  - domain: example.com\n    name: hq\n    type: [A, AAAA]\n  - name: www\n    targets:\n      blue: 192.0.2.1\n  - plain",
+			want: m{"records": l{
+				m{"domain": "example.com", "name": "hq", "type": l{"A", "AAAA"}},
+				m{"name": "www", "targets": m{"blue": "192.0.2.1"}},
+				"plain",
+			}}},
+		{name: "key then same-indent sequence", src: "records:\n- name: a\n  ttl: 60\n- name: b\nafter: x",
+			want: m{"records": l{m{"name": "a", "ttl": int64(60)}, m{"name": "b"}}, "after": "x"}},
+		{name: "top-level sequence", src: "- 1\n- two", want: l{int64(1), "two"}},
+		{name: "flow lists", src: `a: [x, "y, z", 'it''s', 3, true, ~]` + "\nb: []\nc: [ spaced ,  items ]",
+			want: m{"a": l{"x", "y, z", "it's", int64(3), true, nil}, "b": l{}, "c": l{"spaced", "items"}}},
+		{name: "comments", src: "# header\na: 1 # trailing\nb: x#not-a-comment\n  # indented comment\nc: 2",
+			want: m{"a": int64(1), "b": "x#not-a-comment", "c": int64(2)}},
+		{name: "comments inside quotes", src: `a: "x # y" # real` + "\n" + `b: 'it''s # here'` + "\n" + `c: "q \" # z"` + "\n" + `d: ["#1", '# 2'] # c`,
+			want: m{"a": "x # y", "b": "it's # here", "c": `q " # z`, "d": l{"#1", "# 2"}}},
+		{name: "quoted keys", src: `"a: b": 1` + "\n'c': 2", want: m{"a: b": int64(1), "c": int64(2)}},
+		{name: "single quotes", src: `a: 'no \escapes'`, want: m{"a": `no \escapes`}},
+		{name: "yaml escapes", src: `a: "tab\tnl\n\\ \"q\" \/ \e \x41\xe9 \u00e9\U0001F600 \N\_\L\P\ \0"`,
+			want: m{"a": "tab\tnl\n\\ \"q\" / \x1b A\u00e9 \u00e9\U0001F600 \u0085\u00a0\u2028\u2029 \x00"}},
+		{name: "windows line endings", src: "a: 1\r\nb: 2\r\n", want: m{"a": int64(1), "b": int64(2)}},
+
+		{name: "duplicate keys", src: "a: 1\nb: 2\na: 3", wantErr: `line 3: duplicate key "a"`},
+		{name: "duplicate nested keys", src: "n:\n  x: 1\n  x: 2", wantErr: `line 3: duplicate key "x"`},
+		{name: "tab indentation", src: "a:\n\tb: 1", wantErr: "line 2: tabs are not allowed"},
+		{name: "tab after spaces", src: "a:\n  \tb: 1", wantErr: "line 2: tabs are not allowed"},
+		{name: "unexpected indentation", src: "a: 1\n  b: 2", wantErr: "line 2: unexpected indentation"},
+		{name: "not a mapping", src: "a: 1\njust text", wantErr: `line 2: expected "key: value"`},
+		{name: "flow mapping", src: "a: {b: 1}", wantErr: "flow mappings are not supported"},
+		{name: "unterminated flow list", src: "a: [1, 2", wantErr: "unterminated flow sequence"},
+		{name: "go-only escape", src: `a: "it\'s"`, wantErr: `unknown escape \'`},
+		{name: "bad hex escape", src: `a: "\xZZ"`, wantErr: `bad \x escape`},
+		{name: "short unicode escape", src: `a: "\u00e"`, wantErr: `short \u escape`},
+		{name: "surrogate escape", src: `a: "\ud800"`, wantErr: `bad \u escape`},
+		{name: "text after closing quote", src: `a: "x" y`, wantErr: "bad double-quoted string"},
+		{name: "unterminated double quote", src: `a: "x`, wantErr: "bad double-quoted string"},
+		{name: "unterminated single quote", src: `a: 'x`, wantErr: "bad single-quoted string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q (got %#v)", err, tt.wantErr, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The example config must keep parsing into the config structs.
+func TestExampleConfig(t *testing.T) {
+	fc, err := loadConfigFile("do-ddns.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fc.Records) == 0 {
+		t.Error("no records in the example config")
+	}
+}